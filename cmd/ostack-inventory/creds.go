@@ -0,0 +1,224 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	creds.go
+	Abstract:	Credential gathering for ostack-inventory. Credentials are taken from the
+				usual OS_* environment variables and are then overlaid with the information
+				for the named cloud from a clouds.yaml file (if a cloud is named with -cloud
+				or OS_CLOUD).  Command line options override both; the precedence is
+				command line, then clouds.yaml, then the environment.
+
+				Only the subset of yaml that is used by clouds.yaml (nested maps with scalar
+				values) is understood; lists are ignored.
+	Date:		19 October 2026
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type creds struct {
+	url		string
+	user	string
+	passwd	string
+	project	string
+	region	string
+	version	int				// identity version 2 or 3
+}
+
+/*
+	Build creds from the environment.
+*/
+func env_creds( ) ( c *creds ) {
+	c = &creds {
+		url:	os.Getenv( "OS_AUTH_URL" ),
+		user:	os.Getenv( "OS_USERNAME" ),
+		passwd:	os.Getenv( "OS_PASSWORD" ),
+		project: os.Getenv( "OS_PROJECT_NAME" ),
+		region: os.Getenv( "OS_REGION_NAME" ),
+		version: 2,
+	}
+
+	if c.project == "" {
+		c.project = os.Getenv( "OS_TENANT_NAME" )
+	}
+	c.set_version( os.Getenv( "OS_IDENTITY_API_VERSION" ) )
+
+	return
+}
+
+/*
+	Set the version from a string like "3" or "2.0"; unrecognised values leave it unchanged.
+*/
+func (c *creds) set_version( v string ) {
+	if f, err := strconv.ParseFloat( v, 64 ); err == nil && (int( f ) == 2 || int( f ) == 3) {
+		c.version = int( f )
+	}
+}
+
+/*
+	Return the list of places we look for clouds.yaml; OS_CLIENT_CONFIG_FILE first if set.
+*/
+func clouds_paths( ) ( paths []string ) {
+	if f := os.Getenv( "OS_CLIENT_CONFIG_FILE" ); f != "" {
+		paths = append( paths, f )
+	}
+
+	paths = append( paths, "clouds.yaml" )
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append( paths, filepath.Join( home, ".config", "openstack", "clouds.yaml" ) )
+	}
+	paths = append( paths, "/etc/openstack/clouds.yaml" )
+
+	return
+}
+
+/*
+	Find the named cloud in the first clouds.yaml file that has it and overlay the creds
+	with the information found.
+*/
+func (c *creds) add_cloud( cloud string ) ( err error ) {
+	for _, p := range clouds_paths() {
+		data, rerr := ioutil.ReadFile( p )
+		if rerr != nil {
+			continue
+		}
+
+		y := parse_yaml( data )
+		cm, ok := ymap( y, "clouds", cloud )
+		if ! ok {
+			continue
+		}
+
+		auth, _ := ymap( cm, "auth" )
+		set_if( &c.url, auth["auth_url"] )
+		set_if( &c.user, auth["username"] )
+		set_if( &c.passwd, auth["password"] )
+		set_if( &c.project, auth["tenant_name"] )
+		set_if( &c.project, auth["project_name"] )
+		set_if( &c.region, cm["region_name"] )
+		if v, ok := cm["identity_api_version"].( string ); ok {
+			c.set_version( v )
+		} else {
+			if strings.Contains( c.url, "/v3" ) {
+				c.version = 3
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf( "cloud %q not found in any of: %s", cloud, strings.Join( clouds_paths(), " " ) )
+}
+
+/*
+	Set the target string if the value is a non-empty string.
+*/
+func set_if( target *string, v interface{} ) {
+	if s, ok := v.( string ); ok && s != "" {
+		*target = s
+	}
+}
+
+/*
+	Walk the nested maps using the keys; returns the map at the end of the path
+	and true if it exists.
+*/
+func ymap( m map[string]interface{}, keys ...string ) ( map[string]interface{}, bool ) {
+	for _, k := range keys {
+		next, ok := m[k].( map[string]interface{} )
+		if ! ok {
+			return map[string]interface{}{}, false
+		}
+		m = next
+	}
+
+	return m, true
+}
+
+/*
+	Parse enough yaml to deal with clouds.yaml: nested maps, with scalar values, using
+	indentation for nesting.  Comments, document markers and list entries are skipped.
+	Quotes around values are removed.
+*/
+func parse_yaml( data []byte ) ( root map[string]interface{} ) {
+	type level struct {
+		indent	int
+		m		map[string]interface{}
+	}
+
+	root = make( map[string]interface{} )
+	stack := []level{ { -1, root } }
+
+	scanner := bufio.NewScanner( bytes.NewReader( data ) )
+	for scanner.Scan() {
+		line := strings.TrimRight( scanner.Text(), " \t\r" )
+		trimmed := strings.TrimLeft( line, " " )
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" || strings.HasPrefix( trimmed, "- " ) || trimmed == "-" {
+			continue
+		}
+		indent := len( line ) - len( trimmed )
+
+		for len( stack ) > 1 && stack[len( stack )-1].indent >= indent {
+			stack = stack[:len( stack )-1]
+		}
+
+		ci := strings.Index( trimmed, ":" )
+		if ci < 0 {
+			continue
+		}
+		key := unquote( strings.TrimSpace( trimmed[:ci] ) )
+		val := strings.TrimSpace( trimmed[ci+1:] )
+		if hi := strings.Index( val, " #" ); hi >= 0 && val[0] != '"' && val[0] != '\'' {
+			val = strings.TrimSpace( val[:hi] )
+		}
+
+		cur := stack[len( stack )-1].m
+		if val == "" {
+			nm := make( map[string]interface{} )
+			cur[key] = nm
+			stack = append( stack, level{ indent, nm } )
+		} else {
+			cur[key] = unquote( val )
+		}
+	}
+
+	return
+}
+
+/*
+	Strip matching single or double quotes.
+*/
+func unquote( s string ) ( string ) {
+	if len( s ) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len( s )-1] == s[0] {
+		return s[1:len( s )-1]
+	}
+
+	return s
+}
@@ -0,0 +1,104 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	inventory_test.go
+	Abstract:	Tests the clouds.yaml parsing and the table output/filtering; the
+				parts of the command which don't need openstack.
+	Date:		19 October 2026
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const clouds_yaml = `
+# sample
+clouds:
+  lab:
+    auth:
+      auth_url: "https://keystone.example.com:5000/v3"
+      username: 'admin'
+      password: secret   # trailing comment
+      project_name: ops
+    region_name: RegionTwo
+    interface: internal
+  other:
+    auth:
+      auth_url: http://other:5000/v2.0
+`
+
+func TestClouds( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "inventory" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	fname := filepath.Join( dir, "clouds.yaml" )
+	ioutil.WriteFile( fname, []byte( clouds_yaml ), 0600 )
+	os.Setenv( "OS_CLIENT_CONFIG_FILE", fname )
+
+	c := &creds{ version: 2, user: "env-user" }
+	if err = c.add_cloud( "lab" ); err != nil {
+		t.Fatalf( "lab cloud not found: %s", err )
+	}
+
+	if c.url != "https://keystone.example.com:5000/v3" || c.user != "admin" || c.passwd != "secret" || c.project != "ops" || c.region != "RegionTwo" || c.version != 3 {
+		t.Errorf( "unexpected creds: %+v", c )
+	}
+
+	if err = c.add_cloud( "missing" ); err == nil {
+		t.Errorf( "expected error for missing cloud" )
+	}
+}
+
+func TestTable( t *testing.T ) {
+	net1 := "id1 physnet1 vlan 100"
+	net2 := "id2 physnet2 vxlan 200"
+	tab := mk_table( "network", "id", "phys_net", "type", "seg_id" )
+	tab.add_map( map[string]*string{ "net-b": &net2, "net-a": &net1 } )
+	tab.sort()
+
+	flist, err := tab.mk_filters( "type=vlan" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	tab.apply( flist )
+
+	out := bytes.NewBufferString( "" )
+	tab.write( out, "csv" )
+	if out.String() != "network,id,phys_net,type,seg_id\nnet-a,id1,physnet1,vlan,100\n" {
+		t.Errorf( "unexpected csv output: %q", out.String() )
+	}
+
+	if _, err = tab.mk_filters( "bogus=x" ); err == nil {
+		t.Errorf( "expected error for unknown filter column" )
+	}
+
+	if v := split_value( "my subnet tenant 10.0.0.0/24 10.0.0.1", 4 ); len( v ) != 4 || v[0] != "my subnet" {
+		t.Errorf( "split of value with blank in the name failed: %q", v )
+	}
+}
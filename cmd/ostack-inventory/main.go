@@ -0,0 +1,410 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ostack-inventory
+	Abstract:	Command line tool which prints the information that the ostack package
+				can dig out of openstack: any of the Mk_* maps, endpoints, hosts, gateways
+				and information about the token.  Output is an aligned table, json or csv
+				and can be filtered.
+
+				Usage:
+					ostack-inventory [options] maps name [name...]
+					ostack-inventory [options] maps			(lists the map names)
+					ostack-inventory [options] endpoints
+					ostack-inventory [options] hosts [compute|l3|network|...]
+					ostack-inventory [options] gateways
+					ostack-inventory [options] token
+
+				Credentials are taken from the OS_* environment variables; values for the
+				cloud named in clouds.yaml (-cloud or OS_CLOUD) replace those, and the
+				command line options replace both (command line wins, environment loses).
+	Date:		19 October 2026
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/att/gopkgs/ostack"
+)
+
+/*
+	Describes a map that can be printed. Fn returns the map; cols are the column names
+	where the first is the key.
+*/
+type map_desc struct {
+	fn		func( o *ostack.Ostack, inc_tenant bool ) ( map[string]*string, error )
+	cols	[]string
+}
+
+/*
+	Gateway maps all come from one call; pick out the one wanted.
+*/
+func gw_map( which int ) ( func( *ostack.Ostack, bool ) ( map[string]*string, error ) ) {
+	return func( o *ostack.Ostack, inc_tenant bool ) ( map[string]*string, error ) {
+		m0, m1, m2, m3, m4, m5, err := o.Mk_gwmaps( nil, nil, nil, nil, nil, nil, inc_tenant, true )
+		return []map[string]*string{ m0, m1, m2, m3, m4, m5 }[which], err
+	}
+}
+
+/*
+	Wrap a single Mk_* function which takes a default map.
+*/
+func mk_fn( f func( o *ostack.Ostack ) ( map[string]*string, error ) ) ( func( *ostack.Ostack, bool ) ( map[string]*string, error ) ) {
+	return func( o *ostack.Ostack, inc_tenant bool ) ( map[string]*string, error ) {
+		return f( o )
+	}
+}
+
+var maps = map[string]*map_desc {
+	"vm2ip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vm2ip( nil ) } ), []string{ "vm", "ip" } },
+	"vm2tip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vm2tip( nil ) } ), []string{ "vm", "tip" } },
+	"ip2vmid":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_ip2vmid( nil ) } ), []string{ "ip", "vmid" } },
+	"tip2vmid":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_tip2vmid( nil ) } ), []string{ "tip", "vmid" } },
+	"vmid2ip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmid2ip( nil ) } ), []string{ "vmid", "ip" } },
+	"vmid2tip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmid2tip( nil ) } ), []string{ "vmid", "tip" } },
+	"ip2vm":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_ip2vm( nil ) } ), []string{ "ip", "vm" } },
+	"tip2vm":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_tip2vm( nil ) } ), []string{ "tip", "vm" } },
+	"vmname2vmid":	{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmname2vmid( nil ) } ), []string{ "vmname", "vmid" } },
+	"vmtname2vmid":	{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmtname2vmid( nil ) } ), []string{ "vmtname", "vmid" } },
+	"vmid2vmname":	{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmid2vmname( nil ) } ), []string{ "vmid", "vmname" } },
+	"vmid2vmtname":	{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmid2vmtname( nil ) } ), []string{ "vmid", "vmtname" } },
+	"vmid2mac":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_vmid2mac( nil ) } ), []string{ "vmid", "mac" } },
+	"mac2ip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_mac2ip( nil ) } ), []string{ "mac", "ip" } },
+	"mac2tip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_mac2tip( nil ) } ), []string{ "mac", "tip" } },
+	"ip2mac":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_ip2mac( nil ) } ), []string{ "ip", "mac" } },
+	"tip2mac":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_tip2mac( nil ) } ), []string{ "tip", "mac" } },
+	"ip2fip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_ip2fip( nil ) } ), []string{ "ip", "fip" } },
+	"tip2fip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_tip2fip( nil ) } ), []string{ "tip", "fip" } },
+	"fip2ip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_fip2ip( nil ) } ), []string{ "fip", "ip" } },
+	"fip2tip":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_fip2tip( nil ) } ), []string{ "fip", "tip" } },
+	"vmid2host":	{ func( o *ostack.Ostack, inc_tenant bool ) ( map[string]*string, error ) {
+						_, _, _, m, _, err := o.Mk_vm_maps( nil, nil, nil, nil, nil, inc_tenant )
+						return m, err
+					}, []string{ "vmid", "host" } },
+	"netinfo":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { return o.Mk_netinfo_map() } ), []string{ "network", "id", "phys_net", "type", "seg_id" } },
+	"subnets":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { m, _, err := o.Mk_snlists(); return m, err } ), []string{ "id", "name", "tenant", "cidr", "gateway" } },
+	"gw2cidr":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) { _, m, err := o.Mk_snlists(); return m, err } ), []string{ "gateway", "cidr" } },
+	"hyp2host":		{ mk_fn( func( o *ostack.Ostack ) ( map[string]*string, error ) {
+						hm, err := o.Mk_hyp2host()
+						m := make( map[string]*string, len( hm ) )
+						for k, v := range hm {
+							m[fmt.Sprintf( "%d", k )] = v
+						}
+						return m, err
+					} ), []string{ "hypervisor", "host" } },
+	"gwmac2ip":		{ gw_map( 0 ), []string{ "mac", "ip" } },
+	"gwip2mac":		{ gw_map( 1 ), []string{ "ip", "mac" } },
+	"gwmac2id":		{ gw_map( 2 ), []string{ "mac", "id" } },
+	"gwid2mac":		{ gw_map( 3 ), []string{ "id", "mac" } },
+	"gwid2phost":	{ gw_map( 4 ), []string{ "id", "phost" } },
+	"gwip2phost":	{ gw_map( 5 ), []string{ "ip", "phost" } },
+}
+
+var host_types = map[string]int {
+	"any":			ostack.ANY,
+	"compute":		ostack.COMPUTE,
+	"schedule":		ostack.SCHEDULE,
+	"network":		ostack.NETWORK,
+	"cells":		ostack.CELLS,
+	"conductor":	ostack.CONDUCTOR,
+	"cert":			ostack.CERT,
+	"auth":			ostack.AUTH,
+	"l3":			ostack.L3,
+}
+
+func usage( ) {
+	fmt.Fprintf( os.Stderr, "usage: ostack-inventory [options] {maps [name...] | endpoints | hosts [type...] | gateways | token}\n" )
+	fmt.Fprintf( os.Stderr, "credentials: command line options override clouds.yaml, which overrides OS_* environment variables\n" )
+	flag.PrintDefaults()
+}
+
+func die( format string, args ...interface{} ) {
+	fmt.Fprintf( os.Stderr, "ostack-inventory: " + format + "\n", args... )
+	os.Exit( 1 )
+}
+
+/*
+	Build the table for the named map.
+*/
+func map_table( o *ostack.Ostack, name string, inc_tenant bool ) ( t *table, err error ) {
+	md := maps[name]
+	if md == nil {
+		return nil, fmt.Errorf( "unknown map: %s", name )
+	}
+
+	m, err := md.fn( o, inc_tenant )
+	if err != nil {
+		return nil, fmt.Errorf( "%s: %s", name, err )
+	}
+
+	t = mk_table( md.cols... )
+	t.add_map( m )
+	return
+}
+
+/*
+	Table listing the names of the maps that can be printed.
+*/
+func map_names( ) ( t *table ) {
+	t = mk_table( "map", "columns" )
+	for k, v := range maps {
+		t.add( k, strings.Join( v.cols, " " ) )
+	}
+
+	return
+}
+
+func endpoint_table( o *ostack.Ostack ) ( t *table, err error ) {
+	eps, err := o.Map_endpoints( nil )
+	if err != nil {
+		return
+	}
+	eps, err = o.Map_gw_endpoints( eps )
+	if err != nil {
+		return
+	}
+
+	t = mk_table( "id", "project", "phost", "mac", "ips", "network", "router" )
+	for id, ep := range eps {
+		ips := []string{ }
+		for _, ip := range ep.Get_ip_copy() {
+			if ip != nil {
+				ips = append( ips, *ip )
+			}
+		}
+		t.add( id, deref( ep.Get_project() ), deref( ep.Get_phost() ), deref( ep.Get_mac() ), strings.Join( ips, " " ), deref( ep.Get_netid() ), fmt.Sprintf( "%v", ep.Is_router() ) )
+	}
+
+	return
+}
+
+func host_table( o *ostack.Ostack, types []string, enabled bool ) ( t *table, err error ) {
+	htype := 0
+	for _, tn := range types {
+		ht, ok := host_types[strings.ToLower( tn )]
+		if ! ok {
+			return nil, fmt.Errorf( "unknown host type: %s", tn )
+		}
+		htype |= ht
+	}
+	if htype == 0 {
+		htype = ostack.COMPUTE | ostack.L3
+	}
+
+	var hlist *string
+	if enabled {
+		hlist, err = o.List_enabled_hosts( htype )
+	} else {
+		hlist, err = o.List_hosts( htype )
+	}
+	if err != nil {
+		return
+	}
+
+	t = mk_table( "host" )
+	if hlist != nil {
+		for _, h := range strings.Fields( *hlist ) {
+			t.add( h )
+		}
+	}
+
+	return
+}
+
+func gateway_table( o *ostack.Ostack ) ( t *table, err error ) {
+	gl, err := o.Mk_gwlist()
+	if err != nil {
+		return
+	}
+
+	t = mk_table( "mac", "ip" )
+	for _, g := range gl {
+		t.add( split_value( g, 2 )... )
+	}
+
+	return
+}
+
+func token_table( o *ostack.Ostack, token string, project string, use_v3 bool ) ( t *table, err error ) {
+	if token == "" {
+		token = o.Get_tok()
+	}
+
+	stuff, err := o.Crack_ptoken( &token, &project, use_v3 )
+	if err != nil {
+		return
+	}
+
+	roles := make( []string, 0, len( stuff.Roles ) )
+	for r := range stuff.Roles {
+		roles = append( roles, r )
+	}
+	sort.Strings( roles )
+
+	t = mk_table( "user", "id", "project_id", "expiry", "admin", "roles" )
+	t.add( stuff.User, stuff.Id, stuff.TenantId, fmt.Sprintf( "%d", stuff.Expiry ), fmt.Sprintf( "%v", o.Isadmin() ), strings.Join( roles, " " ) )
+	return
+}
+
+func deref( s *string ) ( string ) {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func main( ) {
+	c := env_creds()
+
+	cloud := flag.String( "cloud", os.Getenv( "OS_CLOUD" ), "name of the cloud in clouds.yaml" )
+	url := flag.String( "U", "", "auth url (overrides environment/clouds.yaml)" )
+	user := flag.String( "u", "", "user name" )
+	passwd := flag.String( "p", "", "password" )
+	project := flag.String( "P", "", "project name" )
+	region := flag.String( "r", "", "region" )
+	version := flag.Int( "V", 0, "identity version (2 or 3)" )
+	format := flag.String( "o", "table", "output format: table, json or csv" )
+	filter := flag.String( "f", "", "filter: comma separated list of col=regex or regex (all must match)" )
+	inc_tenant := flag.Bool( "i", false, "include the project in gateway and vm map names" )
+	enabled := flag.Bool( "e", false, "list only enabled hosts (hosts)" )
+	token := flag.String( "t", "", "token to describe (token); default is our own" )
+	debug := flag.Bool( "d", false, "dump openstack urls and json to stderr" )
+	latency := flag.Bool( "l", false, "show latency of openstack calls on stderr" )
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit( 1 )
+	}
+
+	if *cloud != "" {
+		if err := c.add_cloud( *cloud ); err != nil {
+			die( "%s", err )
+		}
+	}
+	set_if( &c.url, *url )
+	set_if( &c.user, *user )
+	set_if( &c.passwd, *passwd )
+	set_if( &c.project, *project )
+	set_if( &c.region, *region )
+	if *version != 0 {
+		c.set_version( fmt.Sprintf( "%d", *version ) )
+	}
+
+	if c.url == "" || c.user == "" || c.passwd == "" {
+		die( "auth url, user and password must be supplied (environment, clouds.yaml or command line)" )
+	}
+
+	if *debug {
+		ostack.Set_debugging( 0 )
+	} else {
+		ostack.Set_debugging( 1000 )
+	}
+	ostack.Set_latency_debugging( *latency )
+
+	var proj *string
+	if c.project != "" {
+		proj = &c.project
+	}
+	var reg *string
+	if c.region != "" {
+		reg = &c.region
+	}
+
+	o := ostack.Mk_ostack_region( &c.url, &c.user, &c.passwd, proj, reg )
+	if o == nil {
+		die( "unable to create openstack credentials" )
+	}
+
+	var err error
+	if c.version == 3 {
+		err = o.Authorise_v3()
+	} else {
+		err = o.Authorise()
+	}
+	if err != nil {
+		die( "authorisation failed: %s", err )
+	}
+
+	var t *table
+	args := flag.Args()
+	switch args[0] {
+		case "maps", "map":
+			if len( args ) < 2 {
+				t = map_names()
+				break
+			}
+
+			if len( args ) == 2 {
+				t, err = map_table( o, args[1], *inc_tenant )
+				break
+			}
+
+			t = mk_table( "map", "key", "value" )				// multiple maps; flatten
+			for _, name := range args[1:] {
+				mt, merr := map_table( o, name, *inc_tenant )
+				if merr != nil {
+					err = merr
+					break
+				}
+				for _, r := range mt.rows {
+					t.add( name, r[0], strings.Join( r[1:], " " ) )
+				}
+			}
+
+		case "endpoints", "ep":
+			t, err = endpoint_table( o )
+
+		case "hosts":
+			t, err = host_table( o, args[1:], *enabled )
+
+		case "gateways", "gw":
+			t, err = gateway_table( o )
+
+		case "token":
+			t, err = token_table( o, *token, c.project, c.version == 3 )
+
+		default:
+			usage()
+			os.Exit( 1 )
+	}
+
+	if err != nil {
+		die( "%s", err )
+	}
+
+	flist, err := t.mk_filters( *filter )
+	if err != nil {
+		die( "bad filter: %s", err )
+	}
+	t.apply( flist )
+	t.sort()
+
+	if err = t.write( os.Stdout, *format ); err != nil {
+		die( "%s", err )
+	}
+}
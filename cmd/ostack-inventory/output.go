@@ -0,0 +1,222 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	output.go
+	Abstract:	Everything that ostack-inventory prints is first put into a table (named
+				columns and rows of strings). The table can then be filtered and written
+				as an aligned text table, json (an array of objects), or csv.
+	Date:		19 October 2026
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+type table struct {
+	cols	[]string
+	rows	[][]string
+}
+
+/*
+	A single filter expression; col is -1 if the pattern may match any column.
+*/
+type filter struct {
+	col		int
+	re		*regexp.Regexp
+}
+
+func mk_table( cols ...string ) ( *table ) {
+	return &table{ cols: cols }
+}
+
+/*
+	Add a row; missing values are added as empty strings, extras are dropped.
+*/
+func (t *table) add( vals ...string ) {
+	row := make( []string, len( t.cols ) )
+	copy( row, vals )
+	t.rows = append( t.rows, row )
+}
+
+/*
+	Add all entries from a map. If the table has more than two columns the map value is
+	assumed to be a space separated tuple and is split across the remaining columns.
+*/
+func (t *table) add_map( m map[string]*string ) {
+	for k, v := range m {
+		if v == nil {
+			t.add( k )
+			continue
+		}
+
+		t.add( append( []string{ k }, split_value( *v, len( t.cols ) - 1 )... )... )
+	}
+}
+
+/*
+	Split the space separated value into n fields. If there are more tokens than fields
+	the extras are assumed to belong to the first field (e.g. a name with blanks).
+*/
+func split_value( v string, n int ) ( []string ) {
+	if n <= 1 {
+		return []string{ v }
+	}
+
+	toks := strings.Split( v, " " )
+	if len( toks ) > n {
+		extra := len( toks ) - n
+		toks = append( []string{ strings.Join( toks[0:extra+1], " " ) }, toks[extra+1:]... )
+	}
+
+	return toks
+}
+
+/*
+	Sort the rows by the first column (then the second...).
+*/
+func (t *table) sort( ) {
+	sort.SliceStable( t.rows, func( i, j int ) bool {
+		for c := range t.rows[i] {
+			if t.rows[i][c] != t.rows[j][c] {
+				return t.rows[i][c] < t.rows[j][c]
+			}
+		}
+		return false
+	} )
+}
+
+/*
+	Parse a filter string: a comma separated list of column=regex or regex expressions.
+	Column names are those in the table.
+*/
+func (t *table) mk_filters( spec string ) ( flist []filter, err error ) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	for _, expr := range strings.Split( spec, "," ) {
+		f := filter{ col: -1 }
+		pat := expr
+		if ei := strings.Index( expr, "=" ); ei > 0 {
+			name := expr[:ei]
+			for i, c := range t.cols {
+				if c == name {
+					f.col = i
+					pat = expr[ei+1:]
+					break
+				}
+			}
+			if f.col < 0 {
+				return nil, fmt.Errorf( "filter column %q is not one of: %s", name, strings.Join( t.cols, " " ) )
+			}
+		}
+
+		f.re, err = regexp.Compile( pat )
+		if err != nil {
+			return nil, err
+		}
+		flist = append( flist, f )
+	}
+
+	return
+}
+
+/*
+	Remove rows which do not match all filters.
+*/
+func (t *table) apply( flist []filter ) {
+	if len( flist ) == 0 {
+		return
+	}
+
+	keep := t.rows[:0]
+	for _, r := range t.rows {
+		ok := true
+		for _, f := range flist {
+			if f.col >= 0 {
+				ok = f.re.MatchString( r[f.col] )
+			} else {
+				ok = false
+				for _, v := range r {
+					if f.re.MatchString( v ) {
+						ok = true
+						break
+					}
+				}
+			}
+			if ! ok {
+				break
+			}
+		}
+
+		if ok {
+			keep = append( keep, r )
+		}
+	}
+	t.rows = keep
+}
+
+/*
+	Write the table to the writer in the format requested (table, json or csv).
+*/
+func (t *table) write( w io.Writer, format string ) ( err error ) {
+	switch format {
+		case "json":
+			list := make( []map[string]string, 0, len( t.rows ) )
+			for _, r := range t.rows {
+				m := make( map[string]string, len( t.cols ) )
+				for i, c := range t.cols {
+					m[c] = r[i]
+				}
+				list = append( list, m )
+			}
+			enc := json.NewEncoder( w )
+			enc.SetIndent( "", "  " )
+			err = enc.Encode( list )
+
+		case "csv":
+			cw := csv.NewWriter( w )
+			cw.Write( t.cols )
+			cw.WriteAll( t.rows )
+			err = cw.Error()
+
+		case "table", "":
+			tw := tabwriter.NewWriter( w, 0, 4, 2, ' ', 0 )
+			fmt.Fprintf( tw, "%s\n", strings.ToUpper( strings.Join( t.cols, "\t" ) ) )
+			for _, r := range t.rows {
+				fmt.Fprintf( tw, "%s\n", strings.Join( r, "\t" ) )
+			}
+			err = tw.Flush()
+
+		default:
+			err = fmt.Errorf( "unknown output format: %s (expected table, json or csv)", format )
+	}
+
+	return
+}
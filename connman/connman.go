@@ -35,6 +35,12 @@
 			06 Jan 2014 - Ensure goroutine exits when session is lost.
 			04 Aug 2016 - Correct potential core dump.
 							Add connection pointer to session data passed to caller on accept.
+			19 Oct 2026 - Added message framing (framing.go).
+			19 Oct 2026 - Added peer certificate information to session data (tls.go).
+			19 Oct 2026 - Added unix domain sockets to Listen and Connect (unix.go).
+			19 Oct 2026 - Session and listener lists are locked; goroutines are tracked for Shutdown
+						  (shutdown.go).
+			19 Oct 2026 - Added reconnecting outbound sessions (reconnect.go).
 */
 
 /*
@@ -58,13 +64,18 @@
 	struct and placed onto the appropriate channel.  The struct contains, in addition to the received
 	buffer, the ID of the session that can be used on a generic Write command to, the current state of
 	the session (ST_ constants), and a string indicating some useful (humanised) data about the session.
+
+	Unix domain sockets (unix.go) and TLS sessions (tls.go) are managed in the same way as TCP sessions,
+	and message framing (framing.go) can be set so that each data message carries a complete message.
 */
 package connman
 
 import (
+	"crypto/x509"
 	"fmt"
 	"net"
 	"os"
+	"sync"
 )
 
 const (
@@ -73,6 +84,8 @@ const (
 	ST_DATA			// data received
 	ST_DISC			// disconnected connection
 	ST_ACCEPTED		// session has been accepted
+	ST_RECONNECTING	// connection lost; attempting to reconnect (reconnecting sessions only)
+	ST_RESTORED		// connection reestablished (reconnecting sessions only)
 )
 
 const(						// connection states
@@ -84,11 +97,22 @@ const (
 )
 
 type Cmgr struct {						// main session manager class
+	clock	sync.Mutex					// protects the lists, counts and stopping
 	clist	map[string] *connection 	// tcp connections, also tracks udp listeners
 	llist	map[string] net.Listener 	// tcp listeners
 	lcount	int 						// tcp listener count for id string generation
 	ucount	int 						// udp 'listener' count for id string
 	mcount	int 						// multicast 'listener' count for id string
+
+	stopping	bool					// shutdown has started; no new sessions or listeners
+	wg			sync.WaitGroup			// listener and reader goroutines running
+	readers		map[*connection] bool	// sessions whose reader is running
+	lrunning	map[string] bool		// listeners whose goroutine is running
+	quit		chan bool				// closed to abandon sends to the user when shutdown times out
+
+	fr_lock		sync.Mutex
+	fr_default	*framing				// framing for listeners and connections created after Set_framing( "" )
+	lframe		map[string] *framing	// framing given to sessions accepted by each listener
 }
 
 /*
@@ -100,6 +124,8 @@ type Sess_data struct {
 	From	string		// message source address
 	State	int			// ST_ constants indicating the session state
 	Data	string		// maybe useful (humanised) data about the session or message; generally empty for data.
+	Peer	string		// common name from the peer's verified certificate (TLS); empty otherwise
+	Peer_cert *x509.Certificate	// the peer's verified certificate (TLS); nil otherwise
 	sender	*connection		// enables the data block to be used as a writer
 }
 
@@ -109,17 +135,34 @@ type connection struct {			// track specifics for a single 'connection'
 	conn		net.Conn 			// connection interface
 	uconn		*net.UDPConn 		// UDP socket
 	uaddr		*net.UDPAddr		// udp address 'bound' to this struct (fast writes)
+	pconn		*net.UnixConn		// unix datagram socket
+	paddr		*net.UnixAddr		// unix datagram sender that writes reply to
+	upath		string				// unix datagram socket file to remove on close
+	gram		bool				// conn is a connected unix datagram socket; data isn't framed
+	parent		*connection			// session a datagram reply sender belongs to; writes are counted there
 	data2usr	chan *Sess_data 	// channel to send data from this conn to user
 	bytes_in	int64
 	bytes_out	int64
 	state		int 				// current state
+	rc			*reconnect			// reconnect state; nil if the session doesn't reconnect
+	rc_err		error				// reason reconnecting was abandoned
+	nwrites		int32				// writes in progress (atomic)
+	draining	int32				// set when new writes are refused (atomic)
+
+	fr_lock		sync.Mutex
+	fr			*framer				// message framing; nil if data is passed as read
+
+	peer		string				// verified peer common name and certificate (tls)
+	peer_cert	*x509.Certificate
 }
 
 /* -------------- private ------------------------------------------------------- */
 
-// listen and accept connections
-func (this *Cmgr) listener(  l net.Listener, data2usr chan *Sess_data ) {
+// listen and accept connections; lid is the listener's id
+func (this *Cmgr) listener( lid string, l net.Listener, data2usr chan *Sess_data ) {
 	var n 	int = 0
+
+	defer this.listener_done( lid )
 	
 	for {
 		conn, err := l.Accept( )
@@ -129,7 +172,11 @@ func (this *Cmgr) listener(  l net.Listener, data2usr chan *Sess_data ) {
 			conn_data.id = fmt.Sprintf( "a%d", n )
 			conn_data.conn = conn
 			conn_data.data2usr = data2usr
-			this.clist[conn_data.id] = conn_data 		// hash for write to session
+			this.init_framer( conn_data, lid )
+			if this.add_sess( conn_data ) != nil { 		// hash for write to session; fails if shutting down
+				conn.Close( )
+				return
+			}
 
 			sdp := new( Sess_data ) 			// create and format accept msg back to user
 			sdp.Id = conn_data.id
@@ -137,7 +184,7 @@ func (this *Cmgr) listener(  l net.Listener, data2usr chan *Sess_data ) {
 			sdp.State = ST_ACCEPTED
 			sdp.Data = fmt.Sprintf( "connection [%s] accepted from: %s", conn_data.id, sdp.From )
 			sdp.sender = conn_data
-			data2usr <- sdp
+			this.send( data2usr, sdp )
 
 			go this.conn_reader( conn_data )
 		} else {
@@ -146,13 +193,160 @@ func (this *Cmgr) listener(  l net.Listener, data2usr chan *Sess_data ) {
 	}
 }
 
+/*
+	Register the session so that it can be found by id and its reader is tracked. The caller
+	must start the reader (conn_reader) if nil is returned; an error is returned if the manager
+	is shutting down.
+*/
+func (this *Cmgr) add_sess( cp *connection ) ( err error ) {
+	this.clock.Lock()
+	defer this.clock.Unlock()
+
+	if this.stopping {
+		return fmt.Errorf( "connection manager is shut down" )
+	}
+
+	this.clist[cp.id] = cp
+	this.readers[cp] = true
+	this.wg.Add( 1 )
+	return nil
+}
+
+/*
+	Register the listener, generating its id, and start the goroutine to accept sessions.
+*/
+func (this *Cmgr) add_listener( l net.Listener, data2usr chan *Sess_data ) ( lid string, err error ) {
+	this.clock.Lock()
+	if this.stopping {
+		this.clock.Unlock()
+		l.Close( )
+		return "", fmt.Errorf( "connection manager is shut down" )
+	}
+
+	lid = fmt.Sprintf( "l%d", this.lcount )
+	this.lcount += 1
+	this.llist[lid] = l
+	this.lrunning[lid] = true
+	this.wg.Add( 1 )
+	this.clock.Unlock()
+
+	this.init_lframe( lid )
+	go this.listener( lid, l, data2usr )
+	return lid, nil
+}
+
+/*
+	Generate the next id for a udp (u), or multicast (m) session.
+*/
+func (this *Cmgr) mk_id( kind string ) ( id string ) {
+	this.clock.Lock()
+	defer this.clock.Unlock()
+
+	if kind == "m" {
+		id = fmt.Sprintf( "m%d", this.mcount )
+		this.mcount++
+	} else {
+		id = fmt.Sprintf( "u%d", this.ucount )
+		this.ucount++
+	}
+
+	return id
+}
+
+/*
+	Return the session with the id, nil if there isn't one.
+*/
+func (this *Cmgr) get_sess( id string ) ( cp *connection ) {
+	this.clock.Lock()
+	defer this.clock.Unlock()
+
+	return this.clist[id]
+}
+
+/*
+	Note that the reader for the session has finished.
+*/
+func (this *Cmgr) reader_done( cp *connection ) {
+	this.clock.Lock()
+	delete( this.readers, cp )
+	this.clock.Unlock()
+
+	this.wg.Done( )
+}
+
+/*
+	Note that the listener goroutine has finished.
+*/
+func (this *Cmgr) listener_done( lid string ) {
+	this.clock.Lock()
+	delete( this.lrunning, lid )
+	this.clock.Unlock()
+
+	this.wg.Done( )
+}
+
+/*
+	Send the session data to the user. Data for a nil channel is discarded. Returns false
+	if the send was abandoned because shutdown timed out.
+*/
+func (this *Cmgr) send( data2usr chan *Sess_data, sdp *Sess_data ) ( bool ) {
+	if data2usr == nil {
+		return true
+	}
+
+	select {
+		case data2usr <- sdp:
+			return true
+
+		case <- this.quit:
+			return false
+	}
+}
+
+/*
+	Close the session's socket and remove it from the list. The reader notices and sends
+	the disconnect to the user.
+*/
+func (this *Cmgr) close_sess( cp *connection ) {
+	this.clock.Lock()
+	if cp.state == ST_CLOSING {		// if close called, read will call us when it pops
+		this.clock.Unlock()
+		return
+	}
+	cp.state = ST_CLOSING
+	if this.clist[cp.id] == cp {		// id might have been reused
+		delete( this.clist, cp.id )
+	}
+	this.clock.Unlock()
+
+	if cp.rc != nil {
+		cp.rc.halt( )					// before the close so the reader won't reconnect
+	}
+	if conn := cp.cur_conn( ); conn != nil {
+		_ = conn.Close( )
+	}
+	if cp.uconn != nil {
+		_ = cp.uconn.Close( )
+	}
+	if cp.pconn != nil {
+		_ = cp.pconn.Close( )
+		if cp.upath != "" {
+			os.Remove( cp.upath )
+		}
+	}
+}
+
 /*
 	Create a new data object.
 */
-func newdata( buf []byte, id string, state int, sender *connection, from *net.UDPAddr, data string ) (* Sess_data) {
+func newdata( buf []byte, id string, state int, sender *connection, from net.Addr, data string ) (* Sess_data) {
 	sdp := new( Sess_data )
 	sdp.Buf = make( []byte, len( buf ) )
 	sdp.sender = sender
+	if sender != nil {
+		sdp.Peer = sender.peer
+		sdp.Peer_cert = sender.peer_cert
+	}
 	copy( sdp.Buf, buf )
 	sdp.Id = id
 	if from != nil {
@@ -172,37 +366,101 @@ func newdata( buf []byte, id string, state int, sender *connection, from *net.UD
 func (this *Cmgr) conn_reader( cp *connection )   {
 	var buf []byte
 
+	defer this.reader_done( cp )
+
 	buf = make( []byte, 2048 )
 
 	if cp.conn != nil {
-		cp.data2usr <- newdata( nil, cp.id, ST_NEW, nil, nil, fmt.Sprintf( "%s", cp.conn.RemoteAddr()) )   // indicate new session
+		if err := cp.tls_handshake( ); err != nil {		// no-op unless tls
+			this.send( cp.data2usr, newdata( nil, cp.id, ST_DISC, nil, nil, fmt.Sprintf( "%s", err ) ) )
+			cp.data2usr = nil
+			this.close_sess( cp )
+			return
+		}
+
+		sdp := newdata( nil, cp.id, ST_NEW, nil, nil, fmt.Sprintf( "%s", cp.conn.RemoteAddr()) )   // indicate new session
+		sdp.Peer = cp.peer
+		sdp.Peer_cert = cp.peer_cert
+		this.send( cp.data2usr, sdp )
 	}
 
 	for {
 		var nread 	int
 		var err		error
-		var from	*net.UDPAddr = nil 	// packet source if udp
+		var from	net.Addr = nil 		// packet source if udp or unix datagram
+		var sender	*connection = cp	// where Sess_data writes go
 
 		if cp.conn != nil {							// nil if this is udp, or if the session isn't connected
 			nread, err = cp.conn.Read( buf );	
 		} else {
 			if cp.uconn != nil {
-				nread, from, err = cp.uconn.ReadFromUDP( buf );	
+				var ufrom *net.UDPAddr
+				nread, ufrom, err = cp.uconn.ReadFromUDP( buf );	
+				if ufrom != nil {
+					from = ufrom
+				}
 			} else {
-				return				// no session just stop the reader
+				if cp.pconn != nil {
+					var pfrom *net.UnixAddr
+					nread, pfrom, err = cp.pconn.ReadFromUnix( buf )
+					if pfrom != nil && pfrom.Name != "" {
+						from = pfrom
+					}
+					sender = &connection{ id: cp.id, pconn: cp.pconn, paddr: pfrom, parent: cp }		// replies go to the sender
+				} else {
+					return				// no session just stop the reader
+				}
 			}
 		}
 
 		if err != nil {
-			cp.data2usr <- newdata( nil, cp.id, ST_DISC, nil, nil, "" ) 	// disco to the user programme	
+			if cp.rc != nil {
+				if this.restore( cp, err ) {			// reconnecting session was reestablished
+					continue
+				}
+				if cp.rc_err != nil {
+					err = cp.rc_err
+				}
+				this.send( cp.data2usr, newdata( nil, cp.id, ST_DISC, nil, nil, fmt.Sprintf( "%s", err ) ) )
+				cp.data2usr = nil
+				this.close_sess( cp )
+				return
+			}
+
+			this.send( cp.data2usr, newdata( nil, cp.id, ST_DISC, nil, nil, "" ) ) 	// disco to the user programme	
 			cp.data2usr = nil
-			this.Close( cp.id ) 		// drop our side and stop
+			this.close_sess( cp ) 		// drop our side and stop
 			return
 		}
 
 		if cp.data2usr != nil {							// a nil buffer signals end to caller, so only write if not nil
 			cp.bytes_in += int64( nread )
-			cp.data2usr <- newdata( buf[0:nread], cp.id, ST_DATA, cp, from, "" )
+
+			if fr := cp.get_framer( ); fr != nil && cp.conn != nil && ! cp.gram {		// send only complete messages
+				fr.add( buf[0:nread] )
+				for {
+					msg, err := fr.next( )
+					if err != nil {
+						this.send( cp.data2usr, newdata( nil, cp.id, ST_DISC, nil, nil, fmt.Sprintf( "%s", err ) ) )
+						cp.data2usr = nil
+						this.close_sess( cp )
+						return
+					}
+					if msg == nil {
+						break
+					}
+					if ! this.send( cp.data2usr, newdata( msg, cp.id, ST_DATA, cp, from, "" ) ) {
+						this.close_sess( cp )
+						return
+					}
+				}
+				continue
+			}
+
+			if ! this.send( cp.data2usr, newdata( buf[0:nread], cp.id, ST_DATA, sender, from, "" ) ) {
+				this.close_sess( cp )						// shutdown abandoned the session
+				return
+			}
 			buf = make( []byte, 2048 )					// new buffer to prevent overruns
 		}
 	}
@@ -216,6 +474,9 @@ func (this *Cmgr) conn_reader( cp *connection )   {
 	accept sessions that connect. Generally the listen method will be driven during the construction of
 	a cmgr object, though I user can use this if more than one listen port is required.
 
+	If kind is unix, unixpacket or unixgram a unix domain socket listener is started with port
+	as the socket path (see Listen_unix); iface is ignored.
+
 	Returns an ID which identifies the listener, and a boolean set to true if the listener was established
 	successfully.
 */
@@ -225,6 +486,10 @@ func (this *Cmgr) Listen( kind string, port string,  iface string, data2usr chan
 	}
 
 
+	if is_unix( kind ) {
+		return this.Listen_unix( kind, port, 0, data2usr )
+	}
+
 	lid = ""
 	l, err := net.Listen( kind, fmt.Sprintf( "%s:%s", iface, port ) )
 	if err != nil {
@@ -232,12 +497,7 @@ func (this *Cmgr) Listen( kind string, port string,  iface string, data2usr chan
 		return
 	}
 
-	lid = fmt.Sprintf( "l%d", this.lcount )
-	this.lcount += 1
-
-	this.llist[lid] = l
-	go this.listener(  this.llist[lid], data2usr )
-	return
+	return this.add_listener( l, data2usr )
 }
 
 /*
@@ -258,18 +518,19 @@ func (this *Cmgr) Listen_udp( port int, data2usr chan *Sess_data ) ( uid string,
 		return
 	}
 
-	uid = fmt.Sprintf( "u%d", this.ucount ) 	// successful bind to port
-	this.ucount += 1
+	uid = this.mk_id( "u" ) 	// successful bind to port
 
 	cp := new( connection )
 	cp.conn = nil
 	cp.uconn = uconn
 	cp.data2usr = data2usr 		// session data written to the channel
 	cp.id = uid 			// user assigned session id
-	this.clist[uid] = cp 		// hash for write to session
+	if err = this.add_sess( cp ); err != nil { 		// hash for write to session
+		uconn.Close( )
+		return "", err
+	}
 	go this.conn_reader( cp ) 	// start reader; will discard if data2usr is nil
 	
-	this.clist[uid] = cp
 	return
 }
 
@@ -284,8 +545,7 @@ func (this *Cmgr) Listen_mc( ifname string, addr string, data2usr chan *Sess_dat
 		return
 	}
 
-	sessid = fmt.Sprintf( "m%d", this.mcount ) 	// successful bind to port
-	this.mcount++
+	sessid = this.mk_id( "m" ) 	// successful bind to port
 
 	uaddr, err := net.ResolveUDPAddr( "udp", addr )
 	if err != nil {
@@ -302,7 +562,10 @@ func (this *Cmgr) Listen_mc( ifname string, addr string, data2usr chan *Sess_dat
 	cp.uconn = uconn
 	cp.data2usr = data2usr 		// session data written to this channel
 	cp.id = sessid 				// user assigned session id
-	this.clist[sessid] = cp 	// hash for write to session
+	if err = this.add_sess( cp ); err != nil { 	// hash for write to session
+		uconn.Close( )
+		return "", err
+	}
 
 	go this.conn_reader( cp ) 	// start reader; will discard if data2usr is nil
 	
@@ -325,6 +588,9 @@ func (this *Cmgr) Listen_tcp( port string, data2usr chan *Sess_data ) ( string,
 func (this *Cmgr) List_stats(  ) {
 	var ucount int = 0 		// count of udp 'listeners' to dec conn count by
 
+	this.clock.Lock()
+	defer this.clock.Unlock()
+
 	fmt.Fprintf( os.Stderr, "%d tcp listeners:\n", len( this.llist ) ) 		// tcp listeners
 	for l := range this.llist {
 		fmt.Printf( "\t%s on %s\n", l, this.llist[l].Addr().String()  )
@@ -336,13 +602,17 @@ func (this *Cmgr) List_stats(  ) {
 			ucount += 1
 			fmt.Printf( "\t%s UDP on %s  %5d %5d\n", cp.id,  cp.uconn.LocalAddr().String(), cp.bytes_in, cp.bytes_out )
 		}
+		if cp.pconn != nil {
+			ucount += 1
+			fmt.Printf( "\t%s UNIXGRAM on %s  %5d %5d\n", cp.id,  cp.pconn.LocalAddr().String(), cp.bytes_in, cp.bytes_out )
+		}
 	}
 
 	fmt.Printf( "%d tcp connections:\n", len( this.clist ) - ucount ) 		// established tcp connections
 	for cname := range this.clist {
 		cp := this.clist[cname]
-		if cp.conn != nil {
-			fmt.Printf( "\t%s -> %s %5d %5d\n", cp.id, cp.conn.RemoteAddr(), cp.bytes_in, cp.bytes_out )
+		if conn := cp.cur_conn( ); conn != nil {
+			fmt.Printf( "\t%s -> %s %5d %5d\n", cp.id, conn.RemoteAddr(), cp.bytes_in, cp.bytes_out )
 		}
 	}
 
@@ -351,7 +621,8 @@ func (this *Cmgr) List_stats(  ) {
 /*
 	Connect establishes a connection to the target process (ip:port) and starts a reader listening
 	for data on the session.  Any received data will be forwarded to the user application
-	via the channel provided.
+	via the channel provided.  The target may also be a unix domain socket given as unix:path,
+	unixpacket:path or unixgram:path; a target starting with / or @ is a unix stream socket.
 */
 func (this *Cmgr) Connect( target string, uid string, data2usr chan *Sess_data ) ( err error ){
 	err = nil;
@@ -361,16 +632,24 @@ func (this *Cmgr) Connect( target string, uid string, data2usr chan *Sess_data )
 	}
 
 	cp := new( connection )
-	cp.conn, err = net.Dial( "tcp", target )
-	//if( err != nil ) {
-		//fmt.Printf( "session_connect: unable to create session to: %s: %s\n", target, err )
-	//} else {
-	if err == nil {
-		cp.data2usr = data2usr 		// session data written to the channel
-		cp.id = uid 				// user assigned session id
+	if kind, addr := split_target( target ); kind == "tcp" {
+		cp.conn, err = net.Dial( "tcp", target )
+	} else {
+		cp.conn, err = dial_unix( kind, addr )
+		cp.gram = kind == "unixgram"
+	}
+	if err != nil {
+		return
 	}
 
-	this.clist[uid] = cp 		// hash for write by id to session
+	cp.data2usr = data2usr 		// session data written to the channel
+	cp.id = uid 				// user assigned session id
+	this.init_framer( cp, "" )
+
+	if err = this.add_sess( cp ); err != nil { 		// hash for write by id to session
+		cp.conn.Close( )
+		return
+	}
 	go this.conn_reader( cp ) 	// start reader; will discard if data2usr is nil
 
 	return
@@ -389,7 +668,18 @@ func (this *Cmgr) Write( id string, buf []byte ) ( err error ) {
 
 	err = nil
 
-	if cp, ok := this.clist[id]; ok {
+	if cp := this.get_sess( id ); cp != nil {
+		if err = cp.wbegin( ); err != nil {
+			return
+		}
+		defer cp.wend( )
+		if cp.rc != nil {
+			_, err = cp.rc_write( buf )
+			return
+		}
+		if cp.conn == nil {
+			return fmt.Errorf( "session %s is not connection oriented", id )
+		}
 		cp.bytes_out += int64( len( buf ) )
 
 		for n = len( buf ) ; n >0 ; {
@@ -414,7 +704,21 @@ func (this *Cmgr) Write_n( id string, buf []byte, n int ) ( err error ){
 
 	err = nil
 
-	if cp, ok := this.clist[id]; ok {
+	if cp := this.get_sess( id ); cp != nil {
+		if err = cp.wbegin( ); err != nil {
+			return
+		}
+		defer cp.wend( )
+		if cp.rc != nil {
+			if n > len( buf ) {
+				n = len( buf )
+			}
+			_, err = cp.rc_write( buf[0:n] )
+			return
+		}
+		if cp.conn == nil {
+			return fmt.Errorf( "session %s is not connection oriented", id )
+		}
 		cp.bytes_out += int64( len( buf ) )
 
 		for  ; n >0 ; {
@@ -461,7 +765,7 @@ func (this *Cmgr) Write_udp( id string, to string, ibuf interface{}) ( err error
 
 	err = nil
 
-	if cp, ok := this.clist[id]; ok {
+	if cp := this.get_sess( id ); cp != nil {
 		addr, e := net.ResolveUDPAddr( "ip", to ) 		// parm1 is either ip, ip4 or ip6
 		if e != nil {
 			fmt.Fprintf( os.Stderr, "unable to convert address: %s\n", to )
@@ -483,7 +787,7 @@ func (this *Cmgr) Write_udp( id string, to string, ibuf interface{}) ( err error
 	writer.
 */
 func ( c *Cmgr ) Get_writer( id string ) ( *connection ) {
-	return  c.clist[id]
+	return  c.get_sess( id )
 }
 
 /*
@@ -492,7 +796,7 @@ func ( c *Cmgr ) Get_writer( id string ) ( *connection ) {
 	is already constructed.
 */
 func ( c *Cmgr ) Get_udp_writer( id string, addr string ) ( newcp *connection, err error ) {
-	cp := c.clist[id]
+	cp := c.get_sess( id )
 	if cp == nil {
 		err = fmt.Errorf( "get_udp_write: cannot find named session to generate writer from: %s", id )
 		return nil, err
@@ -528,12 +832,25 @@ func (this *connection) Write( buf []byte ) ( nw int, err error ) {
 
 	err = nil
 
+	if err = this.wbegin( ); err != nil {
+		return
+	}
+	defer this.wend( )
+
+	if this.rc != nil {
+		return this.rc_write( buf )				// reconnecting session
+	}
 	
 	for n = len( buf ); n > 0; {
 		if this.conn != nil {
 			tpnw, err = this.conn.Write( buf ) 			// connection oriented
 		} else {
-			if this.uaddr != nil {
+			if this.pconn != nil {
+				if this.paddr == nil || this.paddr.Name == "" {
+					return 0, fmt.Errorf( "unix datagram sender is not bound to an address; cannot reply" )
+				}
+				tpnw, err = this.pconn.WriteToUnix( buf, this.paddr )		// unix datagram reply
+			} else if this.uaddr != nil {
 				tpnw, err = this.uconn.WriteToUDP( buf, this.uaddr )		// udp oriented
 			} else {
 				nw = 0
@@ -602,11 +919,20 @@ func (this *Sess_data ) Write_n( buf []byte, n int ) ( err error ) {
 		err = fmt.Errorf( "sender not associated with session" )
 		return
 	}
+	if this.sender.pconn != nil || this.sender.rc != nil {
+		_, err = this.sender.Write( buf[0:n] )
+		return
+	}
 	if this.sender.conn == nil {
 		err = fmt.Errorf( "connection not associated with session" )
 		return
 	}
 
+	if err = this.sender.wbegin( ); err != nil {
+		return
+	}
+	defer this.sender.wend( )
+
 	this.sender.bytes_out += int64( n )
 	for ; n > 0; {
 		nw, err = this.sender.conn.Write( buf[0:n] ) 	// ignore error assuming that reader will catch and close things up
@@ -635,7 +961,7 @@ func (this *Sess_data ) Write_str( buf string ) ( n int, err error ) {
     Write_udp_addr writes the buffer to the address associated with id.
 */
 func (this *Cmgr) Write_udp_addr( id string, addr *net.UDPAddr, buf []byte ) {
-	if cp, ok := this.clist[id]; ok {
+	if cp := this.get_sess( id ); cp != nil {
 		cp.bytes_out += int64( len( buf ) );
 		cp.uconn.WriteToUDP( buf, addr );
 	}
@@ -656,7 +982,7 @@ func (this *Cmgr) String2udp_addr ( astr string ) ( addr *net.UDPAddr, err error
 	Get_conn returns the connection given the connection id.
 */
 func ( cm *Cmgr ) Get_conn( id string ) ( conn *connection ) {
-	conn = cm.clist[id]
+	conn = cm.get_sess( id )
 	return
 }
 
@@ -673,24 +999,25 @@ func ( conn *connection ) Direct_udp_send( addr *net.UDPAddr, buf []byte ) {
 	Close closess the named connection.
 */
 func (this *Cmgr) Close( id string ) {
-	
-	sess, ok := this.clist[id] 		// map id to the session data
-	if ok {
-		if( sess.state != ST_CLOSING ) { // if close called, read will call us when it popps; in case we are preempted
-			sess.state = ST_CLOSING
-			_ = sess.conn.Close( )
-			sess.conn = nil
-			delete( this.clist, id )
-		}
-
+	if sess := this.get_sess( id ); sess != nil { 		// map id to the session data
+		this.close_sess( sess )
 		return
 	}
 
+	this.clock.Lock()
 	ls, ok := this.llist[id] 			// listener
 	if ok {
-		ls.Close( )
 		delete( this.llist, id )
 	}
+	this.clock.Unlock()
+
+	if ok {
+		ls.Close( )
+
+		this.fr_lock.Lock()
+		delete( this.lframe, id )
+		this.fr_lock.Unlock()
+	}
 }
 
 /*
@@ -706,6 +1033,9 @@ func NewManager( port string, data2usr chan *Sess_data  ) ( *Cmgr ) {
 	this := new( Cmgr )
 	this.clist = make( map[string] *connection ) 	// must allocate the maps first
 	this.llist = make( map[string] net.Listener );	
+	this.readers = make( map[*connection] bool )
+	this.lrunning = make( map[string] bool )
+	this.quit = make( chan bool )
 	this.lcount = 0
 
 	
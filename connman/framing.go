@@ -0,0 +1,358 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+ Mnemonic:	framing.go
+ Abstract:	Message framing for connection oriented sessions. By default the buffer in
+			each ST_DATA Sess_data is whatever a single read returned, which might be
+			part of a message, or several.  When framing is set for a session the bytes
+			read are held until a complete message is received and each ST_DATA then
+			carries exactly one message:
+				FR_NEWLINE	messages end with a newline; the newline (and a carriage
+							return before it) is removed.
+				FR_LENGTH	each message is preceded by its length as a 4 byte, big
+							endian, unsigned integer; the length is removed.
+				FR_JSON		each message is a complete json object (as determined by
+							jsontools.Jsoncache, which matches braces ignoring those in
+							strings); white space between objects is removed.
+
+			A session which sends a message larger than the maximum size allowed is
+			disconnected (ST_DISC with the reason in Data).  A partial message held when
+			the session disconnects is dropped. UDP, multicast and unix datagram data is
+			not framed; each datagram is already a single message.
+
+			Write_msg() frames an outgoing message to match the session's framing.
+
+ Date:		19 October 2026
+*/
+
+package connman
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/att/gopkgs/jsontools"
+)
+
+const (
+						// message framing kinds
+	FR_NONE = iota		// no framing; data as read
+	FR_NEWLINE			// newline terminated messages
+	FR_LENGTH			// 4 byte big endian length prefixed messages
+	FR_JSON				// complete json objects
+)
+
+const (
+	MAX_MSG int = 1024 * 1024		// default maximum message size for framed sessions
+)
+
+type framing struct {				// framing settings for a listener or the manager's default
+	kind	int
+	max		int
+}
+
+type framer struct {				// assembles messages for a single session
+	kind	int
+	max		int
+	pend	[]byte					// bytes held until a message is complete (newline and length)
+	jc		*jsontools.Jsoncache	// json objects are assembled here
+	jpend	int						// number of bytes held by the json cache
+}
+
+/* -------------- private ------------------------------------------------------- */
+
+/*
+	Create a framer for the kind and maximum message size. Nil is returned for FR_NONE.
+*/
+func mk_framer( kind int, max int ) ( *framer ) {
+	if kind == FR_NONE {
+		return nil
+	}
+
+	if max <= 0 {
+		max = MAX_MSG
+	}
+
+	f := &framer {
+		kind: kind,
+		max: max,
+	}
+	if kind == FR_JSON {
+		f.jc = jsontools.Mk_jsoncache( )
+	}
+
+	return f
+}
+
+/*
+	Add newly read bytes to those held.
+*/
+func (f *framer) add( buf []byte ) {
+	if f.kind == FR_JSON {
+		f.jc.Add_bytes( buf )
+		f.jpend += len( buf )
+		return
+	}
+
+	f.pend = append( f.pend, buf... )
+}
+
+/*
+	Return the next complete message, or nil if there isn't one yet. An error is returned
+	if the message is (or will be) larger than the maximum.
+*/
+func (f *framer) next( ) ( msg []byte, err error ) {
+	switch f.kind {
+		case FR_NEWLINE:
+			i := bytes.IndexByte( f.pend, '\n' )
+			if i < 0 {
+				if len( f.pend ) > f.max {
+					return nil, fmt.Errorf( "no newline found in %d bytes; maximum message size is %d", len( f.pend ), f.max )
+				}
+				return nil, nil
+			}
+
+			if i > f.max {
+				return nil, fmt.Errorf( "message size (%d) exceeds the maximum (%d)", i, f.max )
+			}
+			msg = bytes.TrimSuffix( f.pend[0:i], []byte( "\r" ) )
+			f.pend = f.pend[i+1:]
+
+		case FR_LENGTH:
+			if len( f.pend ) < 4 {
+				return nil, nil
+			}
+
+			mlen := binary.BigEndian.Uint32( f.pend )
+			if uint64( mlen ) > uint64( f.max ) {
+				return nil, fmt.Errorf( "message size (%d) exceeds the maximum (%d)", mlen, f.max )
+			}
+			if len( f.pend ) < int( mlen ) + 4 {
+				return nil, nil
+			}
+			msg = f.pend[4:mlen+4]
+			f.pend = f.pend[mlen+4:]
+
+		case FR_JSON:
+			blob := f.jc.Get_blob( )
+			if blob == nil {
+				if f.jpend > f.max {
+					return nil, fmt.Errorf( "no complete json object found in %d bytes; maximum message size is %d", f.jpend, f.max )
+				}
+				return nil, nil
+			}
+
+			f.jpend -= len( blob )
+			msg = bytes.TrimSpace( blob )
+			if len( msg ) > f.max {
+				return nil, fmt.Errorf( "message size (%d) exceeds the maximum (%d)", len( msg ), f.max )
+			}
+
+		default:
+			return nil, fmt.Errorf( "unknown framing kind: %d", f.kind )
+	}
+
+	if msg == nil {
+		msg = []byte{ }			// an empty message is still a message
+	}
+	return msg, nil
+}
+
+/*
+	Frame the buffer for writing. The buffer is returned unchanged for json and unframed
+	sessions.
+*/
+func (f *framer) frame( buf []byte ) ( fbuf []byte, err error ) {
+	if f == nil {
+		return buf, nil
+	}
+
+	if len( buf ) > f.max {
+		return nil, fmt.Errorf( "message size (%d) exceeds the maximum (%d)", len( buf ), f.max )
+	}
+
+	switch f.kind {
+		case FR_NEWLINE:
+			if i := bytes.IndexByte( buf, '\n' ); i >= 0 && i < len( buf ) - 1 {
+				return nil, fmt.Errorf( "message contains an embedded newline" )
+			}
+			if len( buf ) > 0 && buf[len( buf )-1] == '\n' {
+				return buf, nil
+			}
+			fbuf = make( []byte, len( buf ) + 1 )
+			copy( fbuf, buf )
+			fbuf[len( buf )] = '\n'
+
+		case FR_LENGTH:
+			fbuf = make( []byte, len( buf ) + 4 )
+			binary.BigEndian.PutUint32( fbuf, uint32( len( buf ) ) )
+			copy( fbuf[4:], buf )
+
+		default:
+			fbuf = buf
+	}
+
+	return fbuf, nil
+}
+
+/*
+	Return the framer for the connection.
+*/
+func (cp *connection) get_framer( ) ( *framer ) {
+	cp.fr_lock.Lock()
+	defer cp.fr_lock.Unlock()
+
+	return cp.fr
+}
+
+/*
+	Replace the framer for the connection.
+*/
+func (cp *connection) set_framer( f *framer ) {
+	cp.fr_lock.Lock()
+	cp.fr = f
+	cp.fr_lock.Unlock()
+}
+
+/*
+	Note the manager's default framing as the framing for sessions accepted by a new listener.
+*/
+func (this *Cmgr) init_lframe( lid string ) {
+	this.fr_lock.Lock()
+	defer this.fr_lock.Unlock()
+
+	if this.fr_default != nil {
+		if this.lframe == nil {
+			this.lframe = make( map[string]*framing )
+		}
+		this.lframe[lid] = this.fr_default
+	}
+}
+
+/*
+	Set the framer for a newly created session from the framing of the listener which accepted
+	it, or from the manager's default if lid is empty (outbound connection). Datagram sessions
+	are never framed.
+*/
+func (this *Cmgr) init_framer( cp *connection, lid string ) {
+	if cp.gram {
+		return
+	}
+
+	this.fr_lock.Lock()
+	defer this.fr_lock.Unlock()
+
+	fs := this.fr_default
+	if lid != "" {
+		fs = this.lframe[lid]
+	}
+	if fs != nil {
+		cp.set_framer( mk_framer( fs.kind, fs.max ) )
+	}
+}
+
+/* ------ public ---------------------------------------------------- */
+
+/*
+	Set_framing sets the message framing (FR_ constants) and the maximum message size (0 uses
+	MAX_MSG) used for the session, or listener, with the given id. When the id is a listener the
+	framing applies to sessions accepted after the call.  When the id is a connection it applies
+	to data read after the call and any partial message held is discarded. When the id is empty
+	the framing becomes the default for listeners and connections created after the call; set it
+	this way before Connect() if the remote side might send data immediately.
+*/
+func (this *Cmgr) Set_framing( id string, kind int, max int ) ( err error ) {
+	if this == nil {
+		return fmt.Errorf( "set_framing: nil manager" )
+	}
+
+	if kind < FR_NONE || kind > FR_JSON {
+		return fmt.Errorf( "set_framing: unknown framing kind: %d", kind )
+	}
+
+	if id == "" {
+		this.fr_lock.Lock()
+		this.fr_default = &framing{ kind: kind, max: max }
+		this.fr_lock.Unlock()
+		return
+	}
+
+	this.clock.Lock()
+	_, is_listener := this.llist[id]
+	this.clock.Unlock()
+
+	if is_listener {
+		this.fr_lock.Lock()
+		if this.lframe == nil {
+			this.lframe = make( map[string]*framing )
+		}
+		this.lframe[id] = &framing{ kind: kind, max: max }
+		this.fr_lock.Unlock()
+		return
+	}
+
+	cp := this.get_sess( id )
+	if cp == nil {
+		return fmt.Errorf( "set_framing: unknown session or listener id: %s", id )
+	}
+	if cp.cur_conn( ) == nil || cp.gram {
+		return fmt.Errorf( "set_framing: %s is not a connection oriented session", id )
+	}
+
+	cp.set_framer( mk_framer( kind, max ) )
+	return
+}
+
+/*
+	Write_msg writes the buffer to the named session as a single message, adding the framing
+	(newline or length) set for the session.
+*/
+func (this *Cmgr) Write_msg( id string, buf []byte ) ( err error ) {
+	cp := this.get_sess( id )
+	if cp == nil {
+		return fmt.Errorf( "write_msg: unknown session id: %s", id )
+	}
+
+	fbuf, err := cp.get_framer( ).frame( buf )
+	if err != nil {
+		return
+	}
+
+	return this.Write( id, fbuf )
+}
+
+/*
+	Write_msg writes the buffer as a single message to the process that sent the data represented
+	by Sess_data, adding the framing set for the session.
+*/
+func (s *Sess_data) Write_msg( buf []byte ) ( err error ) {
+	if s == nil || s.sender == nil {
+		return fmt.Errorf( "no struct" )
+	}
+
+	fbuf, err := s.sender.get_framer( ).frame( buf )
+	if err != nil {
+		return
+	}
+
+	_, err = s.sender.Write( fbuf )
+	return
+}
@@ -0,0 +1,180 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+package connman
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+/*
+	Feed the framer the input a few bytes at a time and collect the messages.
+*/
+func frame_all( t *testing.T, f *framer, in []byte, chunk int ) ( msgs []string, err error ) {
+	for len( in ) > 0 {
+		n := chunk
+		if n > len( in ) {
+			n = len( in )
+		}
+		f.add( in[0:n] )
+		in = in[n:]
+
+		for {
+			msg, err := f.next( )
+			if err != nil {
+				return msgs, err
+			}
+			if msg == nil {
+				break
+			}
+			msgs = append( msgs, string( msg ) )
+		}
+	}
+
+	return msgs, nil
+}
+
+func TestFramer( t *testing.T ) {
+	nl := mk_framer( FR_NEWLINE, 0 )
+	msgs, err := frame_all( t, nl, []byte( "one\ntwo\r\n\nthree\npartial" ), 3 )
+	if err != nil || strings.Join( msgs, "|" ) != "one|two||three" {
+		t.Errorf( "newline framing: got %q err=%v", msgs, err )
+	}
+
+	lf := mk_framer( FR_LENGTH, 0 )
+	var in []byte
+	for _, m := range []string{ "hello", "", "a\nb" } {
+		fb, _ := lf.frame( []byte( m ) )
+		in = append( in, fb... )
+	}
+	msgs, err = frame_all( t, lf, in, 2 )
+	if err != nil || strings.Join( msgs, "|" ) != "hello||a\nb" {
+		t.Errorf( "length framing: got %q err=%v", msgs, err )
+	}
+
+	jf := mk_framer( FR_JSON, 0 )
+	msgs, err = frame_all( t, jf, []byte( `{ "a": { "b": 1 } }  {"c":2}{"d"` ), 5 )
+	if err != nil || strings.Join( msgs, "|" ) != `{ "a": { "b": 1 } }|{"c":2}` {
+		t.Errorf( "json framing: got %q err=%v", msgs, err )
+	}
+
+	jf = mk_framer( FR_JSON, 0 )						// braces and escaped quotes in strings
+	msgs, err = frame_all( t, jf, []byte( `{"a":"}{"} {"b":"x\"}"} {"c":"\\"}` ), 3 )
+	if err != nil || strings.Join( msgs, "|" ) != `{"a":"}{"}|{"b":"x\"}"}|{"c":"\\"}` {
+		t.Errorf( "json framing with braces in strings: got %q err=%v", msgs, err )
+	}
+
+	if _, err = frame_all( t, mk_framer( FR_NEWLINE, 8 ), []byte( "0123456789abc\n" ), 4 ); err == nil {
+		t.Errorf( "newline framing: expected an error for an oversized message" )
+	}
+	if _, err = frame_all( t, mk_framer( FR_LENGTH, 8 ), []byte{ 0, 0, 1, 0 }, 4 ); err == nil {
+		t.Errorf( "length framing: expected an error for an oversized message" )
+	}
+	if _, err = frame_all( t, mk_framer( FR_JSON, 8 ), []byte( `{ "name": "too long" }` ), 4 ); err == nil {
+		t.Errorf( "json framing: expected an error for an oversized message" )
+	}
+
+	if _, err = nl.frame( []byte( "a\nb" ) ); err == nil {
+		t.Errorf( "newline framing: expected an error writing a message with an embedded newline" )
+	}
+}
+
+/*
+	Wait for the next session data on the channel that isn't a new/accepted notification.
+*/
+func next_data( t *testing.T, ch chan *Sess_data ) ( *Sess_data ) {
+	for {
+		select {
+			case sd := <- ch:
+				if sd.State == ST_DATA || sd.State == ST_DISC {
+					return sd
+				}
+
+			case <- time.After( 5 * time.Second ):
+				t.Fatalf( "timeout waiting for session data" )
+		}
+	}
+}
+
+/*
+	Return a port which is free on the loopback interface.
+*/
+func free_port( t *testing.T ) ( string ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort( l.Addr().String() )
+	return port
+}
+
+func TestFraming_session( t *testing.T ) {
+	lch := make( chan *Sess_data, 32 )
+	cm := NewManager( "", nil )
+
+	port := free_port( t )
+	lid, err := cm.Listen( "tcp", port, "127.0.0.1", lch )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	defer cm.Close( lid )
+	if err = cm.Set_framing( lid, FR_LENGTH, 64 ); err != nil {
+		t.Fatalf( "set_framing: %s", err )
+	}
+
+	cch := make( chan *Sess_data, 32 )
+	cm.Set_framing( "", FR_LENGTH, 64 )
+	if err = cm.Connect( "127.0.0.1:" + port, "c1", cch ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+
+	for _, m := range []string{ "first", "second message", "" } {
+		if err = cm.Write_msg( "c1", []byte( m ) ); err != nil {
+			t.Fatalf( "write_msg: %s", err )
+		}
+	}
+
+	var sd *Sess_data
+	for _, m := range []string{ "first", "second message", "" } {
+		sd = next_data( t, lch )
+		if sd.State != ST_DATA || string( sd.Buf ) != m {
+			t.Fatalf( "expected message %q, got state=%d %q", m, sd.State, sd.Buf )
+		}
+	}
+
+	if err = sd.Write_msg( []byte( "reply" ) ); err != nil {		// reply is framed for the accepted session too
+		t.Fatalf( "reply: %s", err )
+	}
+	if sd = next_data( t, cch ); sd.State != ST_DATA || string( sd.Buf ) != "reply" {
+		t.Fatalf( "expected reply, got state=%d %q", sd.State, sd.Buf )
+	}
+
+	cm.Write( "c1", []byte{ 0, 0, 1, 0 } )							// too big for the listener's max
+	if sd = next_data( t, lch ); sd.State != ST_DISC || sd.Data == "" {
+		t.Fatalf( "expected disconnect with a reason, got state=%d %q", sd.State, sd.Data )
+	}
+	if sd = next_data( t, cch ); sd.State != ST_DISC {
+		t.Fatalf( "expected the client to see a disconnect, got state=%d", sd.State )
+	}
+}
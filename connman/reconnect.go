@@ -0,0 +1,353 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+ Mnemonic:	reconnect.go
+ Abstract:	Outbound sessions which reconnect.  A session created by Connect_reconnect()
+			keeps its id when the connection is lost: ST_RECONNECTING is sent to the user
+			(with the reason in Data) and the target is redialed, waiting Min_delay before
+			the first attempt and doubling the wait after each failure up to Max_delay.
+			When the connection is reestablished ST_RESTORED is sent and data flows as
+			before; a partial framed message held when the connection was lost is dropped.
+			If Max_tries consecutive attempts fail the session ends with ST_DISC.
+
+			While the session is reconnecting writes are either refused (error), or if
+			a buffer size is given, saved and written, in order, as soon as the connection
+			is restored.  A write made before the loss is noticed fails with the error
+			from the old connection and is not saved.  Closing the session (Close or
+			Shutdown) stops any reconnect attempts, including one in progress; each
+			attempt is also limited by the Timeout option.  Data still saved when the
+			session is closed is discarded (Shutdown with drain reports the session).
+
+ Date:		19 October 2026
+*/
+
+package connman
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+	Options for a reconnecting session.
+*/
+type Reconnect_opts struct {
+	Min_delay	time.Duration		// wait before the first attempt; 250ms if 0
+	Max_delay	time.Duration		// limit of the doubled wait between attempts; 30s if 0
+	Max_tries	int					// consecutive failed attempts before giving up; 0 never gives up
+	Buffer		int					// bytes of writes saved while disconnected; 0 refuses writes
+	Tls			*Tls_opts			// connect with TLS using these options when not nil
+	Timeout		time.Duration		// limit on each connection attempt, including a TLS handshake; 30s if 0
+}
+
+type reconnect struct {				// reconnect state for a session
+	target	string
+	opts	Reconnect_opts
+	config	*tls.Config				// nil if not tls
+
+	lock	sync.Mutex				// protects the following and the connection's conn and bytes_out; not held while writing
+	up		bool					// connection is established
+	stopped	bool					// session closed; no more attempts
+	stop	chan bool				// closed when the session is closed
+	pend	[]byte					// writes saved while disconnected
+}
+
+/* -------------- private ------------------------------------------------------- */
+
+/*
+	Connect to the target. The attempt is abandoned after the timeout, or when the session
+	is closed or quit is closed.
+*/
+func (rc *reconnect) dial( quit chan bool ) ( conn net.Conn, err error ) {
+	ctx, cancel := context.WithCancel( context.Background() )
+	defer cancel()
+	go func( ) {
+		select {
+			case <- rc.stop:
+			case <- quit:
+			case <- ctx.Done():
+		}
+		cancel()
+	}( )
+
+	d := &net.Dialer{ Timeout: rc.opts.Timeout }
+	if rc.config != nil {
+		td := &tls.Dialer{ NetDialer: d, Config: rc.config }
+		return td.DialContext( ctx, "tcp", rc.target )
+	}
+
+	kind, addr := split_target( rc.target )
+	if kind == "tcp" {
+		return d.DialContext( ctx, "tcp", rc.target )
+	}
+
+	if kind == "unixgram" {
+		if laddr := gram_laddr( ); laddr != nil {
+			d.LocalAddr = laddr
+		}
+	}
+	return d.DialContext( ctx, kind, addr )
+}
+
+/*
+	Return the current connection.
+*/
+func (cp *connection) cur_conn( ) ( net.Conn ) {
+	if cp.rc == nil {
+		return cp.conn
+	}
+
+	cp.rc.lock.Lock()
+	defer cp.rc.lock.Unlock()
+
+	return cp.conn
+}
+
+/*
+	Stop reconnect attempts; called when the session is closed.
+*/
+func (rc *reconnect) halt( ) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if ! rc.stopped {
+		rc.stopped = true
+		close( rc.stop )
+	}
+}
+
+/*
+	Write the buffer if connected, otherwise save it if there is room. The lock is not held
+	while writing so that a write blocked by the peer doesn't prevent the session from being
+	closed.
+*/
+func (cp *connection) rc_write( buf []byte ) ( nw int, err error ) {
+	rc := cp.rc
+	rc.lock.Lock()
+	if rc.up {
+		conn := cp.conn
+		rc.lock.Unlock()
+
+		nw, err = conn.Write( buf )
+		rc.lock.Lock()
+		cp.bytes_out += int64( nw )
+		rc.lock.Unlock()
+		return
+	}
+	defer rc.lock.Unlock()
+
+	if rc.stopped {
+		return 0, fmt.Errorf( "session %s is closed", cp.id )
+	}
+	if len( rc.pend ) + len( buf ) > rc.opts.Buffer {
+		return 0, fmt.Errorf( "session %s is reconnecting; write not buffered", cp.id )
+	}
+
+	rc.pend = append( rc.pend, buf... )
+	return len( buf ), nil
+}
+
+/*
+	Write the data saved while disconnected to the new connection and then mark the connection
+	up. Writes made while flushing are saved and written in turn so that the order is kept.  On
+	error what wasn't written is saved for the next connection.
+*/
+func (cp *connection) rc_flush( ) ( err error ) {
+	rc := cp.rc
+	for {
+		rc.lock.Lock()
+		if rc.stopped {
+			rc.lock.Unlock()
+			return fmt.Errorf( "session %s is closed", cp.id )
+		}
+		pend := rc.pend
+		if len( pend ) == 0 {
+			rc.up = true
+			rc.lock.Unlock()
+			return nil
+		}
+		rc.pend = nil
+		conn := cp.conn
+		rc.lock.Unlock()
+
+		nw, err := conn.Write( pend )
+		rc.lock.Lock()
+		cp.bytes_out += int64( nw )
+		if err != nil {
+			rc.pend = append( pend[nw:], rc.pend... )
+		}
+		rc.lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+	Return the number of bytes saved while disconnected and not yet written.
+*/
+func (cp *connection) rc_pending( ) ( int ) {
+	if cp.rc == nil {
+		return 0
+	}
+
+	cp.rc.lock.Lock()
+	defer cp.rc.lock.Unlock()
+
+	return len( cp.rc.pend )
+}
+
+/*
+	Called by the reader when the connection fails. Attempts to reestablish the connection and
+	returns true if it was.
+*/
+func (this *Cmgr) restore( cp *connection, reason error ) ( bool ) {
+	rc := cp.rc
+
+	rc.lock.Lock()
+	rc.up = false
+	stopped := rc.stopped
+	rc.lock.Unlock()
+	if stopped {
+		return false
+	}
+	cp.conn.Close( )
+
+	this.send( cp.data2usr, newdata( nil, cp.id, ST_RECONNECTING, nil, nil, fmt.Sprintf( "connection to %s lost: %s", rc.target, reason ) ) )
+
+	delay := rc.opts.Min_delay
+	for tries := 1; ; tries++ {
+		select {
+			case <- time.After( delay ):
+
+			case <- rc.stop:
+				return false
+
+			case <- this.quit:
+				return false
+		}
+
+		conn, err := rc.dial( this.quit )
+		if err == nil {
+			rc.lock.Lock()
+			stopped := rc.stopped
+			if ! stopped {
+				cp.conn = conn						// before the flush so that a close interrupts it
+			}
+			rc.lock.Unlock()
+			if stopped {
+				conn.Close( )
+				return false
+			}
+
+			if err = cp.rc_flush( ); err == nil {			// flush what was saved while down
+				if err = cp.tls_handshake( ); err == nil {			// no-op unless tls; captures the peer
+					if fr := cp.get_framer( ); fr != nil {
+						cp.set_framer( mk_framer( fr.kind, fr.max ) )	// drop any partial message
+					}
+
+					sdp := newdata( nil, cp.id, ST_RESTORED, nil, nil, fmt.Sprintf( "reconnected to %s after %d attempt(s)", rc.target, tries ) )
+					sdp.Peer = cp.peer
+					sdp.Peer_cert = cp.peer_cert
+					this.send( cp.data2usr, sdp )
+					return true
+				}
+			}
+			conn.Close( )
+		}
+
+		reason = err
+		if rc.opts.Max_tries > 0 && tries >= rc.opts.Max_tries {
+			cp.rc_err = fmt.Errorf( "unable to reconnect to %s after %d attempt(s): %s", rc.target, tries, reason )
+			return false
+		}
+
+		delay *= 2
+		if delay > rc.opts.Max_delay {
+			delay = rc.opts.Max_delay
+		}
+	}
+}
+
+/* ------ public ---------------------------------------------------- */
+
+/*
+	Connect_reconnect establishes a session to the target in the same manner as Connect() (the
+	target may be a unix domain socket, or if TLS options are given, a TLS session), but the session
+	is reestablished when the connection is lost.  See the abstract in reconnect.go for the states
+	sent to the user and write behaviour while disconnected.  Opts may be nil to use the defaults.
+	An error is returned if the first connection attempt fails.
+*/
+func (this *Cmgr) Connect_reconnect( target string, uid string, data2usr chan *Sess_data, opts *Reconnect_opts ) ( err error ) {
+	if this == nil {
+		return fmt.Errorf( "cannot connect; nil object passed in" )
+	}
+
+	rc := &reconnect{ target: target, stop: make( chan bool ) }
+	if opts != nil {
+		rc.opts = *opts
+	}
+	if rc.opts.Min_delay <= 0 {
+		rc.opts.Min_delay = 250 * time.Millisecond
+	}
+	if rc.opts.Max_delay <= 0 {
+		rc.opts.Max_delay = 30 * time.Second
+	}
+	if rc.opts.Max_delay < rc.opts.Min_delay {
+		rc.opts.Max_delay = rc.opts.Min_delay
+	}
+	if rc.opts.Timeout <= 0 {
+		rc.opts.Timeout = 30 * time.Second
+	}
+	if rc.opts.Tls != nil {
+		if rc.config, err = rc.opts.Tls.mk_config( false ); err != nil {
+			return
+		}
+	}
+
+	conn, err := rc.dial( this.quit )
+	if err != nil {
+		return fmt.Errorf( "unable to connect to %s: %s", target, err )
+	}
+	rc.up = true
+
+	cp := new( connection )
+	cp.conn = conn
+	cp.rc = rc
+	if rc.config == nil {
+		kind, _ := split_target( target )
+		cp.gram = kind == "unixgram"
+	}
+	cp.data2usr = data2usr
+	cp.id = uid
+	this.init_framer( cp, "" )
+
+	if err = this.add_sess( cp ); err != nil {
+		conn.Close( )
+		return
+	}
+	go this.conn_reader( cp )
+
+	return
+}
@@ -0,0 +1,270 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+package connman
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*
+	Wait for the next session data in the given state.
+*/
+func next_state( t *testing.T, ch chan *Sess_data, state int ) ( *Sess_data ) {
+	for {
+		select {
+			case sd := <- ch:
+				if sd.State == state {
+					return sd
+				}
+				if sd.State == ST_DISC {
+					t.Fatalf( "unexpected disconnect waiting for state %d: %s", state, sd.Data )
+				}
+
+			case <- time.After( 5 * time.Second ):
+				t.Fatalf( "timeout waiting for state %d", state )
+		}
+	}
+}
+
+/*
+	Accept a connection and read n bytes from it.
+*/
+func accept_read( t *testing.T, l net.Listener, n int ) ( net.Conn, string ) {
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf( "accept: %s", err )
+	}
+
+	buf := make( []byte, n )
+	c.SetReadDeadline( time.Now().Add( 5 * time.Second ) )
+	if _, err = io.ReadFull( c, buf ); err != nil {
+		t.Fatalf( "read: %s", err )
+	}
+	return c, string( buf )
+}
+
+func TestReconnect( t *testing.T ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	defer l.Close()
+
+	cm := NewManager( "", nil )
+	ch := make( chan *Sess_data, 32 )
+	opts := &Reconnect_opts{ Min_delay: 20 * time.Millisecond, Max_delay: 100 * time.Millisecond, Buffer: 64 }
+	if err = cm.Connect_reconnect( l.Addr().String(), "r1", ch, opts ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+
+	cm.Write_str( "r1", "one" )
+	c, got := accept_read( t, l, 3 )
+	if got != "one" {
+		t.Fatalf( "expected one, got %q", got )
+	}
+
+	c.Close()											// drop the session; client should reconnect
+	if sd := next_state( t, ch, ST_RECONNECTING ); sd.Id != "r1" {
+		t.Fatalf( "expected id r1 while reconnecting, got %s", sd.Id )
+	}
+	if err = cm.Write_str( "r1", "two" ); err != nil {		// buffered until restored
+		t.Fatalf( "write while reconnecting: %s", err )
+	}
+
+	c, got = accept_read( t, l, 3 )
+	defer c.Close()
+	if got != "two" {
+		t.Fatalf( "expected buffered write two after reconnect, got %q", got )
+	}
+	if sd := next_state( t, ch, ST_RESTORED ); sd.Id != "r1" {
+		t.Fatalf( "expected id r1 when restored, got %s", sd.Id )
+	}
+
+	c.Write( []byte( "back" ) )
+	sd := next_state( t, ch, ST_DATA )
+	if sd.Id != "r1" || string( sd.Buf ) != "back" {
+		t.Fatalf( "expected back on r1, got %s %q", sd.Id, sd.Buf )
+	}
+
+	if _, err = sd.Write_str( "reply" ); err != nil {		// session data writes use the new connection
+		t.Fatalf( "reply: %s", err )
+	}
+	buf := make( []byte, 5 )
+	if _, err = io.ReadFull( c, buf ); err != nil || string( buf ) != "reply" {
+		t.Fatalf( "expected reply, got %q %v", buf, err )
+	}
+}
+
+func TestReconnect_give_up( t *testing.T ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+
+	cm := NewManager( "", nil )
+	ch := make( chan *Sess_data, 32 )
+	opts := &Reconnect_opts{ Min_delay: 10 * time.Millisecond, Max_tries: 2 }
+	if err = cm.Connect_reconnect( l.Addr().String(), "r1", ch, opts ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf( "accept: %s", err )
+	}
+	l.Close()											// nothing to reconnect to
+	c.Close()
+
+	next_state( t, ch, ST_RECONNECTING )
+	if err = cm.Write_str( "r1", "lost" ); err == nil {
+		t.Fatalf( "expected a write while reconnecting without a buffer to fail" )
+	}
+
+	select {
+		case sd := <- ch:
+			if sd.State != ST_DISC || ! strings.Contains( sd.Data, "after 2 attempt" ) {
+				t.Fatalf( "expected disconnect after 2 attempts, got state=%d %q", sd.State, sd.Data )
+			}
+
+		case <- time.After( 5 * time.Second ):
+			t.Fatalf( "timeout waiting for disconnect" )
+	}
+}
+
+func TestReconnect_close( t *testing.T ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+
+	cm := NewManager( "", nil )
+	ch := make( chan *Sess_data, 32 )
+	opts := &Reconnect_opts{ Min_delay: time.Hour }
+	if err = cm.Connect_reconnect( l.Addr().String(), "r1", ch, opts ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf( "accept: %s", err )
+	}
+	l.Close()
+	c.Close()
+
+	next_state( t, ch, ST_RECONNECTING )
+	cm.Close( "r1" )									// must end the wait for the next attempt
+	select {
+		case sd := <- ch:
+			if sd.State != ST_DISC {
+				t.Fatalf( "expected disconnect after close, got state=%d", sd.State )
+			}
+
+		case <- time.After( 5 * time.Second ):
+			t.Fatalf( "close did not stop reconnecting" )
+	}
+}
+
+/*
+	A write blocked by a peer which doesn't read must not prevent the session from being closed.
+*/
+func TestReconnect_blocked_write( t *testing.T ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	defer l.Close()
+
+	cm := NewManager( "", nil )
+	ch := make( chan *Sess_data, 32 )
+	if err = cm.Connect_reconnect( l.Addr().String(), "r1", ch, nil ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+	c, err := l.Accept()									// never read
+	if err != nil {
+		t.Fatalf( "accept: %s", err )
+	}
+	defer c.Close()
+
+	wdone := make( chan error )
+	go func() {
+		wdone <- cm.Write( "r1", make( []byte, 64 * 1024 * 1024 ) )
+	}()
+	cp := cm.get_sess( "r1" )
+	for i := 0; i < 500 && atomic.LoadInt32( &cp.nwrites ) == 0; i++ {		// wait for the write to start
+		time.Sleep( 10 * time.Millisecond )
+	}
+	time.Sleep( 100 * time.Millisecond )					// and to fill the socket buffers
+
+	cdone := make( chan bool )
+	go func() {
+		cm.Close( "r1" )
+		close( cdone )
+	}()
+	select {
+		case <- cdone:
+
+		case <- time.After( 5 * time.Second ):
+			t.Fatalf( "close blocked by a write in progress" )
+	}
+
+	select {
+		case err = <- wdone:
+			if err == nil {
+				t.Errorf( "expected the blocked write to fail when the session was closed" )
+			}
+
+		case <- time.After( 5 * time.Second ):
+			t.Fatalf( "write not ended by close" )
+	}
+}
+
+/*
+	A connection attempt must give up after the timeout; here a TLS handshake is never answered.
+*/
+func TestReconnect_timeout( t *testing.T ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()										// held open, never answered
+		}
+	}()
+
+	cm := NewManager( "", nil )
+	opts := &Reconnect_opts{ Tls: &Tls_opts{ Insecure: true }, Timeout: 200 * time.Millisecond }
+	start := time.Now()
+	if err = cm.Connect_reconnect( l.Addr().String(), "r1", make( chan *Sess_data, 32 ), opts ); err == nil {
+		t.Fatalf( "expected connect to fail" )
+	}
+	if el := time.Since( start ); el > 3 * time.Second {
+		t.Errorf( "connect took %s; timeout not applied", el )
+	}
+}
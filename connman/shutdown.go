@@ -0,0 +1,197 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+ Mnemonic:	shutdown.go
+ Abstract:	Shutdown of the whole manager.  Listeners are closed first so that nothing
+			new is accepted, then (optionally) writes in progress are allowed to finish
+			while new writes are refused, and then every session is closed.  Each
+			session's reader sends ST_DISC to the user as it would for any disconnect,
+			so the user must keep reading the channel(s) until Shutdown returns.
+
+			If the context expires first, sends to the user which are blocked are
+			abandoned so that every goroutine can exit, and the ids of the sessions and
+			listeners which had not finished (whose ST_DISC might not have been
+			delivered, or whose writes were cut off) are returned.  When draining, a
+			reconnecting session which still holds saved writes is also returned as
+			the saved data can't be sent; it is discarded.
+
+ Date:		19 October 2026
+*/
+
+package connman
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+/* -------------- private ------------------------------------------------------- */
+
+/*
+	Note the start of a write; an error is returned if the session is refusing writes. Writes
+	by a datagram reply sender are counted against the session which received the datagram.
+*/
+func (cp *connection) wbegin( ) ( error ) {
+	if cp.parent != nil {
+		cp = cp.parent
+	}
+
+	atomic.AddInt32( &cp.nwrites, 1 )
+	if atomic.LoadInt32( &cp.draining ) != 0 {
+		atomic.AddInt32( &cp.nwrites, -1 )
+		return fmt.Errorf( "session %s is shutting down", cp.id )
+	}
+
+	return nil
+}
+
+/*
+	Note the end of a write.
+*/
+func (cp *connection) wend( ) {
+	if cp.parent != nil {
+		cp = cp.parent
+	}
+
+	atomic.AddInt32( &cp.nwrites, -1 )
+}
+
+/*
+	Wait until no session has a write in progress. Returns false if the context expired first.
+*/
+func wait_writes( ctx context.Context, sessions []*connection ) ( bool ) {
+	for {
+		busy := false
+		for _, cp := range sessions {
+			if atomic.LoadInt32( &cp.nwrites ) > 0 {
+				busy = true
+				break
+			}
+		}
+		if ! busy {
+			return true
+		}
+
+		select {
+			case <- ctx.Done():
+				return false
+
+			case <- time.After( 10 * time.Millisecond ):
+		}
+	}
+}
+
+/* ------ public ---------------------------------------------------- */
+
+/*
+	Shutdown stops all listeners, closes all sessions and waits for all of the manager's goroutines
+	to finish. If drain is true, writes in progress are allowed to complete before the sessions
+	are closed. The user's channels must continue to be read as ST_DISC is sent for each session.
+
+	If the context expires before the shutdown completes, the ids of the sessions and listeners
+	which were forced closed are returned along with the context's error. When draining, the ids
+	of reconnecting sessions whose saved writes were discarded are also returned, with an error
+	if the context had not expired. The manager cannot be used once it has been shut down.
+*/
+func (this *Cmgr) Shutdown( ctx context.Context, drain bool ) ( forced []string, err error ) {
+	if this == nil {
+		return nil, fmt.Errorf( "shutdown: nil manager" )
+	}
+
+	this.clock.Lock()
+	if this.stopping {
+		this.clock.Unlock()
+		return nil, fmt.Errorf( "shutdown: already shut down" )
+	}
+	this.stopping = true
+
+	listeners := make( []net.Listener, 0, len( this.llist ) )
+	for _, l := range this.llist {
+		listeners = append( listeners, l )
+	}
+	this.llist = make( map[string] net.Listener )
+
+	sessions := make( []*connection, 0, len( this.clist ) )
+	for _, cp := range this.clist {
+		sessions = append( sessions, cp )
+	}
+	this.clock.Unlock()
+
+	for _, l := range listeners {				// stop accepting
+		l.Close( )
+	}
+
+	cut := make( map[string] bool )				// ids forced closed
+	for _, cp := range sessions {
+		atomic.StoreInt32( &cp.draining, 1 )
+	}
+	if drain {
+		finished := wait_writes( ctx, sessions )
+		for _, cp := range sessions {
+			if ( ! finished && atomic.LoadInt32( &cp.nwrites ) > 0 ) || cp.rc_pending( ) > 0 {
+				cut[cp.id] = true
+			}
+		}
+	}
+
+	for _, cp := range sessions {
+		this.close_sess( cp )
+	}
+
+	done := make( chan bool )
+	go func( ) {
+		this.wg.Wait( )
+		close( done )
+	}( )
+
+	select {
+		case <- done:
+
+		case <- ctx.Done():
+			this.clock.Lock()
+			for cp := range this.readers {
+				cut[cp.id] = true
+			}
+			for lid := range this.lrunning {
+				cut[lid] = true
+			}
+			this.clock.Unlock()
+
+			close( this.quit )				// abandon blocked sends so the goroutines can finish
+			<- done
+	}
+
+	if len( cut ) > 0 {
+		forced = make( []string, 0, len( cut ) )
+		for id := range cut {
+			forced = append( forced, id )
+		}
+		sort.Strings( forced )
+		if err = ctx.Err( ); err == nil {
+			err = fmt.Errorf( "shutdown: writes saved by reconnecting sessions were discarded" )
+		}
+	}
+
+	return forced, err
+}
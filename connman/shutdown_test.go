@@ -0,0 +1,186 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+package connman
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdown( t *testing.T ) {
+	cm := NewManager( "", nil )
+	ch := make( chan *Sess_data, 64 )
+	port := free_port( t )
+	if _, err := cm.Listen( "tcp", port, "127.0.0.1", ch ); err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	if _, err := cm.Listen_udp( 0, ch ); err != nil {
+		t.Fatalf( "listen_udp: %s", err )
+	}
+
+	for _, id := range []string{ "c1", "c2" } {
+		if err := cm.Connect( "127.0.0.1:" + port, id, ch ); err != nil {
+			t.Fatalf( "connect: %s", err )
+		}
+		cm.Write_str( id, "hello" )
+		if sd := next_data( t, ch ); sd.State != ST_DATA {
+			t.Fatalf( "expected data, got state %d", sd.State )
+		}
+	}
+
+	discs := make( map[string]bool )
+	reading := make( chan bool )
+	go func() {
+		for sd := range ch {
+			if sd.State == ST_DISC {
+				discs[sd.Id] = true
+			}
+		}
+		close( reading )
+	}()
+
+	ctx, cancel := context.WithTimeout( context.Background(), 5 * time.Second )
+	defer cancel()
+	forced, err := cm.Shutdown( ctx, true )
+	if err != nil || len( forced ) != 0 {
+		t.Fatalf( "shutdown: forced=%v err=%v", forced, err )
+	}
+	close( ch )					// safe; no goroutine is left to write
+	<- reading
+
+	for _, id := range []string{ "a1", "a2", "c1", "c2", "u0" } {
+		if ! discs[id] {
+			t.Errorf( "no disconnect received for %s: %v", id, discs )
+		}
+	}
+
+	if _, err = cm.Listen( "tcp", free_port( t ), "127.0.0.1", ch ); err == nil {
+		t.Errorf( "expected listen to fail after shutdown" )
+	}
+	if _, err = cm.Shutdown( ctx, false ); err == nil {
+		t.Errorf( "expected a second shutdown to fail" )
+	}
+}
+
+func TestShutdown_forced( t *testing.T ) {
+	cm := NewManager( "", nil )
+	lch := make( chan *Sess_data, 64 )
+	port := free_port( t )
+	if _, err := cm.Listen( "tcp", port, "127.0.0.1", lch ); err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+
+	stuck := make( chan *Sess_data )						// never read
+	if err := cm.Connect( "127.0.0.1:" + port, "c1", stuck ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), 200 * time.Millisecond )
+	defer cancel()
+	forced, err := cm.Shutdown( ctx, false )
+	if err != context.DeadlineExceeded {
+		t.Fatalf( "expected deadline exceeded, got %v", err )
+	}
+	if len( forced ) != 1 || forced[0] != "c1" {
+		t.Fatalf( "expected c1 to be forced, got %v", forced )
+	}
+}
+
+/*
+	Writes saved by a reconnecting session can't be drained; the session must be reported.
+*/
+func TestShutdown_pending( t *testing.T ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+
+	cm := NewManager( "", nil )
+	ch := make( chan *Sess_data, 32 )
+	opts := &Reconnect_opts{ Min_delay: time.Hour, Buffer: 64 }
+	if err = cm.Connect_reconnect( l.Addr().String(), "r1", ch, opts ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatalf( "accept: %s", err )
+	}
+	l.Close()
+	c.Close()
+
+	next_state( t, ch, ST_RECONNECTING )
+	if err = cm.Write_str( "r1", "saved" ); err != nil {
+		t.Fatalf( "write while reconnecting: %s", err )
+	}
+
+	ctx, cancel := context.WithTimeout( context.Background(), 5 * time.Second )
+	defer cancel()
+	forced, err := cm.Shutdown( ctx, true )
+	if err == nil || len( forced ) != 1 || forced[0] != "r1" {
+		t.Fatalf( "expected r1 to be reported with an error, got forced=%v err=%v", forced, err )
+	}
+}
+
+/*
+	Replies to unix datagrams must be waited for, and then refused, like any other write.
+*/
+func TestShutdown_datagram( t *testing.T ) {
+	if runtime.GOOS != "linux" {
+		t.Skip( "replies to a datagram client need an abstract address" )
+	}
+
+	cm := NewManager( "", nil )
+	lch := make( chan *Sess_data, 32 )
+	path := filepath.Join( t.TempDir(), "d.sock" )
+	id, err := cm.Listen_unix( "unixgram", path, 0, lch )
+	if err != nil {
+		t.Fatalf( "listen_unix: %s", err )
+	}
+	if err = cm.Connect( "unixgram:" + path, "c1", make( chan *Sess_data, 32 ) ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+	cm.Write_str( "c1", "ping" )
+	sd := next_data( t, lch )
+
+	if err = sd.sender.wbegin( ); err != nil {					// a reply in progress
+		t.Fatalf( "wbegin: %s", err )
+	}
+	go func() {
+		for range lch {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout( context.Background(), 200 * time.Millisecond )
+	defer cancel()
+	forced, err := cm.Shutdown( ctx, true )
+	sd.sender.wend( )
+	if err == nil || len( forced ) != 1 || forced[0] != id {
+		t.Fatalf( "expected the datagram session to be forced, got forced=%v err=%v", forced, err )
+	}
+
+	if _, err = sd.Write_str( "pong" ); err == nil || ! strings.Contains( err.Error(), "shutting down" ) {
+		t.Errorf( "expected the reply to be refused as shutting down, got %v", err )
+	}
+}
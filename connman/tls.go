@@ -19,25 +19,59 @@
 
 /*
  Mnemonic:	tls.go
- Abstract:	Functions which support a TLS listener within a connman environment.
+ Abstract:	Functions which support TLS listeners and connections within a connman environment.
+			TLS_listen() uses (or creates) a self-signed certificate and does not verify
+			clients.  TLS_listen_opts() and TLS_connect() accept a Tls_opts struct which
+			provides the certificates, the CA used to verify the peer (mutual TLS when set
+			for a listener), and cipher/version limits.
+
+			The handshake for an accepted session is completed by its reader before the
+			ST_NEW message is sent; a failed handshake is reported with ST_DISC and the
+			reason in Data.  The common name and certificate of a verified peer are given
+			in the Peer and Peer_cert fields of the Sess_data for the session.
 
  Date:		30 October 2016
  Author: 	E. Scott Daniels
 
- Mods:
+ Mods:		19 Oct 2026 - Listener framing is set up (framing.go).
+			19 Oct 2026 - Added TLS connections, mutual TLS and Tls_opts.
 */
 
 package connman
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
 	"strings"
 	"os"
+	"time"
 	"crypto/tls"
+	"crypto/x509"
 
 	"github.com/att/gopkgs/security"
 )
 
+const (
+	TLS_HS_TIMEOUT	time.Duration = 30 * time.Second		// max time allowed to complete a handshake
+)
+
+/*
+	Options for TLS listeners and connections.
+*/
+type Tls_opts struct {
+	Cert_file	string			// our certificate (PEM); required for a listener, sent as the client cert on connect
+	Key_file	string			// the key for the certificate
+	Ca_file		string			// CA bundle (PEM) used to verify the peer; system roots are used on connect if empty
+	Verify_peer	bool			// listener: require a client certificate signed by a CA in Ca_file (mutual TLS)
+	Server_name	string			// connect: name sent (SNI) and verified; defaults to the host in the target
+	Insecure	bool			// connect: don't verify the server's certificate (testing only)
+	Min_version	uint16			// minimum protocol version (tls.VersionTLS1x); TLS 1.2 if 0
+	Ciphers		[]uint16		// allowed cipher suites (TLS 1.2 and earlier); Go's defaults if nil
+}
+
+/* -------------- private ------------------------------------------------------- */
+
 /*
 	Generate a self-singed certificate and key which are plced into the files
 	with the given names passed in.
@@ -98,6 +132,113 @@ func mk_tls_config( certs []tls.Certificate ) ( *tls.Config ) {
 	return base_config
 }
 
+/*
+	Load the PEM certificates in the file into a new pool.
+*/
+func load_ca( fname string ) ( pool *x509.CertPool, err error ) {
+	pem, err := ioutil.ReadFile( fname )
+	if err != nil {
+		return nil, fmt.Errorf( "unable to read CA file: %s", err )
+	}
+
+	pool = x509.NewCertPool( )
+	if ! pool.AppendCertsFromPEM( pem ) {
+		return nil, fmt.Errorf( "no certificates found in CA file: %s", fname )
+	}
+
+	return pool, nil
+}
+
+/*
+	Build a tls configuration from the user's options. Server is true when building for a listener.
+*/
+func (opts *Tls_opts) mk_config( server bool ) ( config *tls.Config, err error ) {
+	config = &tls.Config {
+		MinVersion: tls.VersionTLS12,
+		SessionTicketsDisabled: true,
+	}
+	if opts.Min_version != 0 {
+		config.MinVersion = opts.Min_version
+	}
+	config.CipherSuites = opts.Ciphers
+
+	if opts.Cert_file != "" || opts.Key_file != "" {
+		cert, err := load_cert( &opts.Cert_file, &opts.Key_file )
+		if err != nil {
+			return nil, fmt.Errorf( "unable to load certificate: %s", err )
+		}
+		config.Certificates = []tls.Certificate{ cert }
+	} else {
+		if server {
+			return nil, fmt.Errorf( "a certificate and key are required for a TLS listener" )
+		}
+	}
+
+	var pool *x509.CertPool
+	if opts.Ca_file != "" {
+		if pool, err = load_ca( opts.Ca_file ); err != nil {
+			return nil, err
+		}
+	}
+
+	if server {
+		if opts.Verify_peer {
+			if pool == nil {
+				return nil, fmt.Errorf( "a CA file is required to verify clients" )
+			}
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else {
+		config.RootCAs = pool					// nil uses the host's set
+		config.ServerName = opts.Server_name
+		config.InsecureSkipVerify = opts.Insecure
+	}
+
+	return config, nil
+}
+
+/*
+	Start a tls listener with the configuration.
+*/
+func (this *Cmgr) tls_listen( kind string, port string,  iface string, data2usr chan *Sess_data, config *tls.Config ) ( lid string, err error ) {
+	l, err := tls.Listen( kind, fmt.Sprintf( "%s:%s", iface, port ), config )
+	if err != nil {
+		err = fmt.Errorf( "unable to create a TLS listener on port: %s; %s", port, err )
+		return
+	}
+
+	return this.add_listener( l, data2usr )
+}
+
+/*
+	If the connection is tls, complete the handshake (if not already done) and capture the
+	peer's certificate. Returns an error if the handshake fails.
+*/
+func (cp *connection) tls_handshake( ) ( err error ) {
+	tc, ok := cp.conn.( *tls.Conn )
+	if ! ok {
+		return nil
+	}
+
+	tc.SetDeadline( time.Now().Add( TLS_HS_TIMEOUT ) )
+	err = tc.Handshake( )
+	tc.SetDeadline( time.Time{ } )
+	if err != nil {
+		return fmt.Errorf( "tls handshake failed: %s", err )
+	}
+
+	state := tc.ConnectionState( )
+	if len( state.VerifiedChains ) > 0 {			// only a verified peer is reported
+		cp.peer_cert = state.VerifiedChains[0][0]
+		cp.peer = cp.peer_cert.Subject.CommonName
+	}
+
+	return nil
+}
+
+/* ------ public ---------------------------------------------------- */
+
 /*
 	Starts a listener (TCP or UDP) for tls connections on the indicated port and interface. The
 	data2usr channel is used to pass back connections when accepted in the same manner as is 
@@ -157,18 +298,92 @@ func (this *Cmgr) TLS_listen( kind string, port string,  iface string, data2usr
 	}
 
 	config := mk_tls_config( certs )		// create a configuration
-	
-	lid = ""
-	l, err := tls.Listen( kind, fmt.Sprintf( "%s:%s", iface, port ), config )
+
+	return this.tls_listen( kind, port, iface, data2usr, config )
+}
+
+/*
+	TLS_listen_opts starts a TLS listener in the same manner as TLS_listen() using the certificate
+	and key named in the options. When Verify_peer is set clients must present a certificate signed
+	by a CA in the options' Ca_file (mutual TLS); the client's common name is given in the Peer
+	field of the session data.
+*/
+func (this *Cmgr) TLS_listen_opts( kind string, port string,  iface string, data2usr chan *Sess_data, opts *Tls_opts ) ( lid string, err error ) {
+	if this == nil || opts == nil {
+		return "", fmt.Errorf( "tls_listen_opts: nil manager or options" )
+	}
+
+	if port == ""  || port == "0" {
+		return "", nil
+	}
+
+	config, err := opts.mk_config( true )
+	if err != nil {
+		return "", err
+	}
+
+	return this.tls_listen( kind, port, iface, data2usr, config )
+}
+
+/*
+	TLS_connect establishes a TLS session to the target (host:port) in the same manner as Connect().
+	The server's certificate is verified against the options' Ca_file (the host's CAs if not given)
+	and the name in the target (or Server_name) which is also sent as the SNI. If a certificate and
+	key are given in the options they are presented to the server. Opts may be nil to use the host's
+	CAs and no client certificate. An error is returned if the connection or handshake fails.
+*/
+func (this *Cmgr) TLS_connect( target string, uid string, data2usr chan *Sess_data, opts *Tls_opts ) ( err error ) {
+	if this == nil {
+		return fmt.Errorf( "cannot connect; nil object passed in" )
+	}
+	if opts == nil {
+		opts = &Tls_opts{ }
+	}
+
+	config, err := opts.mk_config( false )
 	if err != nil {
-		err = fmt.Errorf( "unable to create a TLS listener on port: %s; %s", port, err )
 		return
 	}
 
-	lid = fmt.Sprintf( "l%d", this.lcount )
-	this.lcount += 1
+	dialer := &net.Dialer{ Timeout: TLS_HS_TIMEOUT }
+	conn, err := tls.DialWithDialer( dialer, "tcp", target, config )	// handshake is completed before return
+	if err != nil {
+		return fmt.Errorf( "unable to establish TLS session to %s: %s", target, err )
+	}
+
+	cp := new( connection )
+	cp.conn = conn
+	cp.data2usr = data2usr
+	cp.id = uid
+	this.init_framer( cp, "" )
+
+	if err = this.add_sess( cp ); err != nil {
+		conn.Close( )
+		return
+	}
+	go this.conn_reader( cp )
 
-	this.llist[lid] = l
-	go this.listener(  this.llist[lid], data2usr )
 	return
 }
+
+/*
+	Ciphers_by_name converts a list of cipher suite names (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+	to the list of ids for Tls_opts. Insecure suites are not accepted.
+*/
+func Ciphers_by_name( names []string ) ( ids []uint16, err error ) {
+	known := make( map[string]uint16 )
+	for _, cs := range tls.CipherSuites( ) {
+		known[cs.Name] = cs.ID
+	}
+
+	ids = make( []uint16, 0, len( names ) )
+	for _, n := range names {
+		id, ok := known[strings.TrimSpace( n )]
+		if ! ok {
+			return nil, fmt.Errorf( "unknown or insecure cipher suite: %s", n )
+		}
+		ids = append( ids, id )
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,157 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+package connman
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+	Create a certificate signed by the parent (self-signed if parent is nil) and write it, and
+	its key, to dir/name.cert and dir/name.key.
+*/
+func mk_test_cert( t *testing.T, dir string, name string, parent *x509.Certificate, pkey *ecdsa.PrivateKey ) ( *x509.Certificate, *ecdsa.PrivateKey ) {
+	key, err := ecdsa.GenerateKey( elliptic.P256(), rand.Reader )
+	if err != nil {
+		t.Fatalf( "generate key: %s", err )
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt( time.Now().UnixNano() ),
+		Subject: pkix.Name{ CommonName: name },
+		NotBefore: time.Now().Add( -time.Hour ),
+		NotAfter: time.Now().Add( time.Hour ),
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{ x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth },
+		DNSNames: []string{ "localhost" },
+		IPAddresses: []net.IP{ net.ParseIP( "127.0.0.1" ) },
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent = tmpl
+		pkey = key
+	}
+
+	der, err := x509.CreateCertificate( rand.Reader, tmpl, parent, &key.PublicKey, pkey )
+	if err != nil {
+		t.Fatalf( "create certificate: %s", err )
+	}
+	kder, _ := x509.MarshalECPrivateKey( key )
+
+	os.WriteFile( filepath.Join( dir, name + ".cert" ), pem.EncodeToMemory( &pem.Block{ Type: "CERTIFICATE", Bytes: der } ), 0600 )
+	os.WriteFile( filepath.Join( dir, name + ".key" ), pem.EncodeToMemory( &pem.Block{ Type: "EC PRIVATE KEY", Bytes: kder } ), 0600 )
+
+	cert, _ := x509.ParseCertificate( der )
+	return cert, key
+}
+
+/*
+	Return options for the named certificate in dir, verified with the ca.
+*/
+func test_opts( dir string, name string ) ( *Tls_opts ) {
+	opts := &Tls_opts{ Ca_file: filepath.Join( dir, "ca.cert" ) }
+	if name != "" {
+		opts.Cert_file = filepath.Join( dir, name + ".cert" )
+		opts.Key_file = filepath.Join( dir, name + ".key" )
+	}
+	return opts
+}
+
+func TestTLS_mutual( t *testing.T ) {
+	dir := t.TempDir()
+	ca, ca_key := mk_test_cert( t, dir, "ca", nil, nil )
+	mk_test_cert( t, dir, "server", ca, ca_key )
+	mk_test_cert( t, dir, "client1", ca, ca_key )
+	mk_test_cert( t, dir, "rogue", nil, nil )						// not signed by the ca
+
+	cm := NewManager( "", nil )
+	lch := make( chan *Sess_data, 32 )
+	port := free_port( t )
+	sopts := test_opts( dir, "server" )
+	sopts.Verify_peer = true
+	sopts.Min_version = tls.VersionTLS12
+	lid, err := cm.TLS_listen_opts( "tcp", port, "127.0.0.1", lch, sopts )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	defer cm.Close( lid )
+	target := "127.0.0.1:" + port
+
+	cch := make( chan *Sess_data, 32 )
+	if err = cm.TLS_connect( target, "c1", cch, test_opts( dir, "client1" ) ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+
+	var sd *Sess_data
+	for sd = <- lch; sd.State != ST_NEW; sd = <- lch {
+	}
+	if sd.Peer != "client1" || sd.Peer_cert == nil {
+		t.Fatalf( "expected peer client1 on the new session, got %q", sd.Peer )
+	}
+
+	cm.Write_str( "c1", "hello" )
+	if sd = next_data( t, lch ); sd.State != ST_DATA || string( sd.Buf ) != "hello" || sd.Peer != "client1" {
+		t.Fatalf( "expected hello from client1, got state=%d %q peer=%q", sd.State, sd.Buf, sd.Peer )
+	}
+	sd.Write_str( "back" )
+	if sd = next_data( t, cch ); sd.State != ST_DATA || string( sd.Buf ) != "back" || sd.Peer != "server" {
+		t.Fatalf( "expected reply from server, got state=%d %q peer=%q", sd.State, sd.Buf, sd.Peer )
+	}
+
+	if err = cm.TLS_connect( target, "c2", cch, test_opts( dir, "rogue" ) ); err == nil {	// tls 1.3 may report this at the server only
+		if sd = next_data( t, lch ); sd.State != ST_DISC || sd.Data == "" {
+			t.Fatalf( "expected the server to reject the rogue client, got state=%d", sd.State )
+		}
+	}
+
+	if err = cm.TLS_connect( target, "c3", cch, &Tls_opts{ } ); err == nil {					// server not signed by a host CA
+		t.Fatalf( "expected connect to fail verification against the host's CAs" )
+	}
+
+	opts := test_opts( dir, "client1" )
+	opts.Server_name = "not.this.host"
+	if err = cm.TLS_connect( target, "c4", cch, opts ); err == nil {
+		t.Fatalf( "expected connect to fail verification of the server name" )
+	}
+}
+
+func TestCiphers_by_name( t *testing.T ) {
+	ids, err := Ciphers_by_name( []string{ "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" } )
+	if err != nil || len( ids ) != 1 || ids[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf( "unexpected result: %v %v", ids, err )
+	}
+
+	if _, err = Ciphers_by_name( []string{ "TLS_RSA_WITH_RC4_128_SHA" } ); err == nil {
+		t.Errorf( "expected an insecure suite to be refused" )
+	}
+}
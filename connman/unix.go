@@ -0,0 +1,215 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+ Mnemonic:	unix.go
+ Abstract:	Unix domain socket support.  Stream (unix) and sequenced packet (unixpacket)
+			sockets behave exactly as TCP: each accepted connection is a session with
+			ST_ACCEPTED, ST_NEW, ST_DATA and ST_DISC messages and can be written to by
+			id or via the Sess_data.
+
+			A datagram (unixgram) listener is a single session, like a UDP listener,
+			with one ST_DATA message per datagram. The Sess_data for a datagram is bound
+			to the sender so that its Write functions reply to the sender; From is the
+			sender's address and is empty if the sender's socket isn't bound (no reply
+			is then possible).  A datagram connection is bound to an abstract address
+			(linux) so that the remote side can reply.
+
+			A path starting with '@' is in the abstract namespace (linux) and has no
+			file.  Otherwise an existing socket file that nothing is listening on (or
+			for a datagram socket, bound to) is removed before binding, and the file is
+			removed when the listener is closed.
+
+ Date:		19 October 2026
+*/
+
+package connman
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var gram_count int64 = 0			// for unique local datagram addresses
+
+/* -------------- private ------------------------------------------------------- */
+
+/*
+	Returns true if the kind is one of the unix domain socket types.
+*/
+func is_unix( kind string ) ( bool ) {
+	return kind == "unix" || kind == "unixpacket" || kind == "unixgram"
+}
+
+/*
+	Split a connect target into the network type and address. Targets of the form
+	unix:path, unixpacket:path and unixgram:path, or which start with / or @ (unix stream),
+	are unix domain sockets; anything else is tcp.
+*/
+func split_target( target string ) ( kind string, addr string ) {
+	if i := strings.Index( target, ":" ); i > 0 && is_unix( target[0:i] ) {
+		return target[0:i], target[i+1:]
+	}
+
+	if strings.HasPrefix( target, "/" ) || strings.HasPrefix( target, "@" ) {
+		return "unix", target
+	}
+
+	return "tcp", target
+}
+
+/*
+	Remove a socket file left by a previous process. The socket is probed with a connect and
+	the file is removed only if the connect is refused (nothing is listening on, or for a
+	datagram socket bound to, the file).  A connect which succeeds, or which fails for any
+	other reason (e.g. a full backlog or no permission), leaves the file in place and an
+	error is returned as the socket may still be in use.
+*/
+func rm_stale( kind string, path string ) ( err error ) {
+	if strings.HasPrefix( path, "@" ) {
+		return nil
+	}
+
+	fi, err := os.Lstat( path )
+	if err != nil {
+		return nil										// doesn't exist
+	}
+	if fi.Mode() & os.ModeSocket == 0 {
+		return fmt.Errorf( "%s exists and is not a socket", path )
+	}
+
+	var c net.Conn
+	if kind == "unixgram" {
+		c, err = net.DialUnix( kind, nil, &net.UnixAddr{ Name: path, Net: kind } )
+	} else {
+		c, err = net.DialTimeout( kind, path, time.Second )
+	}
+	if err == nil {
+		c.Close()
+		return fmt.Errorf( "%s is in use", path )
+	}
+	if ! errors.Is( err, syscall.ECONNREFUSED ) {
+		return fmt.Errorf( "unable to tell if %s is in use: %s", path, err )
+	}
+
+	return os.Remove( path )
+}
+
+/*
+	Return a local address for an outbound datagram socket so that the remote side can reply.
+	Abstract addresses are only supported by linux; elsewhere the socket is left unbound.
+*/
+func gram_laddr( ) ( *net.UnixAddr ) {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	n := atomic.AddInt64( &gram_count, 1 )
+	return &net.UnixAddr{ Name: fmt.Sprintf( "@connman.%d.%d", os.Getpid(), n ), Net: "unixgram" }
+}
+
+/*
+	Establish a connection to a unix domain socket.
+*/
+func dial_unix( kind string, path string ) ( conn net.Conn, err error ) {
+	raddr := &net.UnixAddr{ Name: path, Net: kind }
+
+	var laddr *net.UnixAddr
+	if kind == "unixgram" {
+		laddr = gram_laddr( )
+	}
+
+	return net.DialUnix( kind, laddr, raddr )
+}
+
+/* ------ public ---------------------------------------------------- */
+
+/*
+	Listen_unix starts a unix domain socket listener on the path. Kind is unix (stream), unixpacket or
+	unixgram (datagram). If perm is not zero the socket file's permissions are set to it (the socket
+	is briefly available with the permissions given by the umask). Data is written to the data2usr
+	channel in the same way as for TCP listeners (see the abstract in unix.go for datagrams).
+
+	The id returned is a listener id for stream sockets, and a session id for a datagram socket;
+	either can be given to Close().
+*/
+func (this *Cmgr) Listen_unix( kind string, path string, perm os.FileMode, data2usr chan *Sess_data ) ( id string, err error ) {
+	if this == nil {
+		return "", fmt.Errorf( "listen_unix: nil manager" )
+	}
+	if ! is_unix( kind ) {
+		return "", fmt.Errorf( "listen_unix: unknown socket kind: %s", kind )
+	}
+	if path == "" {
+		return "", fmt.Errorf( "listen_unix: socket path must not be empty" )
+	}
+
+	if err = rm_stale( kind, path ); err != nil {
+		return "", fmt.Errorf( "unable to create a listener on %s: %s", path, err )
+	}
+
+	var l net.Listener
+	var pconn *net.UnixConn
+	if kind == "unixgram" {
+		pconn, err = net.ListenUnixgram( kind, &net.UnixAddr{ Name: path, Net: kind } )
+	} else {
+		l, err = net.Listen( kind, path )
+	}
+	if err != nil {
+		return "", fmt.Errorf( "unable to create a listener on %s: %s", path, err )
+	}
+
+	if perm != 0 && ! strings.HasPrefix( path, "@" ) {
+		if err = os.Chmod( path, perm ); err != nil {
+			if l != nil {
+				l.Close()
+			} else {
+				pconn.Close()
+				os.Remove( path )
+			}
+			return "", fmt.Errorf( "unable to set permissions on %s: %s", path, err )
+		}
+	}
+
+	if pconn == nil {
+		return this.add_listener( l, data2usr )
+	}
+
+	cp := new( connection )
+	cp.pconn = pconn
+	cp.data2usr = data2usr
+	cp.id = this.mk_id( "u" )			// datagram sockets share the udp id space
+	if ! strings.HasPrefix( path, "@" ) {
+		cp.upath = path
+	}
+	if err = this.add_sess( cp ); err != nil {
+		this.close_sess( cp )
+		return "", err
+	}
+	go this.conn_reader( cp )
+
+	return cp.id, nil
+}
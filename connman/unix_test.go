@@ -0,0 +1,207 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+package connman
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+/*
+	Connect to the listener at target, send a message and check that it arrives and that the
+	reply written via the session data comes back.
+*/
+func echo_check( t *testing.T, cm *Cmgr, lch chan *Sess_data, target string, uid string ) {
+	cch := make( chan *Sess_data, 32 )
+	if err := cm.Connect( target, uid, cch ); err != nil {
+		t.Fatalf( "connect to %s: %s", target, err )
+	}
+
+	if err := cm.Write_str( uid, "ping" ); err != nil {
+		t.Fatalf( "write to %s: %s", target, err )
+	}
+	sd := next_data( t, lch )
+	if sd.State != ST_DATA || string( sd.Buf ) != "ping" {
+		t.Fatalf( "%s: expected ping, got state=%d %q", target, sd.State, sd.Buf )
+	}
+
+	if _, err := sd.Write_str( "pong" ); err != nil {
+		t.Fatalf( "%s: reply: %s", target, err )
+	}
+	if sd = next_data( t, cch ); sd.State != ST_DATA || string( sd.Buf ) != "pong" {
+		t.Fatalf( "%s: expected pong, got state=%d %q", target, sd.State, sd.Buf )
+	}
+
+	cm.Close( uid )
+	if ! strings.HasPrefix( target, "unixgram:" ) {				// accepted session must see the disconnect
+		if sd = next_data( t, lch ); sd.State != ST_DISC {
+			t.Fatalf( "%s: expected disconnect, got state=%d", target, sd.State )
+		}
+	}
+}
+
+func TestUnix_stream( t *testing.T ) {
+	cm := NewManager( "", nil )
+	lch := make( chan *Sess_data, 32 )
+	path := filepath.Join( t.TempDir(), "s.sock" )
+
+	stale, err := net.Listen( "unix", path )			// leave a stale socket file behind
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	stale.( *net.UnixListener ).SetUnlinkOnClose( false )
+	stale.Close()
+
+	lid, err := cm.Listen_unix( "unix", path, 0660, lch )
+	if err != nil {
+		t.Fatalf( "listen_unix: %s", err )
+	}
+	if fi, err := os.Stat( path ); err != nil || fi.Mode().Perm() != 0660 {
+		t.Fatalf( "expected socket permissions 0660: %v %v", fi, err )
+	}
+	if _, err = cm.Listen_unix( "unix", path, 0, lch ); err == nil {
+		t.Fatalf( "expected a second listener on an active socket to fail" )
+	}
+	if sd := next_data( t, lch ); sd.State != ST_DISC {		// the in use check connects and drops
+		t.Fatalf( "expected disconnect of the in use check, got state=%d", sd.State )
+	}
+
+	echo_check( t, cm, lch, path, "c1" )
+	echo_check( t, cm, lch, "unix:" + path, "c2" )
+
+	cm.Close( lid )
+	if _, err = os.Stat( path ); err == nil {
+		t.Fatalf( "socket file not removed on close" )
+	}
+
+	ppath := filepath.Join( t.TempDir(), "p.sock" )		// seqpacket via Listen()
+	if lid, err = cm.Listen( "unixpacket", ppath, "", lch ); err != nil {
+		t.Fatalf( "listen unixpacket: %s", err )
+	}
+	echo_check( t, cm, lch, "unixpacket:" + ppath, "c3" )
+	cm.Close( lid )
+
+	if runtime.GOOS == "linux" {
+		apath := fmt.Sprintf( "@connman_test.%d", os.Getpid() )
+		if lid, err = cm.Listen( "unix", apath, "", lch ); err != nil {
+			t.Fatalf( "listen abstract: %s", err )
+		}
+		echo_check( t, cm, lch, apath, "c4" )
+		cm.Close( lid )
+	}
+}
+
+func TestUnix_datagram( t *testing.T ) {
+	if runtime.GOOS != "linux" {
+		t.Skip( "replies to a datagram client need an abstract address" )
+	}
+
+	cm := NewManager( "", nil )
+	lch := make( chan *Sess_data, 32 )
+	path := filepath.Join( t.TempDir(), "d.sock" )
+
+	stale, err := net.ListenUnixgram( "unixgram", &net.UnixAddr{ Name: path, Net: "unixgram" } )
+	if err != nil {
+		t.Fatalf( "listen: %s", err )
+	}
+	stale.Close()											// leaves the socket file behind
+
+	id, err := cm.Listen_unix( "unixgram", path, 0600, lch )
+	if err != nil {
+		t.Fatalf( "listen_unix: %s", err )
+	}
+	if _, err = cm.Listen_unix( "unixgram", path, 0, lch ); err == nil {
+		t.Fatalf( "expected a second listener on an active datagram socket to fail" )
+	}
+	if _, err = os.Stat( path ); err != nil {
+		t.Fatalf( "active datagram socket file was removed: %s", err )
+	}
+
+	cm.Set_framing( "", FR_NEWLINE, 0 )							// datagrams must not be framed
+	echo_check( t, cm, lch, "unixgram:" + path, "c1" )
+
+	if err = cm.Connect( "unixgram:" + path, "c2", make( chan *Sess_data, 32 ) ); err != nil {
+		t.Fatalf( "connect: %s", err )
+	}
+	if err = cm.Set_framing( "c2", FR_LENGTH, 0 ); err == nil {
+		t.Fatalf( "expected set_framing on a datagram connection to fail" )
+	}
+	cm.Close( "c2" )
+
+	if err = cm.Write_str( id, "x" ); err == nil {
+		t.Fatalf( "expected a write by id to the datagram listener to fail" )
+	}
+
+	cm.Close( id )
+	if _, err = os.Stat( path ); err == nil {
+		t.Fatalf( "socket file not removed on close" )
+	}
+}
+
+/*
+	A listener which refuses a connect for a reason other than nothing listening (here a full
+	backlog) must keep its socket file.
+*/
+func TestUnix_busy( t *testing.T ) {
+	if runtime.GOOS != "linux" {
+		t.Skip( "relies on linux returning EAGAIN for a full unix socket backlog" )
+	}
+
+	path := filepath.Join( t.TempDir(), "busy.sock" )
+	fd, err := syscall.Socket( syscall.AF_UNIX, syscall.SOCK_STREAM, 0 )
+	if err != nil {
+		t.Fatalf( "socket: %s", err )
+	}
+	defer syscall.Close( fd )
+	if err = syscall.Bind( fd, &syscall.SockaddrUnix{ Name: path } ); err != nil {
+		t.Fatalf( "bind: %s", err )
+	}
+	if err = syscall.Listen( fd, 0 ); err != nil {				// never accepts
+		t.Fatalf( "listen: %s", err )
+	}
+
+	full := false
+	for i := 0; i < 16 && ! full; i++ {							// fill the backlog
+		c, err := net.DialTimeout( "unix", path, time.Second )
+		if err != nil {
+			full = true
+			break
+		}
+		defer c.Close()
+	}
+	if ! full {
+		t.Skip( "unable to fill the listen backlog" )
+	}
+
+	cm := NewManager( "", nil )
+	if _, err = cm.Listen_unix( "unix", path, 0, make( chan *Sess_data, 8 ) ); err == nil {
+		t.Fatalf( "expected listen on a busy socket to fail" )
+	}
+	if _, err = os.Stat( path ); err != nil {
+		t.Fatalf( "busy socket file was removed: %s", err )
+	}
+}
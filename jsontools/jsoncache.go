@@ -25,6 +25,8 @@
 				Caller can use to read packets from the wire until a complete structure is
 				discovered. If the first part of a second json struct is in the last packet
 				it remains in the cache after the first complete struct is returned.
+				Braces within strings (including those following an escaped quote) are
+				not counted.
 	Date:		16 December 2013
 	Author:		E. Scott Daniels
 */
@@ -44,6 +46,8 @@ type Jsoncache struct {
 	nxt		int		// starting point for next get check
 	len		int
 	insrt	int		// insertion point
+	in_str	bool	// nxt is inside a string
+	esc		bool	// previous byte in the string was a backslash
 }
 
 func Mk_jsoncache( ) ( jc *Jsoncache ) {
@@ -95,7 +99,26 @@ func (jc *Jsoncache)  Get_blob( ) ( blob []byte ) {
 	blob = nil
 
 	for ; jc.nxt < jc.insrt; jc.nxt++ {
+		if jc.in_str {
+			switch {
+				case jc.esc:
+					jc.esc = false
+
+				case jc.buf[jc.nxt] == '\\':
+					jc.esc = true
+
+				case jc.buf[jc.nxt] == '"':
+					jc.in_str = false
+			}
+			continue
+		}
+
 		switch( jc.buf[jc.nxt] ) {
+			case '"':
+					if jc.open > 0 {
+						jc.in_str = true
+					}
+
 			case '{':	
 					jc.open++
 					encountered_brace = true
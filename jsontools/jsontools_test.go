@@ -154,6 +154,32 @@ json = `{
 }
 
 
+/*
+	Braces inside strings, and quotes escaped inside strings, must not end a blob early.
+*/
+func TestJsoncache_strings( t *testing.T ) {
+	jc := jsontools.Mk_jsoncache( )
+	input := `{ "a": "}{" } { "b": "x\"}" } { "c": "\\" }`
+	expect := []string{ `{ "a": "}{" }`, `{ "b": "x\"}" }`, `{ "c": "\\" }` }
+
+	got := make( []string, 0, len( expect ) )
+	for i := 0; i < len( input ); i += 3 {				// a few bytes at a time
+		end := i + 3
+		if end > len( input ) {
+			end = len( input )
+		}
+		jc.Add_bytes( []byte( input[i:end] ) )
+
+		for blob := jc.Get_blob( ); blob != nil; blob = jc.Get_blob( ) {
+			got = append( got, strings.TrimSpace( string( blob ) ) )
+		}
+	}
+
+	if strings.Join( got, "|" ) != strings.Join( expect, "|" ) {
+		t.Errorf( "blobs split wrongly:\n got %q\nwant %q", got, expect )
+	}
+}
+
 /*
 	Test the jtree functions
 */
@@ -42,6 +42,11 @@
 				15 Jul 2105 - Emit correct tag in the unpack debugging.
 				17 Dec 2015 - Change to add ability to list only L3 hosts.
 				16 Aug 2016 - Add new structs to handle version 3
+				19 Oct 2026 - Added send_unpacked() to support non-GET requests; json scan is
+							skipped for no-content responses.
+							Send_req now records per service/operation metrics.
+							Send_req now honours rate/in-flight limits and retries after a 429.
+							Added structs for neutron create requests.
 ------------------------------------------------------------------------------------------------
 */
 
@@ -284,11 +289,17 @@ type ost_pool struct {
 	Start	string
 }
 
+type ost_host_route struct {
+	Destination		string
+	Nexthop			string
+}
+
 type ost_subnet struct {
 	Allocation_pools []ost_pool
 	Cidr 			string
 	Gateway_ip 		string
-	//Host_routes 	[]string	// this is not an array of string that comes back from openstack -- array of objects
+	Host_routes 	[]ost_host_route
+	Ip_version		int
 	Name 			string
 	Id 				string		// this is the id listed in output from v2/networks in the subnet list
 	Network_id 		string		// who knows what this ID really is
@@ -477,15 +488,19 @@ type generic_response struct {
 	Aggregates	[]ost_aggregate
 	Error		*error_obj
 	Forbidden	*error_obj					// couldn't this have been bundled in error?
+	Neutronerror *error_obj					// and neutron has its own name for it too
 	Hosts		[]ost_os_host
     Interfaceattachments	 []ost_ifattach
+	Network		*ost_network				// from create/update requests
 	Networks 	[]ost_network
+	Port_id		string						// from add_router_interface
 	Ports		[]Ost_os_port
 	Roles		[]ost_role
 	Routers		[]ost_router				// from v2.0/routers
 	Router		*ost_router					// from v2.0/routers/<routerid>/l3-agent
 	Servers		[]ost_vm_server
 	Services	[]ost_service				// list of services from os-service
+	Subnet		*ost_subnet					// from create/update requests
 	Subnets		[]ost_subnet
 	Tenants		[]ost_tenant
 	Agents		[]ost_net_agent
@@ -518,6 +533,8 @@ type Ostack struct {
 	tok_isadmin	map[string]bool	// maps token to whether or not it was identified as an admin
 	isadmin	bool				// true if the authorised user associated with the struct is an admin
 	version int				// to differentiate between identity version 2.0 and 3
+	metrics	*ost_metrics	// request counts and latency; shared with Dup()'d copies
+	limits	*ost_limits		// rate and in-flight limits; shared with Dup()'d copies
 }
 
 /*
@@ -603,6 +620,8 @@ func Mk_ostack_region( host *string, user *string, passwd *string, project *stri
 	}
 
 	o.tok_isadmin = make( map[string]bool )
+	o.metrics = mk_metrics( )
+	o.limits = mk_limits( )
 
 	return
 }
@@ -616,11 +635,16 @@ func Mk_ostack( host *string, user *string, passwd *string, project *string ) (
 
 /*
 	Duplicate the object adding the project name passed and then authorise to get a token
-	and to pick up chost information for the project.
+	and to pick up chost information for the project. The duplicate shares the
+	metrics and rate limits of the original.
 */
 func (o *Ostack) Dup(  project *string ) ( dup *Ostack, err error ) {
 
 	dup = Mk_ostack_region( o.host, o.user, o.passwd, project, o.aregion )
+	if dup != nil {
+		dup.metrics = o.metrics
+		dup.limits = o.limits
+	}
 
 	return
 }
@@ -660,36 +684,76 @@ const (								// reset iota
 func (o *Ostack) Send_req( method string, url *string, data *bytes.Buffer ) (jdata []byte, headers map[string][]string, err error) {
 	var (
 		req 	*http.Request
+		resp	*http.Response
 		rsrc	*http.Client		// request source
 		stime	int64
+		payload	[]byte				// request body; saved so it can be resent after a 429
+		sem		chan bool			// in flight slot held while the request is outstanding
+		all_sem	chan bool			// in flight slot for the all services limit
 	)
 
 	jdata = nil;
 	headers = nil
 
-	req, err = http.NewRequest( method, *url, data )
-	if err != nil {
-		fmt.Fprintf( os.Stderr, "error making request for %s to %s\n", method, *url )
-		return
+	if data != nil {
+		payload = data.Bytes()
 	}
 
-	req.Header.Add( "Content-Type", "application/json" )
-	if o.token != nil {											// authorisation won't have a token
-		if len( *o.token ) > 100 {
-			req.Header.Add( "X-Auth-Token", *o.small_tok )		// use compressed token
-		} else {
-			req.Header.Add( "X-Auth-Token", *o.token )
-		}
+	svc, op := o.svc_op( method, *url )
+	limiter := o.limits.get( svc, false )
+	all_limiter := o.limits.get( ALL_SERVICES, false )
+	retries := 0
+	if o.limits != nil {
+		o.limits.mu.Lock()
+		retries = o.limits.retries
+		o.limits.mu.Unlock()
 	}
 
 	rsrc = &http.Client{}
-	if debug_latency {
+	for attempt := 0; ; attempt++ {
+		req, err = http.NewRequest( method, *url, bytes.NewReader( payload ) )
+		if err != nil {
+			fmt.Fprintf( os.Stderr, "error making request for %s to %s\n", method, *url )
+			return
+		}
+
+		req.Header.Add( "Content-Type", "application/json" )
+		if o.token != nil {											// authorisation won't have a token
+			if len( *o.token ) > 100 {
+				req.Header.Add( "X-Auth-Token", *o.small_tok )		// use compressed token
+			} else {
+				req.Header.Add( "X-Auth-Token", *o.token )
+			}
+		}
+
+		sem = limiter.acquire()						// blocks if rate or in-flight limits are reached
+		all_sem = all_limiter.acquire()				// the service must also fit under the overall limits
 		stime = time.Now().UnixNano()
-	}
-	resp, err := rsrc.Do( req )
-	if debug_latency {
+		resp, err = rsrc.Do( req )
 		stime = time.Now().UnixNano() - stime			// delta
-		fmt.Fprintf( os.Stderr, "[DBUG] ostack latency: %.3fs %5s: %s\n", float64( stime )/1000000000, method, *url )
+		if debug_latency {
+			fmt.Fprintf( os.Stderr, "[DBUG] ostack latency: %.3fs %5s: %s\n", float64( stime )/1000000000, method, *url )
+		}
+
+		code := 0
+		if err == nil {
+			code = resp.StatusCode
+		}
+		o.metrics.record( svc, op, code, time.Duration( stime ) )
+
+		if err != nil || code != http.StatusTooManyRequests || attempt >= retries {
+			break
+		}
+
+		wait := retry_after( resp.Header.Get( "Retry-After" ) )		// too many requests; pause and try again
+		ioutil.ReadAll( resp.Body )
+		resp.Body.Close( )
+		release( all_sem )
+		release( sem )
+		if debug_latency {
+			fmt.Fprintf( os.Stderr, "[DBUG] ostack 429 received, retry in %s %5s: %s\n", wait, method, *url )
+		}
+		time.Sleep( wait )
 	}
 
 	if err == nil {
@@ -702,10 +766,14 @@ func (o *Ostack) Send_req( method string, url *string, data *bytes.Buffer ) (jda
 			o.version = 3
 		}
 
-		err = scanj4gook( jdata )				// quick scan to see if there are bad things in the json
+		if resp.StatusCode != http.StatusNoContent {		// put/delete requests might legitimately return nothing
+			err = scanj4gook( jdata )				// quick scan to see if there are bad things in the json
+		}
 	} else {
 		fmt.Fprintf( os.Stderr, "ostack/Send_req: received err response %s\n", err )
 	}
+	release( all_sem )
+	release( sem )
 
 	return
 }
@@ -715,15 +783,28 @@ func (o *Ostack) Send_req( method string, url *string, data *bytes.Buffer ) (jda
 	structure passed in. Tag is used for error reporting and debugging info written to stderr.
 */
 func (o *Ostack) get_unpacked( url string, body *bytes.Buffer, resp interface{}, tag string ) ( err error ) {
+	return o.send_unpacked( "GET", url, body, resp, tag )
+}
+
+/*
+	Sends the request using the method given (POST, PUT, PATCH, DELETE...) unpacking the resulting
+	json into the structure passed in.  If openstack returns nothing (e.g. a 204 in response to a
+	delete) the response struct is left untouched and no error is returned.
+*/
+func (o *Ostack) send_unpacked( method string, url string, body *bytes.Buffer, resp interface{}, tag string ) ( err error ) {
 
 	dump_url( tag, 10, url )
-	jdata, _, e := o.Send_req( "GET",  &url, body )
+	jdata, _, e := o.Send_req( method,  &url, body )
 	dump_json( tag, 10, jdata )
 
 	if e != nil {
 		return e
 	}
 
+	if len( jdata ) == 0 {
+		return
+	}
+
 	err = json.Unmarshal( jdata, resp )			// unpack the json into response struct
 	if err != nil {
 		dump_json( tag, 90, jdata )				// dump the offending json up to 90 times
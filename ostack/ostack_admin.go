@@ -0,0 +1,480 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+------------------------------------------------------------------------------------------------
+	Mnemonic:	ostack_admin
+	Abstract:	Keystone (identity v3) administration functions: create and disable projects,
+				create users, assign and revoke roles on projects and domains, and list
+				role assignments.  All of these require that the credentials in the
+				ostack struct were identified as admin credentials when authorised (see
+				Isadmin()).
+
+				Doc: http://developer.openstack.org/api-ref-identity-v3.html
+
+	Date:		19 October 2026
+------------------------------------------------------------------------------------------------
+*/
+
+package ostack
+
+import (
+	"bytes"
+	"fmt"
+)
+
+/*
+	Project information returned by the create/enable project functions.
+*/
+type Ostack_project struct {
+	Id			string
+	Name		string
+	Domain_id	string
+	Description	string
+	Enabled		bool
+}
+
+/*
+	User information returned by the create user function.
+*/
+type Ostack_user struct {
+	Id			string
+	Name		string
+	Domain_id	string
+	Project_id	string			// default project
+	Email		string
+	Enabled		bool
+}
+
+/*
+	A single role assignment. Either the user or group fields are filled in, and either
+	the project or domain fields depending on the scope of the assignment.  Names are
+	filled in only if openstack returns them.
+*/
+type Ostack_role_asgn struct {
+	Role_id		string
+	Role_name	string
+	User_id		string
+	User_name	string
+	Group_id	string
+	Project_id	string
+	Project_name	string
+	Domain_id	string
+	Domain_name	string
+}
+
+// ----- internal support -------------------------------------------------------------------
+
+/*
+	Validate the credentials (reauthorising if needed) and ensure that they have admin privs.
+	The admin functions use the v3 interface, so credentials which were authorised with v2
+	are rejected.
+*/
+func (o *Ostack) validate_admin( tag string ) ( err error ) {
+	if o == nil ||  o.user == nil || o.passwd == nil {
+		return fmt.Errorf( "%s: no openstack object to work on, or missing data inside", tag )
+	}
+
+	if o.version != 3 {
+		return fmt.Errorf( "%s: credentials for %s were not authorised with identity v3", tag, *o.user )
+	}
+
+	err = o.Validate_auth_v3()
+	if err != nil {
+		return
+	}
+
+	if ! o.Isadmin() {
+		err = fmt.Errorf( "%s: credentials for %s do not have admin privileges", tag, *o.user )
+	}
+
+	return
+}
+
+/*
+	Return the url of the identity service to use for admin requests. Preference is the
+	admin url, then the internal url, and finally the host that was used to authorise.
+	The url is returned without a version and with a trailing slant.
+*/
+func (o *Ostack) identity_url( ) ( string ) {
+	if o.iahost != nil && *o.iahost != "" {
+		return *o.iahost
+	}
+	if o.ihost != nil && *o.ihost != "" {
+		return *o.ihost
+	}
+
+	return *o.host
+}
+
+/*
+	Return the domain id that should be used; default if one isn't given.
+*/
+func domain_or_default( domain *string ) ( string ) {
+	if domain == nil || *domain == "" {
+		return "default"
+	}
+
+	return *domain
+}
+
+/*
+	Convert an openstack project into our project struct.
+*/
+func mk_project( p *osv3_proj ) ( *Ostack_project ) {
+	if p == nil {
+		return nil
+	}
+
+	return &Ostack_project {
+		Id:			p.Id,
+		Name:		p.Name,
+		Domain_id:	p.Domain_id,
+		Description: p.Description,
+		Enabled:	p.Enabled,
+	}
+}
+
+/*
+	Sends a role grant or revoke (method is PUT or DELETE) for the user and role on the
+	project or domain (stype is projects or domains) given.
+*/
+func (o *Ostack) role_grant( method string, stype string, sid *string, uid *string, rid *string, tag string ) ( err error ) {
+	var (
+		resp osv3_generic
+	)
+
+	err = o.validate_admin( tag )
+	if err != nil {
+		return
+	}
+
+	if sid == nil || uid == nil || rid == nil {
+		return fmt.Errorf( "%s: scope, user and role ids must all be supplied", tag )
+	}
+
+	body := bytes.NewBufferString( "" )
+	url := fmt.Sprintf( "%sv3/%s/%s/users/%s/roles/%s", o.identity_url(), stype, *sid, *uid, *rid )
+	err = o.send_unpacked( method, url, body, &resp, tag )
+	if err != nil {
+		return
+	}
+
+	if resp.Error != nil {
+		err = fmt.Errorf( "%s failed: %s", tag, resp.Error )
+	}
+
+	return
+}
+
+// ------------------- public ----------------------------------------------------------------
+
+/*
+	Create a project in the named domain (default if domain is nil).  The description may be nil.
+	The project is created in the enabled state.
+*/
+func (o *Ostack) Create_project( name *string, domain *string, desc *string ) ( proj *Ostack_project, err error ) {
+	var (
+		resp osv3_generic
+	)
+
+	err = o.validate_admin( "create-project" )
+	if err != nil {
+		return
+	}
+
+	if name == nil || *name == "" {
+		err = fmt.Errorf( "create-project: project name was not supplied" )
+		return
+	}
+
+	dstr := ""
+	if desc != nil {
+		dstr = *desc
+	}
+
+	rjson := fmt.Sprintf( `{ "project": { "name": %q, "domain_id": %q, "description": %q, "enabled": true } }`, *name, domain_or_default( domain ), dstr )
+	body := bytes.NewBufferString( rjson )
+	url := o.identity_url() + "v3/projects"
+	err = o.send_unpacked( "POST", url, body, &resp, "create-project" )
+	if err != nil {
+		return
+	}
+
+	if resp.Error != nil {
+		err = fmt.Errorf( "create-project failed: %s", resp.Error )
+		return
+	}
+
+	if resp.Project == nil {
+		err = fmt.Errorf( "create-project: openstack response did not contain project data" )
+		return
+	}
+
+	proj = mk_project( resp.Project )
+	return
+}
+
+/*
+	Set the enabled state of the project (by id).  The updated project information is returned.
+*/
+func (o *Ostack) Enable_project( pid *string, state bool ) ( proj *Ostack_project, err error ) {
+	var (
+		resp osv3_generic
+	)
+
+	err = o.validate_admin( "enable-project" )
+	if err != nil {
+		return
+	}
+
+	if pid == nil || *pid == "" {
+		err = fmt.Errorf( "enable-project: project id was not supplied" )
+		return
+	}
+
+	rjson := fmt.Sprintf( `{ "project": { "enabled": %v } }`, state )
+	body := bytes.NewBufferString( rjson )
+	url := fmt.Sprintf( "%sv3/projects/%s", o.identity_url(), *pid )
+	err = o.send_unpacked( "PATCH", url, body, &resp, "enable-project" )
+	if err != nil {
+		return
+	}
+
+	if resp.Error != nil {
+		err = fmt.Errorf( "enable-project failed: %s", resp.Error )
+		return
+	}
+
+	proj = mk_project( resp.Project )
+	return
+}
+
+/*
+	Disable the project (by id). Convenience wrapper for Enable_project().
+*/
+func (o *Ostack) Disable_project( pid *string ) ( proj *Ostack_project, err error ) {
+	return o.Enable_project( pid, false )
+}
+
+/*
+	Create a user in the given domain (default if nil). The default project (id) and email
+	address are optional and may be nil.
+*/
+func (o *Ostack) Create_user( name *string, passwd *string, domain *string, def_project *string, email *string ) ( usr *Ostack_user, err error ) {
+	var (
+		resp osv3_generic
+	)
+
+	err = o.validate_admin( "create-user" )
+	if err != nil {
+		return
+	}
+
+	if name == nil || *name == "" || passwd == nil {
+		err = fmt.Errorf( "create-user: user name or password was not supplied" )
+		return
+	}
+
+	opt := ""
+	if def_project != nil && *def_project != "" {
+		opt += fmt.Sprintf( `, "default_project_id": %q`, *def_project )
+	}
+	if email != nil && *email != "" {
+		opt += fmt.Sprintf( `, "email": %q`, *email )
+	}
+
+	rjson := fmt.Sprintf( `{ "user": { "name": %q, "password": %q, "domain_id": %q, "enabled": true%s } }`, *name, *passwd, domain_or_default( domain ), opt )
+	body := bytes.NewBufferString( rjson )
+	url := o.identity_url() + "v3/users"
+	err = o.send_unpacked( "POST", url, body, &resp, "create-user" )
+	if err != nil {
+		return
+	}
+
+	if resp.Error != nil {
+		err = fmt.Errorf( "create-user failed: %s", resp.Error )
+		return
+	}
+
+	if resp.User == nil {
+		err = fmt.Errorf( "create-user: openstack response did not contain user data" )
+		return
+	}
+
+	usr = &Ostack_user {
+		Id:			resp.User.Id,
+		Name:		resp.User.Name,
+		Domain_id:	resp.User.Domain_id,
+		Project_id:	resp.User.Default_project_id,
+		Email:		resp.User.Email,
+		Enabled:	resp.User.Enabled,
+	}
+
+	return
+}
+
+/*
+	Builds a map of role names to role ids using the v3 interface (Map_roles() uses the
+	v2 admin extension which isn't available in newer releases).
+*/
+func (o *Ostack) Map_roles_v3( ) ( rmap map[string]*string, err error ) {
+	var (
+		resp osv3_generic
+	)
+
+	err = o.validate_admin( "map-roles-v3" )
+	if err != nil {
+		return
+	}
+
+	body := bytes.NewBufferString( "" )
+	url := o.identity_url() + "v3/roles"
+	err = o.get_unpacked( url, body, &resp, "map-roles-v3" )
+	if err != nil {
+		return
+	}
+
+	if resp.Error != nil {
+		err = fmt.Errorf( "map-roles-v3 failed: %s", resp.Error )
+		return
+	}
+
+	rmap = make( map[string]*string, len( resp.Roles ) )
+	for _, r := range resp.Roles {
+		dup_str := r.Id
+		rmap[r.Name] = &dup_str
+	}
+
+	return
+}
+
+/*
+	Assign the role (id) to the user (id) on the project (id).
+*/
+func (o *Ostack) Assign_role( pid *string, uid *string, rid *string ) ( error ) {
+	return o.role_grant( "PUT", "projects", pid, uid, rid, "assign-role" )
+}
+
+/*
+	Revoke the role (id) from the user (id) on the project (id).
+*/
+func (o *Ostack) Revoke_role( pid *string, uid *string, rid *string ) ( error ) {
+	return o.role_grant( "DELETE", "projects", pid, uid, rid, "revoke-role" )
+}
+
+/*
+	Assign the role (id) to the user (id) on the domain (id).
+*/
+func (o *Ostack) Assign_domain_role( did *string, uid *string, rid *string ) ( error ) {
+	return o.role_grant( "PUT", "domains", did, uid, rid, "assign-drole" )
+}
+
+/*
+	Revoke the role (id) from the user (id) on the domain (id).
+*/
+func (o *Ostack) Revoke_domain_role( did *string, uid *string, rid *string ) ( error ) {
+	return o.role_grant( "DELETE", "domains", did, uid, rid, "revoke-drole" )
+}
+
+/*
+	List role assignments. If pid is not nil, the list is limited to assignments on that
+	project; if uid is not nil the list is limited to assignments for that user. If both
+	are nil, all assignments are listed (could be huge).
+*/
+func (o *Ostack) List_role_assignments( pid *string, uid *string ) ( list []*Ostack_role_asgn, err error ) {
+	var (
+		resp osv3_generic
+	)
+
+	err = o.validate_admin( "list-rasgn" )
+	if err != nil {
+		return
+	}
+
+	url := o.identity_url() + "v3/role_assignments?include_names=true"
+	if pid != nil && *pid != "" {
+		url += "&scope.project.id=" + *pid
+	}
+	if uid != nil && *uid != "" {
+		url += "&user.id=" + *uid
+	}
+
+	body := bytes.NewBufferString( "" )
+	err = o.get_unpacked( url, body, &resp, "list-rasgn" )
+	if err != nil {
+		return
+	}
+
+	if resp.Error != nil {
+		err = fmt.Errorf( "list-rasgn failed: %s", resp.Error )
+		return
+	}
+
+	list = make( []*Ostack_role_asgn, 0, len( resp.Role_assignments ) )
+	for _, ra := range resp.Role_assignments {
+		a := &Ostack_role_asgn{ }
+		if ra.Role != nil {
+			a.Role_id = ra.Role.Id
+			a.Role_name = ra.Role.Name
+		}
+		if ra.User != nil {
+			a.User_id = ra.User.Id
+			a.User_name = ra.User.Name
+		}
+		if ra.Group != nil {
+			a.Group_id = ra.Group.Id
+		}
+		if ra.Scope != nil {
+			if ra.Scope.Project != nil {
+				a.Project_id = ra.Scope.Project.Id
+				a.Project_name = ra.Scope.Project.Name
+			}
+			if ra.Scope.Domain != nil {
+				a.Domain_id = ra.Scope.Domain.Id
+				a.Domain_name = ra.Scope.Domain.Name
+			}
+		}
+
+		list = append( list, a )
+	}
+
+	return
+}
+
+/*
+	Convenience function for printing.
+*/
+func (a *Ostack_role_asgn) String( ) ( string ) {
+	if a == nil {
+		return "<nil>"
+	}
+
+	who := a.User_name + "/" + a.User_id
+	if a.User_id == "" {
+		who = "group/" + a.Group_id
+	}
+	scope := "project " + a.Project_name + "/" + a.Project_id
+	if a.Project_id == "" {
+		scope = "domain " + a.Domain_name + "/" + a.Domain_id
+	}
+
+	return fmt.Sprintf( "role: %s/%s  who: %s  scope: %s", a.Role_name, a.Role_id, who, scope )
+}
@@ -0,0 +1,209 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ostack_admin_test.go
+	Abstract:	Tests the keystone admin functions against a local http server which
+				plays the part of keystone.
+	Date:		19 October 2026
+*/
+
+package ostack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/*
+	Build an ostack struct which is already authorised as an admin with v3 and points at the server.
+*/
+func mk_admin_ostack( url string ) ( *Ostack ) {
+	o := mk_test_ostack( url )
+	o.version = 3
+	o.isadmin = true
+	o.iahost = &url
+
+	return o
+}
+
+func TestAdmin_projects( t *testing.T ) {
+	var req map[string]map[string]interface{}
+	var method, path string
+
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		method = r.Method
+		path = r.URL.Path
+		req = nil
+		json.NewDecoder( r.Body ).Decode( &req )
+
+		switch {
+			case r.Method == "POST" && r.URL.Path == "/v3/projects":
+				w.WriteHeader( http.StatusCreated )
+				fmt.Fprintf( w, `{ "project": { "id": "p1", "name": %q, "domain_id": %q, "description": %q, "enabled": true } }`,
+					req["project"]["name"], req["project"]["domain_id"], req["project"]["description"] )
+
+			case r.Method == "PATCH" && r.URL.Path == "/v3/projects/p1":
+				fmt.Fprintf( w, `{ "project": { "id": "p1", "name": "proj", "domain_id": "default", "enabled": %v } }`, req["project"]["enabled"] )
+
+			default:
+				w.WriteHeader( http.StatusNotFound )
+				fmt.Fprintf( w, `{ "error": { "message": "bad url %s", "code": 404, "title": "Not Found" } }`, r.URL.Path )
+		}
+	} ) )
+	defer srv.Close()
+
+	o := mk_admin_ostack( srv.URL + "/" )
+	name := "proj"
+	desc := "a project"
+	proj, err := o.Create_project( &name, nil, &desc )
+	if err != nil {
+		t.Fatalf( "create project failed: %s", err )
+	}
+	if proj.Id != "p1" || proj.Name != "proj" || proj.Domain_id != "default" || proj.Description != "a project" || !proj.Enabled {
+		t.Errorf( "unexpected project: %+v", proj )
+	}
+	if req["project"]["enabled"] != true {
+		t.Errorf( "project not created enabled: %v", req )
+	}
+
+	pid := "p1"
+	if proj, err = o.Disable_project( &pid ); err != nil {
+		t.Fatalf( "disable project failed: %s", err )
+	}
+	if method != "PATCH" || path != "/v3/projects/p1" || req["project"]["enabled"] != false || proj.Enabled {
+		t.Errorf( "unexpected disable: %s %s %v -> %+v", method, path, req, proj )
+	}
+
+	if proj, err = o.Enable_project( &pid, true ); err != nil {
+		t.Fatalf( "enable project failed: %s", err )
+	}
+	if req["project"]["enabled"] != true || !proj.Enabled {
+		t.Errorf( "unexpected enable: %v -> %+v", req, proj )
+	}
+
+	bad := "nosuch"
+	if _, err = o.Enable_project( &bad, true ); err == nil {
+		t.Errorf( "expected keystone error to be returned" )
+	}
+}
+
+func TestAdmin_user_roles( t *testing.T ) {
+	var req map[string]map[string]interface{}
+	var reqs []string
+
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		reqs = append( reqs, r.Method + " " + r.URL.RequestURI() )
+
+		switch {
+			case r.Method == "POST" && r.URL.Path == "/v3/users":
+				json.NewDecoder( r.Body ).Decode( &req )
+				w.WriteHeader( http.StatusCreated )
+				fmt.Fprintf( w, `{ "user": { "id": "u1", "name": %q, "domain_id": "default", "default_project_id": "p1", "email": "u@example.com", "enabled": true } }`,
+					req["user"]["name"] )
+
+			case (r.Method == "PUT" || r.Method == "DELETE") && r.URL.Path == "/v3/projects/p1/users/u1/roles/r1":
+				w.WriteHeader( http.StatusNoContent )
+
+			case r.Method == "GET" && r.URL.Path == "/v3/role_assignments":
+				fmt.Fprintf( w, `{ "role_assignments": [
+					{ "role": { "id": "r1", "name": "member" }, "user": { "id": "u1", "name": "usr" }, "scope": { "project": { "id": "p1", "name": "proj" } } },
+					{ "role": { "id": "r2", "name": "admin" }, "group": { "id": "g1" }, "scope": { "domain": { "id": "default", "name": "Default" } } }
+				] }` )
+
+			default:
+				w.WriteHeader( http.StatusNotFound )
+				fmt.Fprintf( w, `{ "error": { "message": "bad url %s", "code": 404, "title": "Not Found" } }`, r.URL.Path )
+		}
+	} ) )
+	defer srv.Close()
+
+	o := mk_admin_ostack( srv.URL + "/" )
+	name := "usr"
+	pw := "secret"
+	pid := "p1"
+	email := "u@example.com"
+	usr, err := o.Create_user( &name, &pw, nil, &pid, &email )
+	if err != nil {
+		t.Fatalf( "create user failed: %s", err )
+	}
+	if usr.Id != "u1" || usr.Name != "usr" || usr.Project_id != "p1" || usr.Email != "u@example.com" || !usr.Enabled {
+		t.Errorf( "unexpected user: %+v", usr )
+	}
+	u := req["user"]
+	if u["password"] != "secret" || u["default_project_id"] != "p1" || u["email"] != "u@example.com" || u["domain_id"] != "default" {
+		t.Errorf( "unexpected create user request: %v", u )
+	}
+
+	uid := "u1"
+	rid := "r1"
+	if err = o.Assign_role( &pid, &uid, &rid ); err != nil {
+		t.Fatalf( "assign role failed: %s", err )
+	}
+	if err = o.Revoke_role( &pid, &uid, &rid ); err != nil {
+		t.Fatalf( "revoke role failed: %s", err )
+	}
+	bad := "nosuch"
+	if err = o.Assign_role( &pid, &uid, &bad ); err == nil {
+		t.Errorf( "expected error assigning unknown role" )
+	}
+
+	list, err := o.List_role_assignments( &pid, &uid )
+	if err != nil {
+		t.Fatalf( "list role assignments failed: %s", err )
+	}
+	if len( list ) != 2 {
+		t.Fatalf( "expected 2 assignments, got %d", len( list ) )
+	}
+	if a := list[0]; a.Role_name != "member" || a.User_id != "u1" || a.User_name != "usr" || a.Project_id != "p1" || a.Project_name != "proj" {
+		t.Errorf( "unexpected assignment: %s", a )
+	}
+	if a := list[1]; a.Role_id != "r2" || a.Group_id != "g1" || a.User_id != "" || a.Domain_id != "default" || a.Project_id != "" {
+		t.Errorf( "unexpected assignment: %s", a )
+	}
+
+	expect := []string{
+		"POST /v3/users",
+		"PUT /v3/projects/p1/users/u1/roles/r1",
+		"DELETE /v3/projects/p1/users/u1/roles/r1",
+		"PUT /v3/projects/p1/users/u1/roles/nosuch",
+		"GET /v3/role_assignments?include_names=true&scope.project.id=p1&user.id=u1",
+	}
+	if fmt.Sprintf( "%q", reqs ) != fmt.Sprintf( "%q", expect ) {
+		t.Errorf( "unexpected requests:\n got %q\nwant %q", reqs, expect )
+	}
+}
+
+func TestAdmin_requires_v3( t *testing.T ) {
+	o := mk_admin_ostack( "http://localhost:1/" )
+	o.version = 2
+	name := "proj"
+	if _, err := o.Create_project( &name, nil, nil ); err == nil {
+		t.Errorf( "expected v2 credentials to be rejected" )
+	}
+
+	o = mk_admin_ostack( "http://localhost:1/" )
+	o.isadmin = false
+	if _, err := o.Create_project( &name, nil, nil ); err == nil {
+		t.Errorf( "expected non-admin credentials to be rejected" )
+	}
+}
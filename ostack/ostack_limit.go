@@ -0,0 +1,251 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+-----------------------------------------------------------------------------------------
+	Mnemonic:	ostack_limit
+	Abstract:	Client side rate limiting of requests sent to openstack.  Each service
+				(compute, network, identity, other -- see ostack_metrics) may have a token
+				bucket limiting the request rate, and a cap on the number of requests
+				which are in flight at any one time.  Limits set on an ostack struct are
+				shared with all Dup()'d copies so that a set of goroutines working with
+				copies of the same credentials are limited as a group.
+
+				When openstack (or the proxy in front of it) responds with 429 (too many
+				requests) Send_req() will pause for the time indicated by the Retry-After
+				header and resend the request a limited number of times.
+	Date:		19 October 2026
+-----------------------------------------------------------------------------------------
+*/
+
+package ostack
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ALL_SERVICES	string = "*"		// service name used to set a limit for services without a specific one
+
+	default_429_retries int = 3
+	max_retry_after = 60 * time.Second	// we won't honour a retry-after larger than this
+)
+
+/*
+	Limits for a single service.
+*/
+type ost_limiter struct {
+	mu		sync.Mutex
+	rate	float64				// requests per second; <= 0 is unlimited
+	burst	float64				// max tokens that can accumulate
+	tokens	float64
+	last	time.Time			// last time tokens were added
+	inflight chan bool			// semaphore; nil if there is no cap
+}
+
+/*
+	The set of limiters for an ostack struct (and its dups).
+*/
+type ost_limits struct {
+	mu			sync.Mutex
+	svcs		map[string]*ost_limiter
+	retries		int				// number of times we resend after a 429
+}
+
+// ------------- internal ---------------------------------------------------------------
+
+func mk_limits( ) ( *ost_limits ) {
+	return &ost_limits{
+		svcs: make( map[string]*ost_limiter ),
+		retries: default_429_retries,
+	}
+}
+
+/*
+	Return the limiter for the service, creating it if create is set. Nil is returned if
+	there isn't a limiter for the service and create is false.
+*/
+func (ls *ost_limits) get( svc string, create bool ) ( *ost_limiter ) {
+	if ls == nil {
+		return nil
+	}
+
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	l := ls.svcs[svc]
+	if l == nil && create {
+		l = &ost_limiter{ }
+		ls.svcs[svc] = l
+	}
+
+	return l
+}
+
+/*
+	Block until a request can be sent. Returns the semaphore that must be passed to
+	release() when the request has completed (may be nil).
+*/
+func (l *ost_limiter) acquire( ) ( sem chan bool ) {
+	if l == nil {
+		return nil
+	}
+
+	for {
+		l.mu.Lock()
+		if l.rate <= 0 {
+			l.mu.Unlock()
+			break
+		}
+
+		now := time.Now()
+		l.tokens += now.Sub( l.last ).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			break
+		}
+
+		wait := time.Duration( (1 - l.tokens) / l.rate * float64( time.Second ) )
+		l.mu.Unlock()
+		time.Sleep( wait )
+	}
+
+	l.mu.Lock()
+	sem = l.inflight
+	l.mu.Unlock()
+
+	if sem != nil {
+		sem <- true
+	}
+
+	return
+}
+
+/*
+	Release the in flight slot acquired.
+*/
+func release( sem chan bool ) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+/*
+	Convert the value of a Retry-After header (seconds or an http date) to a duration.
+	If it's missing, or not understood, 1s is returned. The value is capped.
+*/
+func retry_after( hv string ) ( d time.Duration ) {
+	d = time.Second
+
+	if hv != "" {
+		if secs, err := strconv.Atoi( hv ); err == nil {
+			d = time.Duration( secs ) * time.Second
+		} else {
+			if t, err := http.ParseTime( hv ); err == nil {
+				d = t.Sub( time.Now() )
+			}
+		}
+	}
+
+	if d < 0 {
+		d = 0
+	}
+	if d > max_retry_after {
+		d = max_retry_after
+	}
+	return
+}
+
+// ------------- public -----------------------------------------------------------------
+
+/*
+	Set the maximum request rate (requests/second) for the service (compute, network,
+	identity, other, or ALL_SERVICES).  A request must satisfy both its service's limits
+	and the ALL_SERVICES limits.  Burst is the number of requests that may be sent
+	back to back after an idle period; if < 1 it is set to 1.  A rate of 0 removes the
+	limit.  The limit is shared with all Dup()'d copies of the struct.
+*/
+func (o *Ostack) Set_rate_limit( svc string, rate float64, burst int ) {
+	if o == nil {
+		return
+	}
+
+	l := o.limits.get( svc, true )
+	if l == nil {
+		return
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	l.mu.Lock()
+	l.rate = rate
+	l.burst = float64( burst )
+	l.tokens = l.burst
+	l.last = time.Now()
+	l.mu.Unlock()
+}
+
+/*
+	Set the maximum number of requests to the service (see Set_rate_limit) that may be
+	outstanding at any time. A max of 0 removes the cap. Requests already in flight when
+	the cap is changed are not counted against the new cap.
+*/
+func (o *Ostack) Set_max_inflight( svc string, max int ) {
+	if o == nil {
+		return
+	}
+
+	l := o.limits.get( svc, true )
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	if max > 0 {
+		l.inflight = make( chan bool, max )
+	} else {
+		l.inflight = nil
+	}
+	l.mu.Unlock()
+}
+
+/*
+	Set the number of times a request is resent after openstack responds with a 429
+	(too many requests). Zero disables the retries.
+*/
+func (o *Ostack) Set_429_retries( n int ) {
+	if o == nil || o.limits == nil {
+		return
+	}
+
+	o.limits.mu.Lock()
+	o.limits.retries = n
+	o.limits.mu.Unlock()
+}
@@ -0,0 +1,162 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ostack_limit_test.go
+	Abstract:	Tests the client side rate limiting and 429 handling using a local
+				http server in place of openstack.
+	Date:		19 October 2026
+*/
+
+package ostack
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry429( t *testing.T ) {
+	var count int32
+
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		b, _ := ioutil.ReadAll( r.Body )
+		if atomic.AddInt32( &count, 1 ) == 1 {
+			w.Header().Set( "Retry-After", "0" )
+			w.WriteHeader( http.StatusTooManyRequests )
+			return
+		}
+		fmt.Fprintf( w, `{ "body": %q }`, b )
+	} ) )
+	defer srv.Close()
+
+	host := srv.URL + "/"
+	user := "user"
+	pw := "pw"
+	o := Mk_ostack( &host, &user, &pw, nil )
+
+	url := host + "v3/projects"
+	jdata, _, err := o.Send_req( "POST", &url, bytes.NewBufferString( `{"x":1}` ) )
+	if err != nil {
+		t.Fatalf( "send failed: %s", err )
+	}
+	if count != 2 {
+		t.Errorf( "expected two requests, server saw %d", count )
+	}
+	if string( jdata ) != `{ "body": "{\"x\":1}" }` {
+		t.Errorf( "body was not resent: %s", jdata )
+	}
+}
+
+func TestRateLimit( t *testing.T ) {
+	var (
+		inflight int32
+		maxseen int32
+	)
+
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		n := atomic.AddInt32( &inflight, 1 )
+		for {
+			m := atomic.LoadInt32( &maxseen )
+			if n <= m || atomic.CompareAndSwapInt32( &maxseen, m, n ) {
+				break
+			}
+		}
+		time.Sleep( 20 * time.Millisecond )
+		atomic.AddInt32( &inflight, -1 )
+		fmt.Fprintf( w, `{ }` )
+	} ) )
+	defer srv.Close()
+
+	host := srv.URL + "/"
+	user := "user"
+	pw := "pw"
+	o := Mk_ostack( &host, &user, &pw, nil )
+	o.Set_rate_limit( "identity", 50, 2 )
+	o.Set_max_inflight( "identity", 2 )
+	dup, _ := o.Dup( nil )
+
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 12; i++ {
+		wg.Add( 1 )
+		go func( oo *Ostack ) {
+			defer wg.Done()
+			url := host + "v3/users"
+			oo.Send_req( "GET", &url, bytes.NewBufferString( "" ) )
+		}( []*Ostack{ o, dup }[i % 2] )
+	}
+	wg.Wait()
+
+	if elapsed := time.Since( start ); elapsed < 150 * time.Millisecond {			// 12 requests, burst of 2 at 50/s needs >= 200ms
+		t.Errorf( "rate limit not applied: 12 requests took %s", elapsed )
+	}
+	if maxseen > 2 {
+		t.Errorf( "in flight cap not honoured: saw %d concurrent requests", maxseen )
+	}
+}
+
+func TestLimit_all_services( t *testing.T ) {
+	var (
+		inflight int32
+		maxseen int32
+	)
+
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		n := atomic.AddInt32( &inflight, 1 )
+		for {
+			m := atomic.LoadInt32( &maxseen )
+			if n <= m || atomic.CompareAndSwapInt32( &maxseen, m, n ) {
+				break
+			}
+		}
+		time.Sleep( 20 * time.Millisecond )
+		atomic.AddInt32( &inflight, -1 )
+		fmt.Fprintf( w, `{ }` )
+	} ) )
+	defer srv.Close()
+
+	host := srv.URL + "/"
+	user := "user"
+	pw := "pw"
+	o := Mk_ostack( &host, &user, &pw, nil )
+	o.Set_max_inflight( ALL_SERVICES, 1 )
+	o.Set_max_inflight( "identity", 4 )				// service's own cap must not bypass the overall cap
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 6; i++ {
+		wg.Add( 1 )
+		go func( ) {
+			defer wg.Done()
+			url := host + "v3/users"
+			o.Send_req( "GET", &url, bytes.NewBufferString( "" ) )
+		}( )
+	}
+	wg.Wait()
+
+	if maxseen != 1 {
+		t.Errorf( "all services cap not honoured: saw %d concurrent requests", maxseen )
+	}
+}
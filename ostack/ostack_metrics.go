@@ -0,0 +1,283 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+-----------------------------------------------------------------------------------------
+	Mnemonic:	ostack_metrics
+	Abstract:	Per service/operation request metrics. Every request sent by Send_req()
+				is counted by status class (2xx, 4xx, etc.) and its latency is added to
+				a histogram.  The service is determined by matching the url against the
+				urls returned by keystone, and the operation is the method and the path
+				with ids replaced by {id} (e.g. GET v2.0/routers/{id}/l3-agents).
+
+				Metrics are shared by an ostack struct and any Dup()'d copies so that
+				the totals reflect all traffic generated with one set of credentials.
+	Date:		19 October 2026
+-----------------------------------------------------------------------------------------
+*/
+
+package ostack
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	Upper bounds (seconds) of the latency histogram buckets. There is an implied +Inf bucket.
+*/
+var latency_buckets = []float64{ 0.05, 0.1, 0.25, 0.5, 1.0, 2.5, 5.0, 10.0 }
+
+var id_re = regexp.MustCompile( "^([0-9a-fA-F-]{16,}|[0-9]+|[0-9a-zA-Z_-]{32,})$" )	// path segments that look like ids/uuids/tokens
+
+/*
+	Stats for one service/operation pair. Latency_counts are NOT cumulative; Latency_counts[i]
+	is the number of requests which took <= Latency_buckets[i] seconds and more than the
+	previous bucket. The last element (one more than the bucket list) counts requests that
+	exceeded the largest bucket.
+*/
+type Ostack_op_stats struct {
+	Service		string				// compute, network, identity or other
+	Op			string				// method and normalised path
+	Requests	int64
+	Errors		int64				// requests which failed, or returned a 4xx/5xx status
+	Status		map[string]int64	// count by status class: 2xx, 3xx, 4xx, 5xx, or neterr (no response)
+	Latency_buckets	[]float64
+	Latency_counts	[]int64
+	Latency_sum	float64				// total seconds
+	Latency_max float64
+}
+
+/*
+	The collection; mu protects the map and all stats in it.
+*/
+type ost_metrics struct {
+	mu		sync.Mutex
+	ops		map[string]*Ostack_op_stats		// keyed by service + " " + op
+}
+
+// ------------- internal ---------------------------------------------------------------
+
+func mk_metrics( ) ( *ost_metrics ) {
+	return &ost_metrics{ ops: make( map[string]*Ostack_op_stats ) }
+}
+
+/*
+	Map a status code into its class string.
+*/
+func status_class( code int ) ( string ) {
+	if code <= 0 {
+		return "neterr"
+	}
+
+	return fmt.Sprintf( "%dxx", code / 100 )
+}
+
+/*
+	Strip the query string and replace things that look like ids in the path so that
+	operations can be grouped.
+*/
+func norm_path( path string ) ( string ) {
+	if i := strings.IndexAny( path, "?#" ); i >= 0 {
+		path = path[0:i]
+	}
+
+	toks := strings.Split( strings.Trim( path, "/" ), "/" )
+	for i := range toks {
+		if id_re.MatchString( toks[i] ) {
+			toks[i] = "{id}"
+		}
+	}
+
+	return strings.Join( toks, "/" )
+}
+
+/*
+	Suss out the service and operation for the method and url.  The url is compared with
+	the urls that we have for each service; if none match it's classed as other and the
+	whole path is used.
+*/
+func (o *Ostack) svc_op( method string, uurl string ) ( svc string, op string ) {
+	candidates := []struct {
+		name	string
+		host	*string
+	} {
+		{ "network", o.nhost },
+		{ "compute", o.chost },
+		{ "compute", o.cahost },
+		{ "identity", o.iahost },
+		{ "identity", o.ihost },
+		{ "identity", o.host },
+	}
+
+	for _, c := range candidates {
+		if c.host != nil && *c.host != "" && strings.HasPrefix( uurl, *c.host ) {
+			return c.name, method + " " + norm_path( uurl[len( *c.host ):] )
+		}
+	}
+
+	path := uurl
+	if u, err := url.Parse( uurl ); err == nil {
+		path = u.Path
+	}
+	return "other", method + " " + norm_path( path )
+}
+
+/*
+	Record the result of one request.  Code is the http status, or 0 if no response was received.
+*/
+func (m *ost_metrics) record( svc string, op string, code int, elapsed time.Duration ) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := svc + " " + op
+	s := m.ops[key]
+	if s == nil {
+		s = &Ostack_op_stats {
+			Service:	svc,
+			Op:			op,
+			Status:		make( map[string]int64 ),
+			Latency_buckets: latency_buckets,
+			Latency_counts:	make( []int64, len( latency_buckets ) + 1 ),
+		}
+		m.ops[key] = s
+	}
+
+	s.Requests++
+	if code <= 0 || code >= 400 {
+		s.Errors++
+	}
+	s.Status[status_class( code )]++
+
+	secs := elapsed.Seconds()
+	s.Latency_sum += secs
+	if secs > s.Latency_max {
+		s.Latency_max = secs
+	}
+	b := sort.SearchFloat64s( latency_buckets, secs )		// first bucket >= secs; len() if larger than all
+	s.Latency_counts[b]++
+}
+
+// ------------- public -----------------------------------------------------------------
+
+/*
+	Returns a copy of the current metrics, sorted by service and operation.
+*/
+func (o *Ostack) Get_metrics( ) ( stats []*Ostack_op_stats ) {
+	if o == nil || o.metrics == nil {
+		return nil
+	}
+
+	m := o.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats = make( []*Ostack_op_stats, 0, len( m.ops ) )
+	for _, s := range m.ops {
+		c := *s
+		c.Status = make( map[string]int64, len( s.Status ) )
+		for k, v := range s.Status {
+			c.Status[k] = v
+		}
+		c.Latency_counts = append( []int64{}, s.Latency_counts... )
+		stats = append( stats, &c )
+	}
+
+	sort.Slice( stats, func( i, j int ) bool {
+		if stats[i].Service != stats[j].Service {
+			return stats[i].Service < stats[j].Service
+		}
+		return stats[i].Op < stats[j].Op
+	} )
+
+	return
+}
+
+/*
+	Clear all metrics (affects Dup()'d copies as well).
+*/
+func (o *Ostack) Reset_metrics( ) {
+	if o == nil || o.metrics == nil {
+		return
+	}
+
+	o.metrics.mu.Lock()
+	o.metrics.ops = make( map[string]*Ostack_op_stats )
+	o.metrics.mu.Unlock()
+}
+
+/*
+	Returns the metrics in prometheus text exposition format. Prefix is used as the start
+	of each metric name; "ostack" is used if it is empty.  Generates:
+		<prefix>_requests_total{service,op,class}		counter
+		<prefix>_request_errors_total{service,op}		counter
+		<prefix>_request_duration_seconds{service,op}	histogram
+*/
+func (o *Ostack) Prom_metrics( prefix string ) ( string ) {
+	if prefix == "" {
+		prefix = "ostack"
+	}
+
+	stats := o.Get_metrics()
+	out := bytes.NewBufferString( "" )
+
+	fmt.Fprintf( out, "# HELP %s_requests_total Openstack API requests by service, operation and status class.\n", prefix )
+	fmt.Fprintf( out, "# TYPE %s_requests_total counter\n", prefix )
+	for _, s := range stats {
+		classes := make( []string, 0, len( s.Status ) )
+		for c := range s.Status {
+			classes = append( classes, c )
+		}
+		sort.Strings( classes )
+		for _, c := range classes {
+			fmt.Fprintf( out, "%s_requests_total{service=%q,op=%q,class=%q} %d\n", prefix, s.Service, s.Op, c, s.Status[c] )
+		}
+	}
+
+	fmt.Fprintf( out, "# HELP %s_request_errors_total Openstack API requests which failed or returned a 4xx/5xx status.\n", prefix )
+	fmt.Fprintf( out, "# TYPE %s_request_errors_total counter\n", prefix )
+	for _, s := range stats {
+		fmt.Fprintf( out, "%s_request_errors_total{service=%q,op=%q} %d\n", prefix, s.Service, s.Op, s.Errors )
+	}
+
+	fmt.Fprintf( out, "# HELP %s_request_duration_seconds Openstack API request latency.\n", prefix )
+	fmt.Fprintf( out, "# TYPE %s_request_duration_seconds histogram\n", prefix )
+	for _, s := range stats {
+		cum := int64( 0 )
+		for i, le := range s.Latency_buckets {
+			cum += s.Latency_counts[i]
+			fmt.Fprintf( out, "%s_request_duration_seconds_bucket{service=%q,op=%q,le=\"%g\"} %d\n", prefix, s.Service, s.Op, le, cum )
+		}
+		fmt.Fprintf( out, "%s_request_duration_seconds_bucket{service=%q,op=%q,le=\"+Inf\"} %d\n", prefix, s.Service, s.Op, s.Requests )
+		fmt.Fprintf( out, "%s_request_duration_seconds_sum{service=%q,op=%q} %g\n", prefix, s.Service, s.Op, s.Latency_sum )
+		fmt.Fprintf( out, "%s_request_duration_seconds_count{service=%q,op=%q} %d\n", prefix, s.Service, s.Op, s.Requests )
+	}
+
+	return out.String()
+}
@@ -0,0 +1,88 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ostack_metrics_test.go
+	Abstract:	Tests the request metrics using a local http server in place of openstack.
+				Internal so that the network url can be stuffed without authorising.
+	Date:		19 October 2026
+*/
+
+package ostack
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics( t *testing.T ) {
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		if strings.Contains( r.URL.Path, "missing" ) {
+			w.WriteHeader( http.StatusNotFound )
+			fmt.Fprintf( w, `{ "error": { "message": "not found", "code": 404 } }` )
+			return
+		}
+		fmt.Fprintf( w, `{ "networks": [] }` )
+	} ) )
+	defer srv.Close()
+
+	host := srv.URL + "/"
+	user := "user"
+	pw := "pw"
+	o := Mk_ostack( &host, &user, &pw, nil )
+	nhost := srv.URL + "/network"
+	o.nhost = &nhost
+
+	for _, u := range []string{ "/v2.0/routers/9f3e5a2c-1f0b-4c8e-8d2e-3a1b9c7d6e5f/l3-agents",
+				"/v2.0/routers/0a0b0c0d-1f0b-4c8e-8d2e-3a1b9c7d6e5f/l3-agents",
+				"/v2.0/missing?tenant_id=12" } {
+		url := nhost + u
+		o.Send_req( "GET", &url, bytes.NewBufferString( "" ) )
+	}
+
+	stats := o.Get_metrics()
+	if len( stats ) != 2 {
+		t.Fatalf( "expected 2 operations, got %d", len( stats ) )
+	}
+
+	if stats[1].Op != "GET v2.0/routers/{id}/l3-agents" || stats[1].Service != "network" || stats[1].Requests != 2 {
+		t.Errorf( "unexpected router stats: %s %s %d", stats[1].Service, stats[1].Op, stats[1].Requests )
+	}
+	if stats[0].Op != "GET v2.0/missing" || stats[0].Errors != 1 || stats[0].Status["4xx"] != 1 {
+		t.Errorf( "unexpected error stats: %s errors=%d 4xx=%d", stats[0].Op, stats[0].Errors, stats[0].Status["4xx"] )
+	}
+
+	dup, _ := o.Dup( nil )
+	prom := dup.Prom_metrics( "" )
+	if ! strings.Contains( prom, `ostack_requests_total{service="network",op="GET v2.0/routers/{id}/l3-agents",class="2xx"} 2` ) {
+		t.Errorf( "prometheus output missing request counter" )
+	}
+	if ! strings.Contains( prom, `ostack_request_duration_seconds_bucket{service="network",op="GET v2.0/missing",le="+Inf"} 1` ) {
+		t.Errorf( "prometheus output missing histogram" )
+	}
+
+	o.Reset_metrics()
+	if len( dup.Get_metrics() ) != 0 {
+		t.Errorf( "reset did not clear shared metrics" )
+	}
+}
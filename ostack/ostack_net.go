@@ -65,6 +65,7 @@
 				17 Dec 2015 - Added an l3 list generator to list only those nodes marked as l3. Using
 					a full list (with openvswitch) was giving too much.
 				07 Jun 2018 - Correct inneffective assignment error.
+				19 Oct 2026 - Pulled map entry formatting into functions shared with ostack_netcfg.
 ------------------------------------------------------------------------------------------------
 */
 
@@ -445,9 +446,8 @@ func (o *Ostack) Mk_netinfo_map( ) ( nmap map[string]*string, err error ) {
 	}
 
 	nmap = make( map[string]*string, 101 )				// size is a hint, not a limit
-	for _, n := range net_list.Networks {
-		dup_str :=fmt.Sprintf( "%s %s %s %d", n.Id, n.Phys_net, n.Phys_type, n.Phys_seg_id )
-		nmap[n.Name] = &dup_str
+	for i := range net_list.Networks {
+		add_netinfo( nmap, &net_list.Networks[i] )
 	}
 
 	return
@@ -688,12 +688,27 @@ func (o *Ostack) Mk_snlists( ) ( snlist map[string]*string, gw2cidr map[string]*
 	snlist = make( map[string]*string )
 	gw2cidr = make( map[string]*string )
 	for j := range resp.Subnets {
-		list := resp.Subnets[j].Name + " " + resp.Subnets[j].Tenant_id + " " + resp.Subnets[j].Cidr + " " + resp.Subnets[j].Gateway_ip
-		snlist[resp.Subnets[j].Id] = &list
-
-		dup_str := resp.Subnets[j].Cidr
-		gw2cidr[resp.Subnets[j].Tenant_id + "/" + resp.Subnets[j].Gateway_ip] = &dup_str
+		add_subnet( snlist, gw2cidr, &resp.Subnets[j] )
 	}
 
 	return
 }
+
+/*
+	Add the network to a netinfo map (see Mk_netinfo_map).
+*/
+func add_netinfo( nmap map[string]*string, n *ost_network ) {
+	dup_str := fmt.Sprintf( "%s %s %s %d", n.Id, n.Phys_net, n.Phys_type, n.Phys_seg_id )
+	nmap[n.Name] = &dup_str
+}
+
+/*
+	Add the subnet to the subnet and gateway maps (see Mk_snlists).
+*/
+func add_subnet( snlist map[string]*string, gw2cidr map[string]*string, sn *ost_subnet ) {
+	list := sn.Name + " " + sn.Tenant_id + " " + sn.Cidr + " " + sn.Gateway_ip
+	snlist[sn.Id] = &list
+
+	dup_str := sn.Cidr
+	gw2cidr[sn.Tenant_id + "/" + sn.Gateway_ip] = &dup_str
+}
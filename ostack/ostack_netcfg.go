@@ -0,0 +1,429 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+
+/*
+------------------------------------------------------------------------------------------------
+	Mnemonic:	ostack_netcfg
+	Abstract:	Functions which change the network component (o.nhost) of openstack: create
+				and delete networks, subnets and routers, attach and detach router interfaces
+				and set router external gateways.  Where something is returned it is in the
+				same map format that the corresponding reader (Mk_netinfo_map, Mk_snlists,
+				Mk_gwlist) returns so that the results can be merged with those maps.
+
+				Doc: http://developer.openstack.org/api-ref-networking-v2.html
+
+	Date:		19 October 2026
+------------------------------------------------------------------------------------------------
+*/
+
+package ostack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+/*
+	Optional subnet parameters for Create_subnet. Pools are "start-end" address pairs
+	and routes are "destination-cidr nexthop" pairs.  The ipv6 modes are ignored for
+	v4 subnets.
+*/
+type Subnet_opts struct {
+	Gateway_ip	string				// if empty openstack picks the first address
+	No_gateway	bool				// create the subnet without a gateway
+	Pools		[]string
+	Routes		[]string
+	Dns			[]string
+	Disable_dhcp bool
+	Ipv6_ra_mode string				// slaac, dhcpv6-stateful, dhcpv6-stateless
+	Ipv6_address_mode string
+}
+
+// ----- internal support -------------------------------------------------------------------
+
+/*
+	Send a request to the network host. Req (if not nil) is marshalled as the body and
+	the response is unpacked into resp. Uri is everything after the version in the url.
+*/
+func (o *Ostack) net_send( method string, uri string, req interface{}, resp *generic_response, tag string ) ( err error ) {
+	if o == nil {
+		return fmt.Errorf( "%s: openstack creds were nil", tag )
+	}
+
+	err = o.Validate_auth()						// reauthorise if needed
+	if err != nil {
+		return
+	}
+
+	if o.nhost == nil || *o.nhost == "" {
+		return fmt.Errorf( "no network host url to query %s", o.To_str() )
+	}
+
+	body := bytes.NewBufferString( "" )
+	if req != nil {
+		jbytes, err := json.Marshal( req )
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer( jbytes )
+	}
+
+	url := fmt.Sprintf( "%s/v2.0/%s", *o.nhost, uri )
+	err = o.send_unpacked( method, url, body, resp, tag )
+	if err != nil {
+		return
+	}
+
+	switch {
+		case resp.Error != nil:
+			err = fmt.Errorf( "%s failed: %s", tag, resp.Error )
+
+		case resp.Neutronerror != nil:
+			err = fmt.Errorf( "%s failed: %s", tag, resp.Neutronerror.Message )
+
+		case resp.Forbidden != nil:
+			err = fmt.Errorf( "%s failed: %s", tag, resp.Forbidden )
+	}
+
+	return
+}
+
+/*
+	Convert the "start-end" pool strings to the list of objects that neutron wants.
+*/
+func mk_pools( pools []string ) ( list []map[string]string, err error ) {
+	list = make( []map[string]string, 0, len( pools ) )
+	for _, p := range pools {
+		toks := strings.SplitN( p, "-", 2 )
+		if len( toks ) != 2 || toks[0] == "" || toks[1] == "" {
+			return nil, fmt.Errorf( "allocation pool is not start-end: %s", p )
+		}
+		list = append( list, map[string]string{ "start": toks[0], "end": toks[1] } )
+	}
+
+	return
+}
+
+/*
+	Convert the "cidr nexthop" route strings to the list of objects that neutron wants.
+*/
+func mk_routes( routes []string ) ( list []map[string]string, err error ) {
+	list = make( []map[string]string, 0, len( routes ) )
+	for _, r := range routes {
+		toks := strings.Fields( r )
+		if len( toks ) != 2 {
+			return nil, fmt.Errorf( "host route is not 'destination nexthop': %s", r )
+		}
+		list = append( list, map[string]string{ "destination": toks[0], "nexthop": toks[1] } )
+	}
+
+	return
+}
+
+// ------------------- public ----------------------------------------------------------------
+
+/*
+	Create a network. Phys_net, ntype and seg_id are the provider attributes and are only
+	sent if supplied (nil/empty/0); they generally require admin credentials. If external
+	is true the network is marked as an external (router gateway) network.
+
+	The map returned has a single entry in the same form as Mk_netinfo_map():
+	name -> "id phys-net type seg-id".
+*/
+func (o *Ostack) Create_network( name *string, phys_net *string, ntype *string, seg_id int, external bool ) ( nmap map[string]*string, err error ) {
+	var (
+		resp generic_response
+	)
+
+	if name == nil || *name == "" {
+		return nil, fmt.Errorf( "create-network: network name was not supplied" )
+	}
+
+	net := map[string]interface{} {
+		"name": *name,
+		"admin_state_up": true,
+	}
+	if phys_net != nil && *phys_net != "" {
+		net["provider:physical_network"] = *phys_net
+	}
+	if ntype != nil && *ntype != "" {
+		net["provider:network_type"] = *ntype
+	}
+	if seg_id > 0 {
+		net["provider:segmentation_id"] = seg_id
+	}
+	if external {
+		net["router:external"] = true
+	}
+
+	err = o.net_send( "POST", "networks", map[string]interface{}{ "network": net }, &resp, "create-network" )
+	if err != nil {
+		return
+	}
+
+	if resp.Network == nil {
+		return nil, fmt.Errorf( "create-network: openstack response did not contain network data" )
+	}
+
+	nmap = make( map[string]*string )
+	add_netinfo( nmap, resp.Network )
+	return
+}
+
+/*
+	Delete the network with the given id.
+*/
+func (o *Ostack) Delete_network( id *string ) ( err error ) {
+	var (
+		resp generic_response
+	)
+
+	if id == nil || *id == "" {
+		return fmt.Errorf( "delete-network: id was not supplied" )
+	}
+
+	return o.net_send( "DELETE", "networks/" + *id, nil, &resp, "delete-network" )
+}
+
+/*
+	Create a subnet on the network (by id).  The ip version is determined from the cidr
+	(v6 if it contains a colon).  Opts may be nil.
+
+	The maps returned each have a single entry in the same form as those returned by
+	Mk_snlists(): id -> "name tenant cidr gateway" and tenant/gateway -> cidr.
+*/
+func (o *Ostack) Create_subnet( netid *string, name *string, cidr *string, opts *Subnet_opts ) ( snlist map[string]*string, gw2cidr map[string]*string, err error ) {
+	var (
+		resp generic_response
+	)
+
+	if netid == nil || *netid == "" || cidr == nil || *cidr == "" {
+		err = fmt.Errorf( "create-subnet: network id and cidr must be supplied" )
+		return
+	}
+
+	sn := map[string]interface{} {
+		"network_id": *netid,
+		"cidr": *cidr,
+		"ip_version": 4,
+	}
+	v6 := strings.Contains( *cidr, ":" )
+	if v6 {
+		sn["ip_version"] = 6
+	}
+	if name != nil {
+		sn["name"] = *name
+	}
+
+	if opts != nil {
+		switch {
+			case opts.No_gateway:
+				sn["gateway_ip"] = nil
+
+			case opts.Gateway_ip != "":
+				sn["gateway_ip"] = opts.Gateway_ip
+		}
+
+		if len( opts.Pools ) > 0 {
+			if sn["allocation_pools"], err = mk_pools( opts.Pools ); err != nil {
+				return
+			}
+		}
+		if len( opts.Routes ) > 0 {
+			if sn["host_routes"], err = mk_routes( opts.Routes ); err != nil {
+				return
+			}
+		}
+		if len( opts.Dns ) > 0 {
+			sn["dns_nameservers"] = opts.Dns
+		}
+		if opts.Disable_dhcp {
+			sn["enable_dhcp"] = false
+		}
+		if v6 {
+			if opts.Ipv6_ra_mode != "" {
+				sn["ipv6_ra_mode"] = opts.Ipv6_ra_mode
+			}
+			if opts.Ipv6_address_mode != "" {
+				sn["ipv6_address_mode"] = opts.Ipv6_address_mode
+			}
+		}
+	}
+
+	err = o.net_send( "POST", "subnets", map[string]interface{}{ "subnet": sn }, &resp, "create-subnet" )
+	if err != nil {
+		return
+	}
+
+	if resp.Subnet == nil {
+		err = fmt.Errorf( "create-subnet: openstack response did not contain subnet data" )
+		return
+	}
+
+	snlist = make( map[string]*string )
+	gw2cidr = make( map[string]*string )
+	add_subnet( snlist, gw2cidr, resp.Subnet )
+	return
+}
+
+/*
+	Delete the subnet with the given id.
+*/
+func (o *Ostack) Delete_subnet( id *string ) ( err error ) {
+	var (
+		resp generic_response
+	)
+
+	if id == nil || *id == "" {
+		return fmt.Errorf( "delete-subnet: id was not supplied" )
+	}
+
+	return o.net_send( "DELETE", "subnets/" + *id, nil, &resp, "delete-subnet" )
+}
+
+/*
+	Create a router. If extnet (external network id) is given it is set as the router's
+	gateway.  The map returned has a single entry in the same form as the Gw2extid lookup:
+	router id -> external network id (empty string if no gateway was set).
+*/
+func (o *Ostack) Create_router( name *string, extnet *string ) ( rmap map[string]*string, err error ) {
+	var (
+		resp generic_response
+	)
+
+	if name == nil || *name == "" {
+		return nil, fmt.Errorf( "create-router: router name was not supplied" )
+	}
+
+	rtr := map[string]interface{} {
+		"name": *name,
+		"admin_state_up": true,
+	}
+	if extnet != nil && *extnet != "" {
+		rtr["external_gateway_info"] = map[string]string{ "network_id": *extnet }
+	}
+
+	err = o.net_send( "POST", "routers", map[string]interface{}{ "router": rtr }, &resp, "create-router" )
+	if err != nil {
+		return
+	}
+
+	if resp.Router == nil {
+		return nil, fmt.Errorf( "create-router: openstack response did not contain router data" )
+	}
+
+	dup_str := ""
+	if resp.Router.External_gateway_info != nil {
+		dup_str = resp.Router.External_gateway_info.Network_id
+	}
+	rmap = map[string]*string{ resp.Router.Id: &dup_str }
+	return
+}
+
+/*
+	Delete the router with the given id. Interfaces must be removed first.
+*/
+func (o *Ostack) Delete_router( id *string ) ( err error ) {
+	var (
+		resp generic_response
+	)
+
+	if id == nil || *id == "" {
+		return fmt.Errorf( "delete-router: id was not supplied" )
+	}
+
+	return o.net_send( "DELETE", "routers/" + *id, nil, &resp, "delete-router" )
+}
+
+/*
+	Set the external gateway of the router (by id) to the external network. If extnet
+	is nil or empty the gateway is cleared.
+*/
+func (o *Ostack) Set_router_gw( rid *string, extnet *string ) ( err error ) {
+	var (
+		resp generic_response
+	)
+
+	if rid == nil || *rid == "" {
+		return fmt.Errorf( "set-router-gw: router id was not supplied" )
+	}
+
+	var gwinfo interface{} = map[string]string{}				// empty object clears the gateway
+	if extnet != nil && *extnet != "" {
+		gwinfo = map[string]string{ "network_id": *extnet }
+	}
+
+	req := map[string]interface{}{ "router": map[string]interface{}{ "external_gateway_info": gwinfo } }
+	return o.net_send( "PUT", "routers/" + *rid, req, &resp, "set-router-gw" )
+}
+
+/*
+	Attach the subnet to the router. The gateway port created is returned as a
+	"mac ip" string (the same form as the entries in the Mk_gwlist() list).
+*/
+func (o *Ostack) Add_router_if( rid *string, snid *string ) ( gw string, err error ) {
+	var (
+		resp generic_response
+	)
+
+	if rid == nil || *rid == "" || snid == nil || *snid == "" {
+		return "", fmt.Errorf( "add-router-if: router and subnet ids must be supplied" )
+	}
+
+	uri := fmt.Sprintf( "routers/%s/add_router_interface", *rid )
+	err = o.net_send( "PUT", uri, map[string]string{ "subnet_id": *snid }, &resp, "add-router-if" )
+	if err != nil {
+		return
+	}
+
+	if resp.Port_id == "" {
+		return "", fmt.Errorf( "add-router-if: openstack response did not contain a port id" )
+	}
+
+	port, err := o.FetchPortInfo( &resp.Port_id )
+	if err != nil {
+		return
+	}
+
+	if len( port.Fixed_ips ) == 0 {
+		return "", fmt.Errorf( "add-router-if: gateway port %s has no address", resp.Port_id )
+	}
+
+	gw = fmt.Sprintf( "%s %s", port.Mac_address, port.Fixed_ips[0].Ip_address )
+	return
+}
+
+/*
+	Detach the subnet from the router.
+*/
+func (o *Ostack) Rm_router_if( rid *string, snid *string ) ( err error ) {
+	var (
+		resp generic_response
+	)
+
+	if rid == nil || *rid == "" || snid == nil || *snid == "" {
+		return fmt.Errorf( "rm-router-if: router and subnet ids must be supplied" )
+	}
+
+	uri := fmt.Sprintf( "routers/%s/remove_router_interface", *rid )
+	return o.net_send( "PUT", uri, map[string]string{ "subnet_id": *snid }, &resp, "rm-router-if" )
+}
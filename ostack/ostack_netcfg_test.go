@@ -0,0 +1,109 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ostack_netcfg_test.go
+	Abstract:	Tests the network create functions against a local http server which
+				plays the part of neutron.
+	Date:		19 October 2026
+*/
+
+package ostack
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+/*
+	Build an ostack struct which is already 'authorised' and points at the server.
+*/
+func mk_test_ostack( url string ) ( *Ostack ) {
+	user := "user"
+	pw := "pw"
+	tok := "token"
+	proj := "proj-id"
+	o := Mk_ostack( &url, &user, &pw, nil )
+	o.token = &tok
+	o.expiry = time.Now().Unix() + 3600
+	o.nhost = &url
+	o.project_id = &proj
+
+	return o
+}
+
+func TestCreate_subnet( t *testing.T ) {
+	var req map[string]map[string]interface{}
+
+	srv := httptest.NewServer( http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ) {
+		if r.Method != "POST" || r.URL.Path != "/v2.0/subnets" {
+			w.WriteHeader( http.StatusNotFound )
+			fmt.Fprintf( w, `{ "NeutronError": { "message": "bad url %s" } }`, r.URL.Path )
+			return
+		}
+
+		json.NewDecoder( r.Body ).Decode( &req )
+		fmt.Fprintf( w, `{ "subnet": { "id": "sn1", "name": "v6net", "tenant_id": "t1", "cidr": "fd00::/64", "gateway_ip": "fd00::1", "ip_version": 6 } }` )
+	} ) )
+	defer srv.Close()
+
+	o := mk_test_ostack( srv.URL )
+	netid := "net1"
+	name := "v6net"
+	cidr := "fd00::/64"
+	opts := &Subnet_opts{
+		Pools: []string{ "fd00::10-fd00::20" },
+		Routes: []string{ "fd01::/64 fd00::2" },
+		Ipv6_address_mode: "slaac",
+	}
+
+	snlist, gw2cidr, err := o.Create_subnet( &netid, &name, &cidr, opts )
+	if err != nil {
+		t.Fatalf( "create failed: %s", err )
+	}
+
+	if v := snlist["sn1"]; v == nil || *v != "v6net t1 fd00::/64 fd00::1" {
+		t.Errorf( "unexpected snlist: %v", snlist )
+	}
+	if v := gw2cidr["t1/fd00::1"]; v == nil || *v != "fd00::/64" {
+		t.Errorf( "unexpected gw2cidr: %v", gw2cidr )
+	}
+
+	sn := req["subnet"]
+	if sn["ip_version"] != float64( 6 ) || sn["ipv6_address_mode"] != "slaac" || sn["network_id"] != "net1" {
+		t.Errorf( "unexpected request: %v", sn )
+	}
+	if pools, ok := sn["allocation_pools"].( []interface{} ); !ok || len( pools ) != 1 {
+		t.Errorf( "allocation pools not sent: %v", sn )
+	}
+
+	opts.Pools = []string{ "fd00::10" }
+	if _, _, err = o.Create_subnet( &netid, &name, &cidr, opts ); err == nil {
+		t.Errorf( "expected error for bad allocation pool" )
+	}
+
+	bad := "bad"
+	if err = o.Delete_subnet( &bad ); err == nil {
+		t.Errorf( "expected neutron error to be returned" )
+	}
+}
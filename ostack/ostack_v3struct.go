@@ -24,6 +24,7 @@ package ostack
 	Abstract:	Structures related to v3 api calls
 	Date:		03 April 2015
 	Author:		E. Scott Daniels
+	Mods:		19 Oct 2026 - Added project, user and role assignment structs for admin functions.
 */
 
 
@@ -34,6 +35,9 @@ type osv3_domain struct {
 
 type  osv3_proj struct {
 	Domain	*osv3_domain
+	Domain_id	string
+	Description	string
+	Enabled	bool
 	Id		string
 	Name	string
 }
@@ -59,10 +63,33 @@ type osv3_catentry struct {
 
 type osv3_user struct {
 	//Domain							// who knows what this is
+	Domain_id	string
+	Default_project_id string
+	Email		string
+	Enabled		bool
 	Id			string
 	Name		string
 }
 
+/*
+	Scope of a role assignment; only one of the two will be set.
+*/
+type osv3_ra_scope struct {
+	Project		*osv3_proj
+	Domain		*osv3_domain
+}
+
+/*
+	One entry returned by v3/role_assignments. Group is set rather than user if the
+	role was assigned through group membership.
+*/
+type osv3_role_assignment struct {
+	Role		*osv3_role
+	User		*osv3_user
+	Group		*osv3_user
+	Scope		*osv3_ra_scope
+}
+
 /*
 	Returned by v3/auth/tokens. This is a union of all possible top layer things.
 */
@@ -83,6 +110,11 @@ type osv3_token struct {
 type osv3_generic struct {
 	Token		*osv3_token
 	Project		*osv3_proj
+	Projects	[]*osv3_proj
+	User		*osv3_user
+	Users		[]*osv3_user
+	Roles		[]*osv3_role
+	Role_assignments	[]*osv3_role_assignment
 	Error		*error_obj				// we can use the generic error handling things here
 }
 
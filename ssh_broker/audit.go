@@ -0,0 +1,258 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	audit.go
+	Abstract: 	Audit records.  When an audit sink is set on the broker it is given one
+				record for every request (command or script) once the request is complete,
+				including requests which failed before reaching the host. Retried attempts
+				are not recorded separately; the number of tries is in the record.
+
+				The sink is called from the initiator goroutines, possibly concurrently,
+				and should not block for long.  Json_audit is a sink which appends each
+				record, as a single line of JSON, to a file.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"os/user"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+	One audit record.  Exactly one of Cmd or Script is set.
+*/
+type Audit_rec struct {
+	Time		time.Time	`json:"time"`				// when the request started
+	Local_user	string		`json:"local_user"`			// user running the broker
+	User		string		`json:"user"`				// remote user
+	Host		string		`json:"host"`
+	Id			int			`json:"id"`
+	Cmd			string		`json:"cmd,omitempty"`
+	Script		string		`json:"script,omitempty"`		// local path of the script
+	Sha256		string		`json:"sha256,omitempty"`		// of the script content sent
+	Parms		string		`json:"parms,omitempty"`
+	Env_file	string		`json:"env_file,omitempty"`
+	Exit_code	int			`json:"exit_code"`			// -1 if the command did not complete
+	Signal		string		`json:"signal,omitempty"`
+	Fail		string		`json:"fail"`				// failure class name (none if ok)
+	Error		string		`json:"error,omitempty"`
+	Duration	time.Duration `json:"duration_ns"`
+	Stdout_bytes int64		`json:"stdout_bytes"`
+	Stderr_bytes int64		`json:"stderr_bytes"`
+	Tries		int			`json:"tries"`
+}
+
+/*
+	Receives the audit records.
+*/
+type Audit_sink interface {
+	Audit( rec *Audit_rec )
+}
+
+/*
+	An audit sink which writes JSON lines.
+*/
+type Json_audit struct {
+	lock	sync.Mutex
+	w		io.Writer
+	f		*os.File				// nil if not opened by us
+	err		error					// first write error
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Counts the bytes written through it.
+*/
+type count_writer struct {
+	w		io.Writer
+	n		*int64
+}
+
+func ( cw *count_writer ) Write( buf []byte ) ( n int, err error ) {
+	n, err = cw.w.Write( buf )
+	atomic.AddInt64( cw.n, int64( n ) )
+	return
+}
+
+/*
+	Note the sha256 of the script in the request if auditing.
+*/
+func ( b *Broker ) audit_script( req *Broker_msg, pname string ) {
+	if b.get_audit() == nil {
+		return
+	}
+
+	f, err := os.Open( pname )
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if sum, err := sum_reader( f ); err == nil {
+		req.ssum = hex.EncodeToString( sum )
+	}
+}
+
+/*
+	Return the current audit sink (nil if not auditing).
+*/
+func ( b *Broker ) get_audit( ) ( Audit_sink ) {
+	b.cfg_lock.RLock()
+	defer b.cfg_lock.RUnlock()
+
+	return b.audit
+}
+
+/*
+	Build the record for the completed request and give it to the sink.
+*/
+func ( b *Broker ) audit_req( req *Broker_msg ) {
+	sink := b.get_audit()
+	if sink == nil {
+		return
+	}
+
+	ruser, hp := split_user( req.host )
+	if ruser == "" {
+		_, cfg, _ := b.host_config( "", b.host_port( hp ) )		// user could come from the ssh config
+		ruser = cfg.User
+	}
+
+	rec := &Audit_rec{
+		Time: req.began,
+		User: ruser,
+		Host: req.host,
+		Id: req.id,
+		Cmd: req.cmd,
+		Parms: req.parms,
+		Env_file: req.env,
+		Exit_code: req.exit_code,
+		Signal: req.signal,
+		Fail: Fail_class_name( req.fail ),
+		Duration: time.Since( req.began ),
+		Stdout_bytes: atomic.LoadInt64( &req.nout ),
+		Stderr_bytes: atomic.LoadInt64( &req.nerr ),
+		Tries: req.ntries + 1,
+	}
+	if req.cmd == "" {
+		rec.Script = req.sname
+		if pname, err := find_file( req.sname ); err == nil {
+			rec.Script = pname
+		}
+		rec.Sha256 = req.ssum
+	}
+	if req.err != nil {
+		rec.Error = req.err.Error()
+	}
+	if u, err := user.Current(); err == nil {
+		rec.Local_user = u.Username
+	}
+
+	sink.Audit( rec )
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Set_audit sets the audit sink; nil turns auditing off.
+*/
+func ( b *Broker ) Set_audit( sink Audit_sink ) {
+	if b == nil {
+		return
+	}
+
+	b.cfg_lock.Lock()
+	b.audit = sink
+	b.cfg_lock.Unlock()
+}
+
+/*
+	Mk_json_audit opens (creating if needed) the file for appending and returns a sink
+	which writes each record to it as a line of JSON.
+*/
+func Mk_json_audit( fname string ) ( ja *Json_audit, err error ) {
+	f, err := os.OpenFile( fname, os.O_WRONLY | os.O_APPEND | os.O_CREATE, 0600 )
+	if err != nil {
+		return
+	}
+
+	return &Json_audit{ w: f, f: f }, nil
+}
+
+/*
+	Mk_json_audit_writer returns a sink which writes each record to the writer as a line
+	of JSON.
+*/
+func Mk_json_audit_writer( w io.Writer ) ( *Json_audit ) {
+	return &Json_audit{ w: w }
+}
+
+/*
+	Audit writes the record. The first write error is kept and returned by Err().
+*/
+func ( ja *Json_audit ) Audit( rec *Audit_rec ) {
+	buf, err := json.Marshal( rec )
+	if err != nil {
+		return
+	}
+	buf = append( buf, '\n' )
+
+	ja.lock.Lock()
+	defer ja.lock.Unlock()
+
+	if _, err = ja.w.Write( buf ); err != nil && ja.err == nil {
+		ja.err = err
+	}
+}
+
+/*
+	Err returns the first error encountered writing a record.
+*/
+func ( ja *Json_audit ) Err( ) ( error ) {
+	ja.lock.Lock()
+	defer ja.lock.Unlock()
+
+	return ja.err
+}
+
+/*
+	Close closes the file if it was opened by Mk_json_audit.
+*/
+func ( ja *Json_audit ) Close( ) ( err error ) {
+	ja.lock.Lock()
+	defer ja.lock.Unlock()
+
+	if ja.f != nil {
+		err = ja.f.Close()
+		ja.f = nil
+	}
+
+	return
+}
@@ -0,0 +1,93 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	audit_test.go
+	Abstract:	Tests that a JSON audit record is written for each command and script.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/att/gopkgs/ssh_broker/sshtest"
+)
+
+func TestAudit( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	dir, _ := ioutil.TempDir( "", "audit" )
+	defer os.RemoveAll( dir )
+
+	afile := filepath.Join( dir, "audit.json" )
+	ja, err := Mk_json_audit( afile )
+	if err != nil {
+		t.Fatal( err )
+	}
+	b.Set_audit( ja )
+
+	script := []byte( "#!/bin/sh\necho \"$1\"\nexit 4\n" )
+	sname := filepath.Join( dir, "s.sh" )
+	ioutil.WriteFile( sname, script, 0755 )
+
+	b.Run_cmd( srv.Addr(), "echo hello" )
+	b.Run_on_host( srv.Addr(), sname, "abc", "" )
+	ja.Close()
+
+	f, err := os.Open( afile )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer f.Close()
+
+	recs := []*Audit_rec{ }
+	sc := bufio.NewScanner( f )
+	for sc.Scan() {
+		rec := &Audit_rec{ }
+		if err = json.Unmarshal( sc.Bytes(), rec ); err != nil {
+			t.Fatalf( "bad json line: %s: %s", sc.Text(), err )
+		}
+		recs = append( recs, rec )
+	}
+
+	if len( recs ) != 2 {
+		t.Fatalf( "expected 2 records, got %d", len( recs ) )
+	}
+
+	r := recs[0]
+	if r.Cmd != "echo hello" || r.User != "scooter" || r.Exit_code != 0 || r.Stdout_bytes != 6 || r.Fail != "none" || r.Tries != 1 {
+		t.Errorf( "unexpected command record: %+v", r )
+	}
+
+	sum := sha256.Sum256( script )
+	r = recs[1]
+	if r.Script != sname || r.Sha256 != hex.EncodeToString( sum[:] ) || r.Parms != "abc" || r.Exit_code != 4 || r.Fail != "remote" || r.Stdout_bytes != 4 {
+		t.Errorf( "unexpected script record: %+v", r )
+	}
+}
@@ -0,0 +1,303 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	auth.go
+	Abstract: 	Builds the list of authentication methods given to ssh from the broker
+				options: keys held by an ssh-agent (SSH_AUTH_SOCK), private key files
+				(optionally passphrase protected), OpenSSH user certificates, and a
+				password which is used for both password and keyboard-interactive
+				authentication.
+
+				All public keys (agent, file and certificate) are offered through a single
+				method as the ssh package tries each method type only once.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+/*
+	Called to get the passphrase for an encrypted private key file.
+*/
+type Passphrase_fn func( kfname string ) ( []byte, error )
+
+/*
+	Holds what is needed to authenticate, and the agent connection if one was opened.
+*/
+type auth_info struct {
+	methods		[]ssh.AuthMethod
+	pk_fn		func() ( []ssh.Signer, error )	// the public key signers (nil if no keys); also in methods
+	others		[]ssh.AuthMethod		// methods other than public key
+	ppfn		Passphrase_fn			// used for identity files named in the ssh config
+	agent		agent.ExtendedAgent		// nil if not using an agent
+	agent_conn	net.Conn
+
+	id_lock		sync.Mutex
+	ids			map[string]ssh.Signer	// identity files (ssh config) already read
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Read the private key file and convert its contents into a "signer". If the key is
+	encrypted, the passphrase function is invoked (if supplied) to get the passphrase.
+*/
+func read_key_file_pp( kfname string, ppfn Passphrase_fn ) ( s ssh.Signer, err error ) {
+	buf, err := ioutil.ReadFile( kfname )
+	if err != nil {
+		return
+	}
+
+	s, err = ssh.ParsePrivateKey( buf )
+	if _, is_pp := err.( *ssh.PassphraseMissingError ); is_pp {
+		if ppfn == nil {
+			return nil, fmt.Errorf( "%s: key is passphrase protected and no passphrase function was given", kfname )
+		}
+
+		pp, err := ppfn( kfname )
+		if err != nil {
+			return nil, err
+		}
+		return ssh.ParsePrivateKeyWithPassphrase( buf, pp )
+	}
+
+	return
+}
+
+/*
+	Read an OpenSSH certificate (a -cert.pub file).
+*/
+func read_cert_file( cfname string ) ( cert *ssh.Certificate, err error ) {
+	buf, err := ioutil.ReadFile( cfname )
+	if err != nil {
+		return
+	}
+
+	pk, _, _, _, err := ssh.ParseAuthorizedKey( buf )
+	if err != nil {
+		return
+	}
+
+	cert, ok := pk.( *ssh.Certificate )
+	if !ok {
+		return nil, fmt.Errorf( "%s: not a certificate", cfname )
+	}
+
+	return
+}
+
+/*
+	Pair each certificate with the signer having the same public key and return the
+	certificate signers. Certificate files named <key>-cert.pub are added to the list
+	automatically (OpenSSH convention) if they exist.
+*/
+func mk_cert_signers( keys []string, signers []ssh.Signer, certs []string, verbose bool ) ( csigners []ssh.Signer ) {
+	for _, k := range keys {
+		cf := k + "-cert.pub"
+		if _, err := os.Stat( cf ); err == nil {
+			certs = append( certs, cf )
+		}
+	}
+
+	for _, cf := range certs {
+		cert, err := read_cert_file( cf )
+		if err != nil {
+			fmt.Fprintf( os.Stderr, "ssh_broker: unable to use certificate: %s\n", err )
+			continue
+		}
+
+		cbytes := cert.Key.Marshal()
+		found := false
+		for _, s := range signers {
+			if bytes.Equal( s.PublicKey().Marshal(), cbytes ) {
+				cs, err := ssh.NewCertSigner( cert, s )
+				if err == nil {
+					csigners = append( csigners, cs )
+					found = true
+				}
+				break
+			}
+		}
+
+		if !found && verbose {
+			fmt.Fprintf( os.Stderr, "ssh_broker: no private key matches certificate: %s\n", cf )
+		}
+	}
+
+	return
+}
+
+/*
+	Build the authentication methods from the options.  Key files which cannot be read
+	are skipped (not fatal) as was always the case, but an error is returned if no
+	method at all results.
+*/
+func mk_auth( opts *Broker_opts, verbose bool ) ( ai *auth_info, err error ) {
+	ai = &auth_info{ ppfn: opts.Passphrase }
+
+	signers := make( []ssh.Signer, 0, len( opts.Keys ) )
+	for i := range opts.Keys {
+		s, err := read_key_file_pp( opts.Keys[i], opts.Passphrase )
+		if err == nil {										// error isn't fatal to the process, but not having the key later might cause issues
+			signers = append( signers, s )					// never add nil entries (ssh crashes if they are there)
+		} else {
+			if verbose {
+				fmt.Fprintf( os.Stderr, "ssh_broker: unable to use key: %s: %s\n", opts.Keys[i], err )
+			}
+		}
+	}
+
+	csigners := mk_cert_signers( opts.Keys, signers, opts.Certs, verbose )
+	signers = append( csigners, signers... )				// offer certificates first
+
+	if opts.Use_agent || opts.Forward_agent {
+		sock := os.Getenv( "SSH_AUTH_SOCK" )
+		if sock == "" {
+			return nil, fmt.Errorf( "ssh agent requested but SSH_AUTH_SOCK is not set" )
+		}
+
+		ai.agent_conn, err = net.Dial( "unix", sock )
+		if err != nil {
+			return nil, fmt.Errorf( "unable to connect to ssh agent: %s", err )
+		}
+		ai.agent = agent.NewClient( ai.agent_conn )
+	}
+
+	if len( signers ) > 0 || ai.agent != nil {
+		ag := ai.agent
+		use_agent := opts.Use_agent
+		ai.pk_fn = func( ) ( []ssh.Signer, error ) {
+			if ag == nil || !use_agent {
+				return signers, nil
+			}
+
+			asigners, err := ag.Signers( )					// agent keys can change, so fetch each time
+			if err != nil {
+				return signers, nil
+			}
+			all := make( []ssh.Signer, 0, len( signers ) + len( asigners ) )		// don't append to the shared slice
+			all = append( all, signers... )
+			return append( all, asigners... ), nil
+		}
+		ai.methods = append( ai.methods, ssh.PublicKeysCallback( ai.pk_fn ) )
+	}
+
+	if opts.Password != "" {
+		pw := opts.Password
+		ai.others = append( ai.others, ssh.Password( pw ) )
+		ai.others = append( ai.others, ssh.KeyboardInteractive( func( name string, instr string, questions []string, echos []bool ) ( []string, error ) {
+			answers := make( []string, len( questions ) )
+			for i := range questions {
+				if !echos[i] {								// assume any non-echoed prompt wants the password
+					answers[i] = pw
+				}
+			}
+			return answers, nil
+		} ) )
+	}
+	ai.methods = append( ai.methods, ai.others... )
+
+	if len( ai.methods ) == 0 {
+		ai.close()
+		return nil, fmt.Errorf( "no suitable key or other authentication method found" )
+	}
+
+	return
+}
+
+/*
+	Close the agent connection if open.
+*/
+func ( ai *auth_info ) close( ) {
+	if ai != nil && ai.agent_conn != nil {
+		ai.agent_conn.Close()
+		ai.agent_conn = nil
+	}
+}
+
+/*
+	Set up agent forwarding on a new connection; requests are answered by our agent.
+*/
+func ( ai *auth_info ) forward( client *ssh.Client ) ( err error ) {
+	if ai == nil || ai.agent == nil {
+		return
+	}
+
+	return agent.ForwardToAgent( client, ai.agent )
+}
+
+/*
+	Return the authentication methods with the signers for the identity files (from the
+	ssh config) offered ahead of the broker's keys.  Files are read once; those which
+	cannot be read are skipped.
+*/
+func ( ai *auth_info ) with_ids( files []string, verbose bool ) ( methods []ssh.AuthMethod ) {
+	ai.id_lock.Lock()
+	if ai.ids == nil {
+		ai.ids = make( map[string]ssh.Signer )
+	}
+
+	ids := make( []ssh.Signer, 0, len( files ) )
+	for _, f := range files {
+		s, ok := ai.ids[f]
+		if !ok {
+			var err error
+			s, err = read_key_file_pp( f, ai.ppfn )
+			if err != nil && verbose {
+				fmt.Fprintf( os.Stderr, "ssh_broker: unable to use identity file: %s: %s\n", f, err )
+			}
+			ai.ids[f] = s								// nil is remembered so we don't try again
+		}
+		if s != nil {
+			ids = append( ids, s )
+		}
+	}
+	ai.id_lock.Unlock()
+
+	if len( ids ) == 0 {
+		return ai.methods
+	}
+
+	pk_fn := ai.pk_fn
+	methods = append( methods, ssh.PublicKeysCallback( func( ) ( []ssh.Signer, error ) {
+		if pk_fn == nil {
+			return ids, nil
+		}
+
+		signers, err := pk_fn()
+		all := make( []ssh.Signer, 0, len( ids ) + len( signers ) )
+		all = append( all, ids... )
+		return append( all, signers... ), err
+	} ) )
+
+	return append( methods, ai.others... )
+}
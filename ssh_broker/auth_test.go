@@ -0,0 +1,84 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	auth_test.go
+	Abstract:	Tests passphrase protected keys and certificate pairing.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeyAuth( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "auth" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	_, priv, _ := ed25519.GenerateKey( rand.Reader )
+	blk, err := ssh.MarshalPrivateKeyWithPassphrase( priv, "test", []byte( "secret" ) )
+	if err != nil {
+		t.Fatal( err )
+	}
+	kf := filepath.Join( dir, "id_ed25519" )
+	ioutil.WriteFile( kf, pem.EncodeToMemory( blk ), 0600 )
+
+	if _, err = read_key_file( kf ); err == nil {
+		t.Errorf( "encrypted key was read without a passphrase" )
+	}
+
+	ppfn := func( string ) ( []byte, error ) { return []byte( "secret" ), nil }
+	signer, err := read_key_file_pp( kf, ppfn )
+	if err != nil {
+		t.Fatalf( "unable to read key with passphrase: %s", err )
+	}
+
+	ca_signer, _ := ssh.NewSignerFromKey( priv )			// self signed is fine for pairing
+	cert := &ssh.Certificate{ Key: signer.PublicKey(), CertType: ssh.UserCert, ValidPrincipals: []string{ "scooter" }, ValidBefore: ssh.CertTimeInfinity }
+	if err = cert.SignCert( rand.Reader, ca_signer ); err != nil {
+		t.Fatal( err )
+	}
+	ioutil.WriteFile( kf + "-cert.pub", ssh.MarshalAuthorizedKey( cert ), 0600 )
+
+	cs := mk_cert_signers( []string{ kf }, []ssh.Signer{ signer }, nil, false )
+	if len( cs ) != 1 {
+		t.Fatalf( "expected one certificate signer, got %d", len( cs ) )
+	}
+	if _, ok := cs[0].PublicKey().( *ssh.Certificate ); !ok {
+		t.Errorf( "signer does not present a certificate" )
+	}
+
+	ai, err := mk_auth( &Broker_opts{ Keys: []string{ kf }, Passphrase: ppfn, Password: "pw" }, false )
+	if err != nil || len( ai.methods ) != 3 {
+		t.Errorf( "expected public key, password and keyboard-interactive methods: %v", err )
+	}
+}
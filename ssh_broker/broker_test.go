@@ -0,0 +1,146 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	broker_test.go
+	Abstract:	End to end tests of the broker against the in-process server (sshtest):
+				commands and scripts, the max-sessions retry path, and reconnect after
+				the connection is dropped.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/att/gopkgs/ssh_broker/sshtest"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	Start a server with the options (a key for user scooter is added) and a broker for it.
+	The returned function shuts both down.
+*/
+func mk_test_broker( t *testing.T, opts *sshtest.Server_opts ) ( *Broker, *sshtest.Server, func() ) {
+	dir, err := ioutil.TempDir( "", "broker" )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	kf := filepath.Join( dir, "id" )
+	pub, err := sshtest.Mk_key_file( kf )
+	if err != nil {
+		t.Fatal( err )
+	}
+	opts.Authorized = map[string][]ssh.PublicKey{ "scooter": { pub } }
+
+	srv, err := sshtest.Mk_server( opts )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	b, err := Mk_broker_opts( "scooter", &Broker_opts{ Keys: []string{ kf } } )
+	if err != nil {
+		t.Fatal( err )
+	}
+	b.verbose = false
+
+	return b, srv, func() { b.Close(); srv.Close(); os.RemoveAll( dir ) }
+}
+
+func TestBroker_run( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	stdout, _, err := b.Run_cmd( srv.Addr(), "echo hello; echo oops >&2" )
+	if err != nil || stdout.String() != "hello\n" {
+		t.Fatalf( "run_cmd: %q %v", stdout, err )
+	}
+
+	dir, _ := ioutil.TempDir( "", "script" )
+	defer os.RemoveAll( dir )
+	sname := filepath.Join( dir, "args.sh" )
+	ioutil.WriteFile( sname, []byte( "#!/bin/sh\n# a comment\necho \"$1-$2\"\nexit 3\n" ), 0755 )
+
+	stdout, _, err = b.Run_on_host( srv.Addr(), sname, "a b", "" )
+	if err == nil || stdout.String() != "a-b\n" {
+		t.Errorf( "run_on_host: %q %v", stdout, err )
+	}
+
+	msg, _ := b.Run_on_host_opts( srv.Addr(), sname, "a b", "", &Run_opts{ Verbatim: true } )
+	if code, _ := msg.Get_exit_status(); code != 3 || msg.Get_fail_class() != FAIL_REMOTE {
+		t.Errorf( "verbatim: expected exit 3 remote failure, got %d %s", code, Fail_class_name( msg.Get_fail_class() ) )
+	}
+}
+
+func TestBroker_retry( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{
+		Handler: sshtest.Script_handler( map[string]*sshtest.Reply{ "slow": { Stdout: "done\n", Delay: 200 * time.Millisecond } } ),
+		Max_sessions: 1,
+	} )
+	defer done()
+	if _, _, err := b.Run_cmd( srv.Addr(), "slow" ); err != nil {		// establish the connection before going parallel
+		t.Fatal( err )
+	}
+	b.Start_initiators( 2 )
+
+	ch := make( chan *Broker_msg, 3 )
+	for i := 0; i < 3; i++ {
+		b.NBRun_cmd( srv.Addr(), "slow", i, ch )
+	}
+	for i := 0; i < 3; i++ {
+		select {
+			case msg := <- ch:
+				if stdout, _, _, err := msg.Get_results(); err != nil || stdout.String() != "done\n" {
+					t.Errorf( "request failed: %q %v", stdout.String(), err )
+				}
+
+			case <- time.After( 20 * time.Second ):
+				t.Fatalf( "timeout waiting for results" )
+		}
+	}
+
+	hs := b.Stats()
+	if len( hs ) != 1 || hs[0].Retries == 0 || hs[0].Commands != 4 || hs[0].Failures != 0 {
+		t.Errorf( "expected retries and no failures: %v", hs )
+	}
+}
+
+func TestBroker_reconnect( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	if _, _, err := b.Run_cmd( srv.Addr(), "true" ); err != nil {
+		t.Fatal( err )
+	}
+
+	srv.Drop_conns()
+	time.Sleep( 50 * time.Millisecond )				// let the client notice
+	if _, _, err := b.Run_cmd( srv.Addr(), "true" ); err != nil {
+		t.Errorf( "command after drop failed: %s", err )
+	}
+	if srv.Nconns() != 2 {
+		t.Errorf( "expected a reconnect, server saw %d connections", srv.Nconns() )
+	}
+}
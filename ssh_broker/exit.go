@@ -0,0 +1,160 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	exit.go
+	Abstract: 	Captures the remote exit status and terminating signal, and classifies a
+				failed request so that callers (and the initiator's retry logic) can tell
+				a script which exited non-zero from a connection which was lost.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	Failure classes.
+*/
+const (
+	FAIL_NONE		int = iota	// the command ran and exited with 0
+	FAIL_SETUP					// a local problem (script not found, not a #! script...); nothing was run
+	FAIL_TRANSPORT				// connection, authentication or session failure; the command may not have run or finished
+	FAIL_REMOTE					// the command ran and exited non-zero or was killed by a signal on the remote host
+	FAIL_TIMEOUT				// the command was killed because its deadline passed or the caller cancelled
+)
+
+var fail_names = []string{ "none", "setup", "transport", "remote", "timeout" }
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Set the exit status and failure class in the request from the error returned by the
+	attempt. A class already set (e.g. setup) is not changed.
+*/
+func ( req *Broker_msg ) classify( err error ) {
+	req.exit_code = -1
+	req.signal = ""
+
+	if err == nil {
+		req.exit_code = 0
+		req.fail = FAIL_NONE
+		return
+	}
+
+	if req.timed_out || req.cancelled {
+		req.fail = FAIL_TIMEOUT
+		return
+	}
+
+	var ee *ssh.ExitError
+	if errors.As( err, &ee ) {
+		req.exit_code = ee.ExitStatus()
+		req.signal = ee.Signal()
+		req.fail = FAIL_REMOTE
+		return
+	}
+
+	if req.fail == FAIL_NONE {
+		req.fail = FAIL_TRANSPORT
+	}
+}
+
+/*
+	Returns true if the request failed in a way which is likely to succeed if retried:
+	the host's max session limit was reached, or the connection was lost (remote rebooted
+	or the connection was closed from the other end), while the session was being opened
+	or set up.  Once the command has been started it might have done some, or all, of its
+	work so it is never retried (a lost exit status or EOF after the start isn't retried).
+	A streamed request is not retried once any of its output has been sent to the sink
+	as the lines can't be taken back, and a request with standard input is never retried
+	as the input can only be read once.
+*/
+func ( req *Broker_msg ) retryable( ) ( bool ) {
+	if req.fail != FAIL_TRANSPORT || req.err == nil || req.started {
+		return false
+	}
+
+	if req.stdin != nil {
+		return false
+	}
+
+	if req.stream != nil && (atomic.LoadInt64( &req.nout ) > 0 || atomic.LoadInt64( &req.nerr ) > 0) {
+		return false
+	}
+
+	if errors.Is( req.err, io.EOF ) {
+		return true
+	}
+
+	estr := req.err.Error()
+	return strings.Contains( estr, "administratively prohibited" ) || strings.Contains( estr, "use of closed network connection" )
+}
+
+/*
+	Prepare the request to be run again.
+*/
+func ( req *Broker_msg ) reset( ) {
+	req.err = nil
+	req.started = false
+	req.fail = FAIL_NONE
+	req.exit_code = -1
+	req.signal = ""
+	req.stdout.Reset()					// don't return partial output from the failed attempt
+	req.stderr.Reset()
+	atomic.StoreInt64( &req.nout, 0 )
+	atomic.StoreInt64( &req.nerr, 0 )
+}
+
+// ----- public msg functions ------------------------------------------------------------------------------
+
+/*
+	Get_exit_status returns the exit code of the remote command and, if the command was
+	terminated by a signal, the signal name (e.g. KILL). The code is -1 if the command
+	did not run to completion (setup, transport or timeout failures).
+*/
+func ( m *Broker_msg ) Get_exit_status( ) ( code int, signal string ) {
+	return m.exit_code, m.signal
+}
+
+/*
+	Get_fail_class returns the failure class (FAIL_* constants) of the request.
+*/
+func ( m *Broker_msg ) Get_fail_class( ) ( int ) {
+	return m.fail
+}
+
+/*
+	Fail_class_name returns the name of the failure class.
+*/
+func Fail_class_name( class int ) ( string ) {
+	if class < 0 || class >= len( fail_names ) {
+		return "unknown"
+	}
+
+	return fail_names[class]
+}
@@ -0,0 +1,118 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	exit_test.go
+	Abstract:	Table tests for failure classification and the retry decision.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestClassify( t *testing.T ) {
+	tests := []struct {
+		name		string
+		err			error
+		preset		int					// class set before classify (e.g. by roar)
+		timed_out	bool
+		cancelled	bool
+		class		int
+		code		int
+	}{
+		{ "ok", nil, FAIL_NONE, false, false, FAIL_NONE, 0 },
+		{ "exit", &ssh.ExitError{ }, FAIL_NONE, false, false, FAIL_REMOTE, 0 },
+		{ "wrapped exit", fmt.Errorf( "run: %w", &ssh.ExitError{ } ), FAIL_NONE, false, false, FAIL_REMOTE, 0 },
+		{ "exit missing", &ssh.ExitMissingError{ }, FAIL_NONE, false, false, FAIL_TRANSPORT, -1 },
+		{ "eof", io.EOF, FAIL_NONE, false, false, FAIL_TRANSPORT, -1 },
+		{ "prohibited", fmt.Errorf( "ssh: rejected: administratively prohibited" ), FAIL_NONE, false, false, FAIL_TRANSPORT, -1 },
+		{ "setup kept", fmt.Errorf( "not a #! script" ), FAIL_SETUP, false, false, FAIL_SETUP, -1 },
+		{ "timed out", fmt.Errorf( "command timed out" ), FAIL_NONE, true, false, FAIL_TIMEOUT, -1 },
+		{ "cancelled", fmt.Errorf( "command cancelled" ), FAIL_NONE, false, true, FAIL_TIMEOUT, -1 },
+	}
+
+	for _, tc := range tests {
+		req := &Broker_msg{ fail: tc.preset, timed_out: tc.timed_out, cancelled: tc.cancelled }
+		req.classify( tc.err )
+		if req.fail != tc.class {
+			t.Errorf( "%s: expected class %s, got %s", tc.name, Fail_class_name( tc.class ), Fail_class_name( req.fail ) )
+		}
+		if code, _ := req.Get_exit_status(); code != tc.code {
+			t.Errorf( "%s: expected exit code %d, got %d", tc.name, tc.code, code )
+		}
+	}
+}
+
+func TestRetryable( t *testing.T ) {
+	prohibited := fmt.Errorf( "ssh: rejected: administratively prohibited (too many sessions)" )
+	closed := fmt.Errorf( "write tcp 127.0.0.1:1->127.0.0.1:22: use of closed network connection" )
+
+	tests := []struct {
+		name		string
+		err			error
+		preset		int
+		started		bool
+		output		bool					// streamed output was sent
+		stdin		bool					// request has standard input
+		retry		bool
+	}{
+		{ "ok", nil, FAIL_NONE, true, false, false, false },
+		{ "session limit", prohibited, FAIL_NONE, false, false, false, true },
+		{ "eof opening session", io.EOF, FAIL_NONE, false, false, false, true },
+		{ "closed opening session", closed, FAIL_NONE, false, false, false, true },
+		{ "eof after start", io.EOF, FAIL_NONE, true, false, false, false },
+		{ "closed after start", closed, FAIL_NONE, true, false, false, false },
+		{ "exit missing", &ssh.ExitMissingError{ }, FAIL_NONE, true, false, false, false },
+		{ "remote exit", &ssh.ExitError{ }, FAIL_NONE, true, false, false, false },
+		{ "connect refused", fmt.Errorf( "dial tcp 127.0.0.1:22: connect: connection refused" ), FAIL_NONE, false, false, false, false },
+		{ "setup", io.EOF, FAIL_SETUP, false, false, false, false },
+		{ "streamed output sent", prohibited, FAIL_NONE, false, true, false, false },
+		{ "stdin given", prohibited, FAIL_NONE, false, false, true, false },
+	}
+
+	for _, tc := range tests {
+		req := &Broker_msg{ fail: tc.preset, started: tc.started }
+		if tc.output {
+			req.stream = &Stream_sink{ }
+			req.nout = 10
+		}
+		if tc.stdin {
+			req.stdin = strings.NewReader( "input" )
+		}
+		req.err = tc.err
+		req.classify( tc.err )
+		if got := req.retryable(); got != tc.retry {
+			t.Errorf( "%s: expected retryable %v, got %v", tc.name, tc.retry, got )
+		}
+	}
+
+	req := &Broker_msg{ started: true, nout: 10 }
+	req.reset()
+	if req.started || req.nout != 0 {
+		t.Errorf( "reset did not clear the started flag or counts" )
+	}
+}
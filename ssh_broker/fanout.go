@@ -0,0 +1,201 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	fanout.go
+	Abstract: 	Runs the same script or command on a list of hosts. Requests are queued to
+				the initiators (no more than the concurrency limit at any time) and the
+				results collected into a single result set with a summary. Optionally,
+				no further hosts are started once a number of failures have been seen.
+
+				The number of requests which actually run in parallel is also limited
+				by the number of initiators (see Start_initiators()).
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/*
+	Options for the fan-out functions.
+*/
+type Fanout_opts struct {
+	Max_parallel	int				// max requests queued at once; 0 is no limit
+	Max_fail		int				// stop starting hosts after this many failures; 0 never stops
+	Run				*Run_opts		// options applied to each request (context, stream)
+}
+
+/*
+	The result from one host.
+*/
+type Host_result struct {
+	Host		string
+	Stdout		bytes.Buffer
+	Stderr		bytes.Buffer
+	Exit_code	int					// -1 if the command didn't complete
+	Signal		string
+	Fail_class	int					// FAIL_ constant
+	Elapsed		int64				// seconds
+	Err			error
+	Skipped		bool				// not run because the failure limit was reached
+}
+
+/*
+	The results from all hosts, in the same order as the host list, and a summary.
+*/
+type Fanout_result struct {
+	Results		[]*Host_result
+	Nok			int
+	Nfailed		int
+	Nskipped	int
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Queue one request per host using submit (which queues to the initiators with the
+	id and channel given) and collect the results.
+*/
+func ( b *Broker ) fanout( hosts []string, opts *Fanout_opts, submit func( host string, id int, ch chan *Broker_msg ) error ) ( fr *Fanout_result ) {
+	if opts == nil {
+		opts = &Fanout_opts{ }
+	}
+
+	max := opts.Max_parallel
+	if max <= 0 || max > len( hosts ) {
+		max = len( hosts )
+	}
+
+	fr = &Fanout_result{ Results: make( []*Host_result, len( hosts ) ) }
+	rch := make( chan *Broker_msg, max )			// large enough that initiators never block writing results
+	next := 0										// next host to start
+	pending := 0
+
+	for next < len( hosts ) || pending > 0 {
+		for next < len( hosts ) && pending < max && ( opts.Max_fail <= 0 || fr.Nfailed < opts.Max_fail ) {
+			if err := submit( hosts[next], next, rch ); err != nil {
+				fr.Results[next] = &Host_result{ Host: hosts[next], Exit_code: -1, Fail_class: FAIL_SETUP, Err: err }
+				fr.Nfailed++
+			} else {
+				pending++
+			}
+			next++
+		}
+
+		if pending == 0 {							// failure limit reached and nothing running
+			break
+		}
+
+		msg := <- rch
+		pending--
+
+		hr := &Host_result{
+			Host: hosts[msg.id],
+			Stdout: msg.stdout,
+			Stderr: msg.stderr,
+			Exit_code: msg.exit_code,
+			Signal: msg.signal,
+			Fail_class: msg.fail,
+			Elapsed: msg.endt - msg.startt,
+			Err: msg.err,
+		}
+		fr.Results[msg.id] = hr
+
+		if hr.Err != nil {
+			fr.Nfailed++
+		} else {
+			fr.Nok++
+		}
+	}
+
+	for i := next; i < len( hosts ); i++ {
+		fr.Results[i] = &Host_result{ Host: hosts[i], Exit_code: -1, Skipped: true }
+		fr.Nskipped++
+	}
+
+	return
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Fanout_script runs the local script on each of the hosts and blocks until all have finished
+	(or the failure limit is reached and the running requests have finished).
+*/
+func ( b *Broker ) Fanout_script( hosts []string, script string, parms string, env_file string, opts *Fanout_opts ) ( *Fanout_result ) {
+	var ropts *Run_opts
+	if opts != nil {
+		ropts = opts.Run
+	}
+
+	return b.fanout( hosts, opts, func( host string, id int, ch chan *Broker_msg ) error {
+		return b.NBRun_on_host_opts( host, script, parms, env_file, id, ch, ropts )
+	} )
+}
+
+/*
+	Fanout_cmd runs the command on each of the hosts and blocks until all have finished
+	(or the failure limit is reached and the running requests have finished).
+*/
+func ( b *Broker ) Fanout_cmd( hosts []string, cmd string, opts *Fanout_opts ) ( *Fanout_result ) {
+	var ropts *Run_opts
+	if opts != nil {
+		ropts = opts.Run
+	}
+
+	return b.fanout( hosts, opts, func( host string, id int, ch chan *Broker_msg ) error {
+		return b.NBRun_cmd_opts( host, cmd, id, ch, ropts )
+	} )
+}
+
+/*
+	Failed returns the results for the hosts which failed.
+*/
+func ( fr *Fanout_result ) Failed( ) ( list []*Host_result ) {
+	for _, hr := range fr.Results {
+		if hr.Err != nil {
+			list = append( list, hr )
+		}
+	}
+
+	return
+}
+
+/*
+	String returns a one line summary of the results.
+*/
+func ( fr *Fanout_result ) String( ) ( string ) {
+	failed := make( []string, 0, fr.Nfailed )
+	for _, hr := range fr.Failed() {
+		failed = append( failed, fmt.Sprintf( "%s(%s)", hr.Host, Fail_class_name( hr.Fail_class ) ) )
+	}
+
+	s := fmt.Sprintf( "%d hosts: %d ok, %d failed, %d skipped", len( fr.Results ), fr.Nok, fr.Nfailed, fr.Nskipped )
+	if len( failed ) > 0 {
+		s += ": " + strings.Join( failed, " " )
+	}
+
+	return s
+}
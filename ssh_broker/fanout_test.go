@@ -0,0 +1,67 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	fanout_test.go
+	Abstract:	Tests the fan-out bookkeeping (concurrency limit and early stop) with a
+				fake submit function in place of the initiators.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFanout( t *testing.T ) {
+	b := &Broker{ }
+	hosts := []string{ "h0", "h1", "h2", "h3", "h4", "h5" }
+
+	submit := func( host string, id int, ch chan *Broker_msg ) error {
+		msg := &Broker_msg{ host: host, id: id }
+		if host == "h1" || host == "h2" {
+			msg.err = fmt.Errorf( "exit 3" )
+			msg.fail = FAIL_REMOTE
+		}
+		go func() {
+			ch <- msg			// fake requests 'complete' immediately
+		}()
+		return nil
+	}
+
+	fr := b.fanout( hosts, &Fanout_opts{ Max_parallel: 2 }, submit )
+	if fr.Nok != 4 || fr.Nfailed != 2 || fr.Nskipped != 0 {
+		t.Errorf( "unexpected summary: %s", fr )
+	}
+	for i, hr := range fr.Results {
+		if hr.Host != hosts[i] {
+			t.Errorf( "results out of order: %d %s", i, hr.Host )
+		}
+	}
+
+	fr = b.fanout( hosts, &Fanout_opts{ Max_parallel: 1, Max_fail: 1 }, submit )
+	if fr.Nfailed != 1 || fr.Nskipped != 4 || !fr.Results[5].Skipped {
+		t.Errorf( "early stop failed: %s", fr )
+	}
+	if fr.String() != "6 hosts: 1 ok, 1 failed, 4 skipped: h1(remote)" {
+		t.Errorf( "unexpected summary string: %s", fr )
+	}
+}
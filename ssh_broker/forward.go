@@ -0,0 +1,359 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	forward.go
+	Abstract: 	Port forwarding over the pooled connection to a host.  A local forward
+				(ssh -L) listens on a local address and tunnels each connection accepted
+				to an address as seen from the remote host (e.g. localhost:6640 on a
+				compute node).  A remote forward (ssh -R) listens on the remote host and
+				tunnels each connection to a local address.
+
+				Local forwards fetch the pooled connection for each new tunnel, so they
+				follow a reconnect.  A remote listener is lost with its connection; it is
+				reestablished on the new connection (retried every second) until the
+				forward is closed.  A remote listener, and each open tunnel, counts as use
+				of the connection it is on so that the connection isn't evicted as idle.
+
+				Forwards are closed by their Close(), or for all of a host's forwards by
+				Close_session(), or all forwards by the broker's Close().
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	An active port forward.
+*/
+type Forward struct {
+	b			*Broker
+	host		string					// host:port the tunnel is through
+	remote		bool					// listener is on the remote host
+	laddr		string					// local address (listen or connect)
+	raddr		string					// remote address (connect or listen)
+
+	lock		sync.Mutex
+	listener	net.Listener			// current listener (remote listener changes on reconnect)
+	conn		*connection				// connection the remote listener is on; counted in use while held
+	conns		map[net.Conn]bool		// tunnelled connections (local side) open now
+	closed		bool
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Copy in both directions until either side finishes, then close both.
+*/
+func ( f *Forward ) pipe( a net.Conn, b net.Conn ) {
+	f.lock.Lock()
+	if f.closed {
+		f.lock.Unlock()
+		a.Close()
+		b.Close()
+		return
+	}
+	f.conns[a] = true
+	f.lock.Unlock()
+
+	done := make( chan bool, 2 )
+	go func() {
+		io.Copy( a, b )
+		done <- true
+	}()
+	go func() {
+		io.Copy( b, a )
+		done <- true
+	}()
+
+	<- done
+	a.Close()
+	b.Close()
+	<- done
+
+	f.lock.Lock()
+	delete( f.conns, a )
+	f.lock.Unlock()
+}
+
+/*
+	Accept local connections and tunnel each to the remote address.
+*/
+func ( f *Forward ) serve_local( ) {
+	for {
+		lc, err := f.listener.Accept()
+		if err != nil {
+			return											// closed
+		}
+
+		go func() {
+			c, client, err := f.hold()
+			if err == nil {
+				defer c.busy( -1 )							// an open tunnel counts as use for idle eviction
+
+				var rc net.Conn
+				if rc, err = client.Dial( "tcp", f.raddr ); err == nil {
+					f.pipe( lc, rc )
+					return
+				}
+			}
+
+			if f.b.verbose {
+				fmt.Fprintf( os.Stderr, "ssh_broker: forward to %s via %s failed: %s\n", f.raddr, f.host, err )
+			}
+			lc.Close()
+		}()
+	}
+}
+
+/*
+	Return the host's connection, connecting if needed, and its client. The connection is
+	counted in use; the caller must release it with c.busy( -1 ) when finished.
+*/
+func ( f *Forward ) hold( ) ( c *connection, client *ssh.Client, err error ) {
+	if c, err = f.b.connect2( f.host ); err != nil {
+		return nil, nil, err
+	}
+
+	if client = c.hold(); client == nil {
+		return nil, nil, fmt.Errorf( "connection to %s is down", f.host )
+	}
+	return
+}
+
+/*
+	Establish the listener on the remote host. The connection the listener is on is
+	returned still counted in use.
+*/
+func ( f *Forward ) listen_remote( ) ( l net.Listener, c *connection, err error ) {
+	c, client, err := f.hold()
+	if err != nil {
+		return
+	}
+
+	if l, err = client.Listen( "tcp", f.raddr ); err != nil {
+		c.busy( -1 )
+		return nil, nil, err
+	}
+	return
+}
+
+/*
+	Install a new remote listener and the connection it is on, releasing the connection
+	held for the previous listener. If the forward was closed the new listener is closed
+	and its connection released.
+*/
+func ( f *Forward ) set_listener( l net.Listener, c *connection ) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		l.Close()
+		c.busy( -1 )
+		return
+	}
+
+	if f.conn != nil {
+		f.conn.busy( -1 )
+	}
+	f.listener = l
+	f.conn = c
+}
+
+/*
+	Accept connections on the remote listener and tunnel each to the local address. When
+	the listener fails (connection lost) it is reestablished until the forward is closed.
+*/
+func ( f *Forward ) serve_remote( ) {
+	l := f.listener
+	for {
+		rc, err := l.Accept()
+		if err == nil {
+			go func() {
+				lc, err := net.Dial( "tcp", f.laddr )
+				if err != nil {
+					if f.b.verbose {
+						fmt.Fprintf( os.Stderr, "ssh_broker: reverse forward to %s failed: %s\n", f.laddr, err )
+					}
+					rc.Close()
+					return
+				}
+				f.pipe( lc, rc )
+			}()
+			continue
+		}
+
+		for {
+			f.lock.Lock()
+			closed := f.closed
+			f.lock.Unlock()
+			if closed || f.b.was_closed {
+				return
+			}
+
+			time.Sleep( time.Second )
+			var c *connection
+			if l, c, err = f.listen_remote(); err == nil {
+				f.set_listener( l, c )
+				break
+			}
+		}
+	}
+}
+
+/*
+	Add the forward to the broker's list.
+*/
+func ( b *Broker ) add_fwd( f *Forward ) {
+	b.cfg_lock.Lock()
+	if b.fwds == nil {
+		b.fwds = make( map[*Forward]bool )
+	}
+	b.fwds[f] = true
+	b.cfg_lock.Unlock()
+}
+
+/*
+	Close all forwards through the host; all forwards if host is empty.
+*/
+func ( b *Broker ) close_fwds( host string ) {
+	if host != "" {
+		host = b.host_port( host )
+	}
+
+	b.cfg_lock.RLock()
+	list := make( []*Forward, 0, len( b.fwds ) )
+	for f := range b.fwds {
+		if host == "" || f.host == host {
+			list = append( list, f )
+		}
+	}
+	b.cfg_lock.RUnlock()
+
+	for _, f := range list {
+		f.Close()
+	}
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Forward_local listens on the local address (e.g. "127.0.0.1:0" for any free port) and
+	tunnels each connection to the remote address (e.g. "localhost:6640") as seen from host.
+	Use Local_addr() to find the port chosen.
+*/
+func ( b *Broker ) Forward_local( host string, laddr string, raddr string ) ( f *Forward, err error ) {
+	if b == nil || b.was_closed {
+		return nil, fmt.Errorf( "forward_local: broker pointer was nil, or broker has been closed" )
+	}
+
+	f = &Forward{ b: b, host: b.host_port( host ), laddr: laddr, raddr: raddr, conns: make( map[net.Conn]bool ) }
+	if _, err = b.connect2( host ); err != nil {			// fail now if the host can't be reached
+		return nil, err
+	}
+
+	if f.listener, err = net.Listen( "tcp", laddr ); err != nil {
+		return nil, err
+	}
+
+	b.add_fwd( f )
+	go f.serve_local()
+
+	return
+}
+
+/*
+	Forward_remote listens on the remote address on host (e.g. "127.0.0.1:0" for any free
+	port; the ssh server may restrict the address) and tunnels each connection to the local
+	address.  Use Remote_addr() to find the port chosen.
+*/
+func ( b *Broker ) Forward_remote( host string, raddr string, laddr string ) ( f *Forward, err error ) {
+	if b == nil || b.was_closed {
+		return nil, fmt.Errorf( "forward_remote: broker pointer was nil, or broker has been closed" )
+	}
+
+	f = &Forward{ b: b, host: b.host_port( host ), remote: true, laddr: laddr, raddr: raddr, conns: make( map[net.Conn]bool ) }
+	if f.listener, f.conn, err = f.listen_remote(); err != nil {		// listener holds the connection against idle eviction
+		return nil, err
+	}
+	f.raddr = f.listener.Addr().String()						// reestablish on the same port if one was assigned
+
+	b.add_fwd( f )
+	go f.serve_remote()
+
+	return
+}
+
+/*
+	Local_addr returns the local address; the listening address for a local forward.
+*/
+func ( f *Forward ) Local_addr( ) ( string ) {
+	if f.remote {
+		return f.laddr
+	}
+
+	return f.listener.Addr().String()
+}
+
+/*
+	Remote_addr returns the remote address; the listening address for a remote forward.
+*/
+func ( f *Forward ) Remote_addr( ) ( string ) {
+	return f.raddr
+}
+
+/*
+	Close stops the listener and closes all connections tunnelled by the forward.
+*/
+func ( f *Forward ) Close( ) {
+	if f == nil {
+		return
+	}
+
+	f.lock.Lock()
+	if f.closed {
+		f.lock.Unlock()
+		return
+	}
+	f.closed = true
+	f.listener.Close()
+	for c := range f.conns {
+		c.Close()
+	}
+	if f.conn != nil {
+		f.conn.busy( -1 )
+		f.conn = nil
+	}
+	f.lock.Unlock()
+
+	f.b.cfg_lock.Lock()
+	delete( f.b.fwds, f )
+	f.b.cfg_lock.Unlock()
+}
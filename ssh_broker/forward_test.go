@@ -0,0 +1,167 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	forward_test.go
+	Abstract:	Tests local and remote forwarding through the in-process server to an
+				echo service, and that Close_session closes the forwards.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/att/gopkgs/ssh_broker/sshtest"
+)
+
+/*
+	Start a line echo service on a loopback port.
+*/
+func mk_echo( t *testing.T ) ( net.Listener ) {
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy( c, c )
+				c.Close()
+			}()
+		}
+	}()
+
+	return l
+}
+
+/*
+	Send a line to the address and expect it back.
+*/
+func echo_through( t *testing.T, addr string, msg string ) {
+	c, err := net.Dial( "tcp", addr )
+	if err != nil {
+		t.Fatalf( "dial %s: %s", addr, err )
+	}
+	defer c.Close()
+
+	io.WriteString( c, msg + "\n" )
+	got, err := bufio.NewReader( c ).ReadString( '\n' )
+	if err != nil || got != msg + "\n" {
+		t.Errorf( "echo through %s: %q %v", addr, got, err )
+	}
+}
+
+func TestForward( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	echo := mk_echo( t )
+	defer echo.Close()
+
+	lf, err := b.Forward_local( srv.Addr(), "127.0.0.1:0", echo.Addr().String() )
+	if err != nil {
+		t.Fatalf( "forward_local: %s", err )
+	}
+	echo_through( t, lf.Local_addr(), "local" )
+
+	rf, err := b.Forward_remote( srv.Addr(), "127.0.0.1:0", echo.Addr().String() )
+	if err != nil {
+		t.Fatalf( "forward_remote: %s", err )
+	}
+	echo_through( t, rf.Remote_addr(), "remote" )
+
+	host := srv.Addr()
+	b.Close_session( &host )
+	if c, err := net.Dial( "tcp", lf.Local_addr() ); err == nil {
+		c.Close()
+		t.Errorf( "local forward still listening after close_session" )
+	}
+	if len( b.fwds ) != 0 {
+		t.Errorf( "forwards not removed from broker: %d", len( b.fwds ) )
+	}
+}
+
+/*
+	Return the connection the forward's remote listener holds.
+*/
+func fwd_conn( f *Forward ) ( *connection ) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.conn
+}
+
+/*
+	The remote listener must hold, and on close release, the connection it is on; after
+	a reset it moves to the new connection.
+*/
+func TestForward_in_use( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	echo := mk_echo( t )
+	defer echo.Close()
+
+	rf, err := b.Forward_remote( srv.Addr(), "127.0.0.1:0", echo.Addr().String() )
+	if err != nil {
+		t.Fatalf( "forward_remote: %s", err )
+	}
+	c1 := fwd_conn( rf )
+	if c1 == nil || c1 != b.get_conn( srv.Addr() ) {
+		t.Fatalf( "forward does not hold the host's connection" )
+	}
+	if n := atomic.LoadInt32( &c1.in_use ); n != 1 {
+		t.Errorf( "expected in use count of 1 with a remote listener, got %d", n )
+	}
+
+	b.Reset()
+	var c2 *connection
+	for i := 0; i < 50; i++ {
+		if c2 = fwd_conn( rf ); c2 != c1 {
+			break
+		}
+		time.Sleep( 100 * time.Millisecond )
+	}
+	if c2 == c1 {
+		t.Fatalf( "remote listener not reestablished after reset" )
+	}
+	if n := atomic.LoadInt32( &c1.in_use ); n != 0 {
+		t.Errorf( "old connection still counted in use after reset: %d", n )
+	}
+	if n := atomic.LoadInt32( &c2.in_use ); n != 1 {
+		t.Errorf( "expected new connection in use count of 1, got %d", n )
+	}
+
+	rf.Close()
+	if n := atomic.LoadInt32( &c2.in_use ); n != 0 {
+		t.Errorf( "connection still counted in use after close: %d", n )
+	}
+}
@@ -0,0 +1,431 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	health.go
+	Abstract: 	Connection health and pool statistics.  When a keepalive interval (broker
+				option or ServerAliveInterval from the ssh config) or an idle timeout is set
+				each pooled connection is watched by a goroutine which:
+					- sends keepalive@openssh.com requests; an error, or three unanswered
+					  requests in a row, causes the connection to be closed and then
+					  reconnected in the background (up to max_reconnect tries) so that
+					  the next command doesn't wait for it.
+					- closes the connection when no command has used it for the idle
+					  timeout.  A connection used as a jump host is not closed while
+					  any connection is tunnelled through it.
+				The watcher ends when its ssh client is replaced or closed.
+
+				Counters kept for each host are returned by Stats().
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	max_missed		int = 3				// unanswered keepalives before the connection is declared dead
+	max_reconnect	int = 3				// background reconnect attempts after a keepalive failure
+)
+
+/*
+	Statistics for one host returned by Stats().
+*/
+type Host_stats struct {
+	Host		string
+	Active		bool				// connection is currently up
+	Age			time.Duration		// time since the connection was (re)established
+	Idle		time.Duration		// time since a command last used the connection
+	In_use		int					// sessions currently running a command
+	Commands	int64				// commands completed (ok or failed)
+	Retries		int64				// commands requeued for retry
+	Failures	int64				// commands which failed
+	Reconnects	int					// background reconnects after a keepalive failure
+	Last_err	string				// most recent failure; empty if none
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Return the connection for the host if there is one; does not connect.
+*/
+func ( b *Broker ) get_conn( host string ) ( c *connection ) {
+	b.conns_lock.RLock()
+	c = b.conns[b.host_port( host )]
+	b.conns_lock.RUnlock()
+
+	return
+}
+
+/*
+	Adjust the count of sessions in use and note the time the connection was last used.
+*/
+func ( c *connection ) busy( delta int32 ) {
+	atomic.AddInt32( &c.in_use, delta )
+	atomic.StoreInt64( &c.last_cmd, time.Now().Unix() )
+}
+
+/*
+	Count a user of the connection (see busy) and return the client to use. The count is
+	made under the lock so that an idle check can't close the client between the caller
+	getting it and using it; the caller must call busy( -1 ) when finished.  Nil is returned,
+	and nothing is counted, if the connection is down.
+*/
+func ( c *connection ) hold( ) ( client *ssh.Client ) {
+	c.stat_lock.Lock()
+	defer c.stat_lock.Unlock()
+
+	if ! c.active {
+		return nil
+	}
+
+	c.busy( 1 )
+	return c.schan
+}
+
+/*
+	Return the client to tunnel a connection through, counting the tunnel so that the
+	connection isn't closed as idle while it is in use (see watch()). The count is made
+	under the lock for the same reason as in hold(); the caller must call untunnel() when
+	the tunnel fails or the connection through it closes. Nil is returned, and nothing is
+	counted, if the connection is down.
+*/
+func ( c *connection ) tunnel( ) ( client *ssh.Client ) {
+	c.stat_lock.Lock()
+	defer c.stat_lock.Unlock()
+
+	if ! c.active {
+		return nil
+	}
+
+	atomic.AddInt32( &c.tunnels, 1 )
+	return c.schan
+}
+
+/*
+	Release a tunnel counted by tunnel(). The idle time starts from now.
+*/
+func ( c *connection ) untunnel( ) {
+	atomic.AddInt32( &c.tunnels, -1 )
+	atomic.StoreInt64( &c.last_cmd, time.Now().Unix() )
+}
+
+/*
+	Returns true if files have been synced to the host (it has been used other than as a
+	jump host).
+*/
+func ( c *connection ) synced( ) ( bool ) {
+	c.stat_lock.Lock()
+	defer c.stat_lock.Unlock()
+
+	return c.sync
+}
+
+/*
+	Mark the connection as used other than as a jump host.
+*/
+func ( c *connection ) set_sync( ) {
+	c.stat_lock.Lock()
+	c.sync = true
+	c.stat_lock.Unlock()
+}
+
+/*
+	Return the client for the connection; nil if the connection is down.
+*/
+func ( c *connection ) client( ) ( *ssh.Client ) {
+	c.stat_lock.Lock()
+	defer c.stat_lock.Unlock()
+
+	if ! c.active {
+		return nil
+	}
+	return c.schan
+}
+
+/*
+	Returns true if the connection is up.
+*/
+func ( c *connection ) is_active( ) ( bool ) {
+	c.stat_lock.Lock()
+	defer c.stat_lock.Unlock()
+
+	return c.active
+}
+
+/*
+	Mark the connection down and close its client so that the next user reconnects. If client
+	is not nil the connection is only dropped if it is still up using that client; nothing is
+	done if someone else has already dropped it (and maybe reconnected).
+*/
+func ( c *connection ) drop( client *ssh.Client ) ( err error ) {
+	c.stat_lock.Lock()
+	if client != nil && (client != c.schan || ! c.active) {
+		c.stat_lock.Unlock()
+		return nil
+	}
+	client = c.schan
+	c.active = false
+	c.stat_lock.Unlock()
+
+	if client != nil {
+		err = client.Close()
+	}
+	return
+}
+
+/*
+	Count the result of the request; retry is true if it is being requeued.
+*/
+func ( c *connection ) count( req *Broker_msg, retry bool ) {
+	if c == nil {
+		return
+	}
+
+	c.stat_lock.Lock()
+	defer c.stat_lock.Unlock()
+
+	if req.err != nil {
+		c.last_err = req.err.Error()
+	}
+
+	if retry {
+		c.nretries++
+		return
+	}
+
+	c.ncmds++
+	if req.err != nil {
+		c.nfails++
+	}
+}
+
+/*
+	Start the watcher for a newly established connection if there is anything to watch for.
+	Alive is the keepalive interval from the ssh config and takes precedence over the
+	broker's.
+*/
+func ( b *Broker ) start_watch( c *connection, client *ssh.Client, alive time.Duration ) {
+	b.cfg_lock.RLock()
+	if alive <= 0 {
+		alive = b.alive
+	}
+	idle := b.idle
+	b.cfg_lock.RUnlock()
+
+	if alive > 0 || idle > 0 {
+		go b.watch( c, client, alive, idle )
+	}
+}
+
+/*
+	Send a keepalive request waiting no longer than the timeout for the reply.
+	Returns false if no reply was received in time, and an error if the request failed.
+*/
+func probe( client *ssh.Client, timeout time.Duration ) ( ok bool, err error ) {
+	rch := make( chan error, 1 )
+	go func() {
+		_, _, err := client.SendRequest( "keepalive@openssh.com", true, nil )
+		rch <- err
+	}()
+
+	select {
+		case err = <- rch:
+			return err == nil, err
+
+		case <- time.After( timeout ):
+			return false, nil
+	}
+}
+
+/*
+	Watch the client established for the connection; see the abstract.
+*/
+func ( b *Broker ) watch( c *connection, client *ssh.Client, alive time.Duration, idle time.Duration ) {
+	tick := alive
+	if tick <= 0 || (idle > 0 && idle < tick) {
+		tick = idle
+	}
+
+	missed := 0
+	for {
+		time.Sleep( tick )
+
+		c.stat_lock.Lock()
+		current := c.schan == client && c.active
+		evict := current && idle > 0 && atomic.LoadInt32( &c.in_use ) == 0 && atomic.LoadInt32( &c.tunnels ) == 0 && time.Since( time.Unix( atomic.LoadInt64( &c.last_cmd ), 0 ) ) > idle
+		if evict {
+			c.active = false							// under the lock so that hold() can't hand it out now
+		}
+		c.stat_lock.Unlock()
+
+		if b.was_closed || !current {					// replaced, closed, or someone else noticed it failed
+			return
+		}
+
+		if evict {
+			if b.verbose {
+				fmt.Fprintf( os.Stderr, "ssh_broker: closing idle connection to %s\n", c.host )
+			}
+			client.Close()
+			return
+		}
+
+		if alive <= 0 {
+			continue
+		}
+
+		ok, err := probe( client, tick )
+		if ok {
+			missed = 0
+			continue
+		}
+
+		missed++
+		if err == nil && missed < max_missed {
+			continue
+		}
+
+		if err == nil {
+			err = fmt.Errorf( "%d keepalive requests not answered", missed )
+		}
+		c.stat_lock.Lock()
+		c.last_err = fmt.Sprintf( "keepalive failed: %s", err )
+		c.stat_lock.Unlock()
+
+		c.drop( client )
+		b.reconnect( c, tick )
+		return
+	}
+}
+
+/*
+	Attempt to reestablish a connection which failed its keepalive. Gives up after a few
+	tries; the next command for the host will try again.
+*/
+func ( b *Broker ) reconnect( c *connection, pause time.Duration ) {
+	for i := 0; i < max_reconnect && !b.was_closed; i++ {
+		_, err := b.connect3( c.host, c.chain, c.synced() )		// success starts a new watcher
+		if err == nil {
+			c.stat_lock.Lock()
+			c.nreconnects++
+			c.stat_lock.Unlock()
+			return
+		}
+
+		if b.verbose {
+			fmt.Fprintf( os.Stderr, "ssh_broker: reconnect to %s failed: %s\n", c.host, err )
+		}
+		time.Sleep( pause )
+	}
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Set_keepalive sets the interval between keepalive requests and the idle timeout for
+	connections. Zero disables either. Only connections established after the call are
+	affected.  A ServerAliveInterval in the ssh config overrides the interval for the
+	hosts it applies to.
+*/
+func ( b *Broker ) Set_keepalive( alive time.Duration, idle time.Duration ) {
+	if b == nil {
+		return
+	}
+
+	b.cfg_lock.Lock()
+	b.alive = alive
+	b.idle = idle
+	b.cfg_lock.Unlock()
+}
+
+/*
+	Stats returns the statistics for each host in the pool, sorted by host.
+*/
+func ( b *Broker ) Stats( ) ( hs []*Host_stats ) {
+	if b == nil {
+		return nil
+	}
+
+	b.conns_lock.RLock()
+	conns := make( []*connection, 0, len( b.conns ) )
+	for _, c := range b.conns {
+		if c != nil {
+			conns = append( conns, c )
+		}
+	}
+	b.conns_lock.RUnlock()
+
+	now := time.Now()
+	hs = make( []*Host_stats, 0, len( conns ) )
+	for _, c := range conns {
+		c.stat_lock.Lock()
+		s := &Host_stats{
+			Host: c.host,
+			Active: c.active,
+			Idle: now.Sub( time.Unix( atomic.LoadInt64( &c.last_cmd ), 0 ) ),
+			In_use: int( atomic.LoadInt32( &c.in_use ) ),
+			Commands: c.ncmds,
+			Retries: c.nretries,
+			Failures: c.nfails,
+			Reconnects: c.nreconnects,
+			Last_err: c.last_err,
+		}
+		if c.active {
+			s.Age = now.Sub( c.since )
+		}
+		c.stat_lock.Unlock()
+
+		hs = append( hs, s )
+	}
+
+	sort.Slice( hs, func( i, j int ) bool { return hs[i].Host < hs[j].Host } )
+	return
+}
+
+/*
+	String returns a one line summary of the host's statistics.
+*/
+func ( hs *Host_stats ) String( ) ( string ) {
+	if hs == nil {
+		return "<nil>"
+	}
+
+	state := "down"
+	if hs.Active {
+		state = "up"
+	}
+	s := fmt.Sprintf( "%s %s age=%s idle=%s in_use=%d cmds=%d retries=%d fails=%d reconnects=%d",
+		hs.Host, state, hs.Age.Truncate( time.Second ), hs.Idle.Truncate( time.Second ), hs.In_use,
+		hs.Commands, hs.Retries, hs.Failures, hs.Reconnects )
+	if hs.Last_err != "" {
+		s += " last_err=" + hs.Last_err
+	}
+
+	return s
+}
@@ -0,0 +1,67 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	health_test.go
+	Abstract:	Tests the per-host counters and Stats() using connections placed directly
+				in the pool.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStats( t *testing.T ) {
+	b := &Broker{ conns: make( map[string]*connection ) }
+	now := time.Now()
+	b.conns["zebra:22"] = &connection{ host: "zebra:22", active: true, since: now.Add( -time.Minute ), last_cmd: now.Unix() }
+	b.conns["apple:22"] = &connection{ host: "apple:22" }
+
+	req := &Broker_msg{ host: "zebra" }
+	b.get_conn( req.host ).count( req, false )
+
+	req.err = fmt.Errorf( "ssh: rejected: administratively prohibited" )
+	b.get_conn( req.host ).count( req, true )
+	req.err = fmt.Errorf( "Process exited with status 2" )
+	b.get_conn( req.host ).count( req, false )
+
+	b.get_conn( "zebra" ).busy( 1 )
+	b.get_conn( "nosuch" ).count( req, false )				// must not panic
+
+	hs := b.Stats()
+	if len( hs ) != 2 || hs[0].Host != "apple:22" || hs[1].Host != "zebra:22" {
+		t.Fatalf( "unexpected stats list: %v", hs )
+	}
+
+	z := hs[1]
+	if z.Commands != 2 || z.Retries != 1 || z.Failures != 1 || z.In_use != 1 || !z.Active {
+		t.Errorf( "unexpected counts: %s", z )
+	}
+	if z.Age < time.Minute || z.Last_err != "Process exited with status 2" {
+		t.Errorf( "unexpected age or last error: %s", z )
+	}
+	if hs[0].Active || hs[0].Age != 0 {
+		t.Errorf( "inactive host reported as up: %s", hs[0] )
+	}
+}
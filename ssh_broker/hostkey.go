@@ -0,0 +1,304 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	hostkey.go
+	Abstract: 	Host key verification.  The broker historically accepted any host key; this
+				module allows the user to supply a policy which verifies the key presented
+				by the remote host against one or more known_hosts files (strict), records
+				keys for hosts not yet known (trust on first use), and/or compares the key
+				with a pinned fingerprint.  A failed verification results in an Hk_error
+				being returned as the request's error.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+/*
+	Host key verification modes.
+*/
+const (
+	HK_ACCEPT_ANY	int = iota		// accept any key (the original behaviour)
+	HK_STRICT						// the host must be listed in a known_hosts file with a matching key
+	HK_TOFU							// unknown hosts are accepted and their key recorded; known hosts must match
+)
+
+/*
+	Hk_policy defines how the broker verifies host keys.  Known_hosts is the list of
+	files (OpenSSH format) that are searched. In TOFU mode new keys are appended to
+	Tofu_file, or to the first known_hosts file if Tofu_file is empty; the file is created
+	if it does not exist.
+
+	Pinned maps a host (name or name:port) to a SHA256 fingerprint as printed by
+	ssh-keygen -l (e.g. SHA256:nThbg6k...). A host with a pinned fingerprint is verified
+	only against that fingerprint regardless of mode.
+*/
+type Hk_policy struct {
+	Mode		int
+	Known_hosts	[]string
+	Tofu_file	string
+	Pinned		map[string]string
+}
+
+/*
+	Hk_error is returned (as the request error) when the remote host's key cannot
+	be verified.  Want is the list of fingerprints expected and is empty if the host
+	is unknown.
+*/
+type Hk_error struct {
+	Host	string				// host:port as dialed
+	Got		string				// fingerprint of the key offered by the host
+	Want	[]string			// fingerprint(s) we expected
+	Unknown	bool				// host was not in any known_hosts file (strict mode)
+	Revoked	bool				// key is marked @revoked in a known_hosts file
+}
+
+func ( e *Hk_error ) Error( ) ( string ) {
+	switch {
+		case e.Revoked:
+			return fmt.Sprintf( "host key for %s has been revoked: %s", e.Host, e.Got )
+
+		case e.Unknown:
+			return fmt.Sprintf( "host key for %s is not known: %s", e.Host, e.Got )
+	}
+
+	return fmt.Sprintf( "HOST KEY MISMATCH for %s: got %s, expected %s", e.Host, e.Got, strings.Join( e.Want, " or " ) )
+}
+
+/*
+	Applies a policy. The known hosts callback is rebuilt each time a key is recorded
+	so the lock is needed to prevent using it while it's being replaced.
+*/
+type hk_checker struct {
+	mode		int
+	files		[]string
+	tofu_file	string
+	pinned		map[string]string
+	kh_cb		ssh.HostKeyCallback		// nil if there are no files to check
+	lock		sync.Mutex
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Create a checker for the policy. In strict mode all files must exist; in TOFU mode
+	the record file is created if needed and missing files are ignored.
+*/
+func mk_hk_checker( p *Hk_policy ) ( hk *hk_checker, err error ) {
+	if p == nil {
+		return nil, nil
+	}
+
+	hk = &hk_checker{
+		mode: p.Mode,
+		pinned: make( map[string]string, len( p.Pinned ) ),
+	}
+
+	for h, fp := range p.Pinned {
+		if ! strings.HasPrefix( fp, "SHA256:" ) {
+			fp = "SHA256:" + fp
+		}
+		hk.pinned[h] = fp
+	}
+
+	switch p.Mode {
+		case HK_ACCEPT_ANY:
+
+		case HK_STRICT:
+			if len( p.Known_hosts ) == 0 && len( p.Pinned ) == 0 {
+				return nil, fmt.Errorf( "strict host key checking requires known_hosts files or pinned keys" )
+			}
+			hk.files = p.Known_hosts
+
+		case HK_TOFU:
+			hk.tofu_file = p.Tofu_file
+			if hk.tofu_file == "" {
+				if len( p.Known_hosts ) == 0 {
+					return nil, fmt.Errorf( "trust on first use requires a file to record keys in" )
+				}
+				hk.tofu_file = p.Known_hosts[0]
+			}
+
+			f, err := os.OpenFile( hk.tofu_file, os.O_CREATE | os.O_RDONLY, 0600 )		// ensure it exists
+			if err != nil {
+				return nil, err
+			}
+			f.Close()
+
+			for _, fname := range p.Known_hosts {
+				if _, err := os.Stat( fname ); err == nil && fname != hk.tofu_file {
+					hk.files = append( hk.files, fname )
+				}
+			}
+			hk.files = append( hk.files, hk.tofu_file )
+
+		default:
+			return nil, fmt.Errorf( "unknown host key mode: %d", p.Mode )
+	}
+
+	err = hk.load( )
+	return
+}
+
+/*
+	(Re)build the known hosts callback from the files.
+*/
+func ( hk *hk_checker ) load( ) ( err error ) {
+	if len( hk.files ) == 0 {
+		return
+	}
+
+	cb, err := knownhosts.New( hk.files... )
+	if err != nil {
+		return
+	}
+
+	hk.lock.Lock()
+	hk.kh_cb = cb
+	hk.lock.Unlock()
+
+	return
+}
+
+/*
+	The host key callback given to ssh when a connection is established.
+*/
+func ( hk *hk_checker ) check( host string, remote net.Addr, key ssh.PublicKey ) ( error ) {
+	got := ssh.FingerprintSHA256( key )
+
+	pin, ok := hk.pinned[host]
+	if ! ok {
+		if name, _, err := net.SplitHostPort( host ); err == nil {
+			pin, ok = hk.pinned[name]
+		}
+	}
+	if ok {
+		if pin != got {
+			return &Hk_error{ Host: host, Got: got, Want: []string{ pin } }
+		}
+		return nil
+	}
+
+	if hk.mode == HK_ACCEPT_ANY {
+		return nil
+	}
+
+	hk.lock.Lock()
+	cb := hk.kh_cb
+	hk.lock.Unlock()
+
+	var err error = &knownhosts.KeyError{ }			// no files is the same as not known
+	if cb != nil {
+		err = cb( host, remote, key )
+	}
+
+	if kerr, ok := err.( *knownhosts.KeyError ); ok && len( kerr.Want ) == 0 && hk.mode == HK_TOFU {
+		hk.lock.Lock()								// serialise writers so a host isn't recorded twice
+		defer hk.lock.Unlock()
+
+		err = &knownhosts.KeyError{ }
+		if hk.kh_cb != nil {
+			err = hk.kh_cb( host, remote, key )		// another caller might have recorded a key since
+		}
+		if kerr, ok := err.( *knownhosts.KeyError ); ok && len( kerr.Want ) == 0 {
+			return hk.record( host, key )
+		}
+	}
+
+	return mk_hk_error( host, got, err )
+}
+
+/*
+	Convert the error returned by the known hosts callback into an Hk_error.
+	Nil is returned if the key was accepted, and errors which aren't key
+	errors are returned unchanged.
+*/
+func mk_hk_error( host string, got string, err error ) ( error ) {
+	switch kerr := err.( type ) {
+		case nil:
+			return nil
+
+		case *knownhosts.KeyError:
+			if len( kerr.Want ) > 0 {
+				want := make( []string, len( kerr.Want ) )
+				for i := range kerr.Want {
+					want[i] = fmt.Sprintf( "%s (%s:%d)", ssh.FingerprintSHA256( kerr.Want[i].Key ), kerr.Want[i].Filename, kerr.Want[i].Line )
+				}
+				return &Hk_error{ Host: host, Got: got, Want: want }
+			}
+
+			return &Hk_error{ Host: host, Got: got, Unknown: true }
+
+		case *knownhosts.RevokedError:
+			return &Hk_error{ Host: host, Got: got, Revoked: true }
+	}
+
+	return err
+}
+
+/*
+	Append the host and key to the record file and rebuild the callback. The caller
+	must hold the lock (load() cannot be used as it takes the lock).
+*/
+func ( hk *hk_checker ) record( host string, key ssh.PublicKey ) ( err error ) {
+	f, err := os.OpenFile( hk.tofu_file, os.O_APPEND | os.O_WRONLY, 0600 )
+	if err != nil {
+		return
+	}
+
+	_, err = fmt.Fprintf( f, "%s\n", knownhosts.Line( []string{ knownhosts.Normalize( host ) }, key ) )
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	cb, err := knownhosts.New( hk.files... )
+	if err == nil {
+		hk.kh_cb = cb
+	}
+
+	return
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Get_hk_error returns the host key error if the request failed because the remote
+	host's key could not be verified, nil otherwise.
+*/
+func ( m *Broker_msg ) Get_hk_error( ) ( *Hk_error ) {
+	if m == nil {
+		return nil
+	}
+
+	hke, _ := m.err.( *Hk_error )
+	return hke
+}
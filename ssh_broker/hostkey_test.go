@@ -0,0 +1,159 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	hostkey_test.go
+	Abstract:	Tests the host key policies without needing a remote host.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func mk_test_key( t *testing.T ) ( ssh.PublicKey ) {
+	pub, _, err := ed25519.GenerateKey( rand.Reader )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	key, err := ssh.NewPublicKey( pub )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	return key
+}
+
+func TestTofu( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "hostkey" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	kh := filepath.Join( dir, "known_hosts" )
+	addr := &net.TCPAddr{ IP: net.ParseIP( "127.0.0.1" ), Port: 22 }
+	key1 := mk_test_key( t )
+	key2 := mk_test_key( t )
+
+	hk, err := mk_hk_checker( &Hk_policy{ Mode: HK_TOFU, Known_hosts: []string{ kh } } )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	if err = hk.check( "cheetah:22", addr, key1 ); err != nil {
+		t.Fatalf( "first use was not accepted: %s", err )
+	}
+	if err = hk.check( "cheetah:22", addr, key1 ); err != nil {
+		t.Fatalf( "recorded key was not accepted: %s", err )
+	}
+
+	err = hk.check( "cheetah:22", addr, key2 )
+	if hke, ok := err.( *Hk_error ); !ok || hke.Unknown || len( hke.Want ) != 1 {
+		t.Fatalf( "expected mismatch error, got: %v", err )
+	}
+
+	strict, err := mk_hk_checker( &Hk_policy{ Mode: HK_STRICT, Known_hosts: []string{ kh } } )		// strict sees what tofu wrote
+	if err != nil {
+		t.Fatal( err )
+	}
+	if err = strict.check( "cheetah:22", addr, key1 ); err != nil {
+		t.Errorf( "strict rejected recorded key: %s", err )
+	}
+	if hke, ok := strict.check( "lion:22", addr, key1 ).( *Hk_error ); !ok || !hke.Unknown {
+		t.Errorf( "strict accepted unknown host" )
+	}
+}
+
+func TestPinned( t *testing.T ) {
+	addr := &net.TCPAddr{ IP: net.ParseIP( "127.0.0.1" ), Port: 22 }
+	key1 := mk_test_key( t )
+	key2 := mk_test_key( t )
+
+	hk, err := mk_hk_checker( &Hk_policy{ Mode: HK_ACCEPT_ANY, Pinned: map[string]string{ "cheetah": ssh.FingerprintSHA256( key1 ) } } )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	if err = hk.check( "cheetah:22", addr, key1 ); err != nil {
+		t.Errorf( "pinned key not accepted: %s", err )
+	}
+	if _, ok := hk.check( "cheetah:22", addr, key2 ).( *Hk_error ); !ok {
+		t.Errorf( "pinned mismatch was not detected" )
+	}
+	if err = hk.check( "lion:22", addr, key2 ); err != nil {
+		t.Errorf( "unpinned host should be accepted in accept-any mode: %s", err )
+	}
+}
+
+func TestTofu_concurrent( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "hostkey" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	kh := filepath.Join( dir, "known_hosts" )
+	addr := &net.TCPAddr{ IP: net.ParseIP( "127.0.0.1" ), Port: 22 }
+	keys := []ssh.PublicKey{ mk_test_key( t ), mk_test_key( t ) }
+
+	hk, err := mk_hk_checker( &Hk_policy{ Mode: HK_TOFU, Known_hosts: []string{ kh } } )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	accepted := make( []int32, 2 )
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add( 1 )
+		go func( k int ) {
+			defer wg.Done()
+			if hk.check( "cheetah:22", addr, keys[k] ) == nil {
+				atomic.AddInt32( &accepted[k], 1 )
+			}
+		}( i % 2 )
+	}
+	wg.Wait()
+
+	if accepted[0] > 0 && accepted[1] > 0 {
+		t.Errorf( "both keys were accepted for the host: %v", accepted )
+	}
+
+	data, err := ioutil.ReadFile( kh )
+	if err != nil {
+		t.Fatal( err )
+	}
+	if n := strings.Count( string( data ), "\n" ); n != 1 {
+		t.Errorf( "expected one recorded key, found %d:\n%s", n, data )
+	}
+}
@@ -0,0 +1,246 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	interactive.go
+	Abstract: 	Pseudo terminal and environment support for sessions, and interactive
+				sessions.  A pty is needed by commands such as sudo when requiretty is set;
+				note that with a pty the remote side merges standard error into standard
+				output.  Environment variables are sent with env requests which sshd
+				refuses unless they are listed in its AcceptEnv setting; refused
+				variables are ignored.
+
+				An interactive session is started directly on the pooled connection (it
+				does not pass through the initiator queue and is never retried) and the
+				caller drives it using the pipes in the handle.  It is audited when Wait()
+				returns.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	Pseudo terminal settings.  Term defaults to xterm and the size to 24x80.  Modes are
+	the terminal modes (see ssh.TerminalModes) and default to echo off.
+*/
+type Pty_opts struct {
+	Term	string
+	Rows	int
+	Cols	int
+	Modes	ssh.TerminalModes
+}
+
+/*
+	Handle for an interactive session.  Stdout and Stderr must be read (or the remote side
+	will eventually block) and Stdin closed when there is no more input.
+*/
+type Interactive struct {
+	Stdin	io.WriteCloser
+	Stdout	io.Reader
+	Stderr	io.Reader
+
+	b		*Broker
+	sess	*ssh.Session
+	req		*Broker_msg				// for the audit record
+	c		*connection
+	once	sync.Once				// result is recorded once (Wait or Close)
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Counts the bytes read through it.
+*/
+type count_reader struct {
+	r		io.Reader
+	n		*int64
+}
+
+func ( cr *count_reader ) Read( buf []byte ) ( n int, err error ) {
+	n, err = cr.r.Read( buf )
+	atomic.AddInt64( cr.n, int64( n ) )
+	return
+}
+
+/*
+	Request the pty and set the environment variables, if given, on the session.
+*/
+func ( req *Broker_msg ) setup_session( sess *ssh.Session ) ( err error ) {
+	for k, v := range req.envars {
+		sess.Setenv( k, v )						// like ssh, a variable the server refuses is silently dropped
+	}
+
+	if req.pty != nil {
+		p := req.pty
+		term := p.Term
+		if term == "" {
+			term = "xterm"
+		}
+		rows := p.Rows
+		if rows <= 0 {
+			rows = 24
+		}
+		cols := p.Cols
+		if cols <= 0 {
+			cols = 80
+		}
+		modes := p.Modes
+		if modes == nil {
+			modes = ssh.TerminalModes{ ssh.ECHO: 0 }
+		}
+
+		if err = sess.RequestPty( term, rows, cols, modes ); err != nil {
+			req.fail = FAIL_SETUP
+			return fmt.Errorf( "unable to get a pty on %s: %s", req.host, err )
+		}
+	}
+
+	return
+}
+
+/*
+	Set up the session and its pipes, then start the command or shell.
+*/
+func ( it *Interactive ) start( cmd string ) ( err error ) {
+	sess := it.sess
+	if err = it.req.setup_session( sess ); err != nil {
+		return
+	}
+
+	if it.Stdin, err = sess.StdinPipe(); err != nil {
+		return
+	}
+	so, err := sess.StdoutPipe()
+	if err != nil {
+		return
+	}
+	se, err := sess.StderrPipe()
+	if err != nil {
+		return
+	}
+	it.Stdout = &count_reader{ r: so, n: &it.req.nout }
+	it.Stderr = &count_reader{ r: se, n: &it.req.nerr }
+
+	if cmd == "" {
+		return sess.Shell()
+	}
+	return sess.Start( cmd )
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Interactive starts the command (or the user's login shell if cmd is empty) on the host
+	and returns a handle with pipes for its standard input, output and error. Only the Pty
+	and Env run options are used.
+*/
+func ( b *Broker ) Interactive( host string, cmd string, opts *Run_opts ) ( it *Interactive, err error ) {
+	if b == nil || b.was_closed {
+		return nil, fmt.Errorf( "interactive: broker pointer was nil, or broker has been closed" )
+	}
+
+	req := &Broker_msg{ host: host, cmd: cmd, began: time.Now() }
+	if cmd == "" {
+		req.cmd = "<shell>"
+	}
+	req.apply( opts )
+
+	sess, c, err := b.session2( host )
+	if err != nil {
+		return
+	}
+
+	it = &Interactive{ b: b, sess: sess, req: req, c: c }		// counted as a user of c until finished
+	if err = it.start( cmd ); err != nil {
+		sess.Close()
+		c.busy( -1 )
+		return nil, err
+	}
+
+	return
+}
+
+/*
+	Wait waits for the command to complete and returns the same error as Run_cmd would:
+	nil if it exited with 0, and an error with the exit status (see Get_exit_status()
+	on the returned message) otherwise.
+*/
+func ( it *Interactive ) Wait( ) ( msg *Broker_msg, err error ) {
+	if it == nil {
+		return nil, fmt.Errorf( "interactive: nil handle" )
+	}
+
+	err = it.sess.Wait()
+	it.finish( err )
+
+	return it.req, err
+}
+
+/*
+	Resize tells the remote pty that the terminal size has changed.
+*/
+func ( it *Interactive ) Resize( rows int, cols int ) ( error ) {
+	return it.sess.WindowChange( rows, cols )
+}
+
+/*
+	Signal sends the signal to the remote command (not all servers honour signals).
+*/
+func ( it *Interactive ) Signal( sig ssh.Signal ) ( error ) {
+	return it.sess.Signal( sig )
+}
+
+/*
+	Close ends the session; the remote command is likely to be killed if it is still running.
+*/
+func ( it *Interactive ) Close( ) ( err error ) {
+	if it == nil {
+		return
+	}
+
+	err = it.sess.Close()
+	it.finish( fmt.Errorf( "closed before the command completed" ) )
+	return
+}
+
+/*
+	Record the result once (Wait or Close, whichever is first).
+*/
+func ( it *Interactive ) finish( err error ) {
+	it.once.Do( func() {
+		it.c.busy( -1 )
+
+		it.req.err = err
+		it.req.classify( err )
+		it.req.startt = it.req.began.Unix()
+		it.req.endt = time.Now().Unix()
+		it.b.audit_req( it.req )
+	} )
+}
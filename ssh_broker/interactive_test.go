@@ -0,0 +1,97 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	interactive_test.go
+	Abstract:	Tests stdin, pty and environment options, and an interactive shell.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/att/gopkgs/ssh_broker/sshtest"
+)
+
+func TestRun_opts_session( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	msg, err := b.Run_cmd_opts( srv.Addr(), "tr a-z A-Z", &Run_opts{ Stdin: strings.NewReader( "from stdin\n" ) } )
+	if stdout, _, _, _ := msg.Get_results(); err != nil || stdout.String() != "FROM STDIN\n" {
+		t.Errorf( "stdin: %q %v", stdout.String(), err )
+	}
+
+	opts := &Run_opts{ Pty: &Pty_opts{ Term: "vt100" }, Env: map[string]string{ "SB_TEST": "yes" } }
+	msg, err = b.Run_cmd_opts( srv.Addr(), "echo $TERM $SB_TEST; echo err >&2", opts )
+	if stdout, _, _, _ := msg.Get_results(); err != nil || stdout.String() != "vt100 yes\nerr\n" {
+		t.Errorf( "pty/env: %q %v", stdout.String(), err )
+	}
+
+	dir := t.TempDir()												// scripts sent on stdin get the environment
+	sname := filepath.Join( dir, "env.sh" )
+	ioutil.WriteFile( sname, []byte( "#!/bin/sh\necho \"$SB_TEST\"\n" ), 0755 )
+	msg, err = b.Run_on_host_opts( srv.Addr(), sname, "", "", &Run_opts{ Env: map[string]string{ "SB_TEST": "script" } } )
+	if stdout, _, _, _ := msg.Get_results(); err != nil || stdout.String() != "script\n" {
+		t.Errorf( "script env: %q %v", stdout.String(), err )
+	}
+
+	msg, err = b.Run_on_host_opts( srv.Addr(), sname, "", "", &Run_opts{ Pty: &Pty_opts{ } } )
+	if err == nil || msg.Get_fail_class() != FAIL_SETUP {
+		t.Errorf( "expected a setup failure asking for a pty with a stdin script, got %v", err )
+	}
+
+	opts.Verbatim = true											// verbatim scripts can have both
+	msg, err = b.Run_on_host_opts( srv.Addr(), sname, "", "", opts )
+	if stdout, _, _, _ := msg.Get_results(); err != nil || strings.TrimSpace( stdout.String() ) != "yes" {
+		t.Errorf( "verbatim pty/env: %q %v", stdout.String(), err )
+	}
+}
+
+func TestInteractive( t *testing.T ) {
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+	defer done()
+
+	it, err := b.Interactive( srv.Addr(), "", nil )
+	if err != nil {
+		t.Fatalf( "interactive: %s", err )
+	}
+
+	go func() {
+		io.WriteString( it.Stdin, "echo hi\necho oops >&2\nexit 3\n" )
+		it.Stdin.Close()
+	}()
+	go io.Copy( ioutil.Discard, it.Stderr )
+	out, _ := ioutil.ReadAll( it.Stdout )
+
+	msg, err := it.Wait()
+	if string( out ) != "hi\n" || err == nil {
+		t.Errorf( "unexpected result: %q %v", out, err )
+	}
+	if code, _ := msg.Get_exit_status(); code != 3 {
+		t.Errorf( "expected exit 3, got %d", code )
+	}
+	it.Close()
+}
@@ -0,0 +1,241 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	jump.go
+	Abstract: 	Jump host (bastion) support.  A list of jump hosts can be set for the broker
+				as a whole, or for an individual host, and connections to the host are then
+				tunnelled through the jump hosts in order (the same as ssh -J). The
+				connection to each jump host is an ordinary pooled connection, so it is
+				shared by all hosts reached through it and is reestablished when needed.
+
+				Jump hosts are given as [user@]host[:port]; if the user is omitted the
+				broker's user is used.  Opening the tunnel through a jump host, and the
+				ssh handshake over it, are limited to the ConnectTimeout from the ssh
+				config (30s if not set).
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	Max time allowed to open the tunnel through a jump host, and for the ssh handshake over
+	it, when the host's config (ConnectTimeout) doesn't give one.
+*/
+const jump_timeout = 30 * time.Second
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Add the default port to the host if it isn't there.
+*/
+func add_port( host string ) ( string ) {
+	if strings.Index( host, ":" ) < 0  {
+		return host + ":22"
+	}
+
+	return host
+}
+
+/*
+	Split [user@]host:port into the user (empty if not there) and host:port.
+*/
+func split_user( host string ) ( user string, hp string ) {
+	if ui := strings.LastIndex( host, "@" ); ui >= 0 {
+		return host[:ui], host[ui+1:]
+	}
+
+	return "", host
+}
+
+/*
+	Return the jump host list to use for the host (host:port).  Jump hosts given to the
+	broker take precedence over a ProxyJump from the ssh config: a host specific list is
+	used first, then the broker default, and the ProxyJump only when neither is set. An
+	empty host list (or ProxyJump none) means connect directly.
+*/
+func ( b *Broker ) jump_chain( host string ) ( chain []string ) {
+	b.cfg_lock.RLock()
+	defer b.cfg_lock.RUnlock()
+
+	if chain, ok := b.jumps[host]; ok {
+		return chain
+	}
+
+	user, hp := split_user( host )
+	name, _, err := net.SplitHostPort( hp )
+	if err == nil {
+		if chain, ok := b.jumps[name]; ok {
+			return chain
+		}
+	}
+
+	if len( b.def_jumps ) > 0 {
+		return b.def_jumps
+	}
+
+	if b.ssh_cfg != nil && err == nil {
+		if user == "" {
+			user = b.config.User
+		}
+		if pj := b.ssh_cfg.Lookup( name, user ).Proxy_jump; len( pj ) > 0 {
+			if len( pj ) == 1 && strings.ToLower( pj[0] ) == "none" {
+				return nil
+			}
+			return pj
+		}
+	}
+
+	return nil
+}
+
+/*
+	Return the client configuration to use for the user; the broker's config is
+	returned if user is empty or matches.
+*/
+func ( b *Broker ) user_config( user string ) ( *ssh.ClientConfig ) {
+	if user == "" || user == b.config.User {
+		return b.config
+	}
+
+	cfg := *b.config
+	cfg.User = user
+	return &cfg
+}
+
+/*
+	Establish the ssh connection to host ([user@]host:port) either directly or through
+	the jump hosts in chain. The connection to the last jump host is found (or created)
+	in the pool using the remainder of the chain to reach it.  Settings from the ssh
+	config are applied; the keepalive interval it gives (0 if none) is returned.
+*/
+func ( b *Broker ) dial( host string, chain []string ) ( client *ssh.Client, alive time.Duration, err error ) {
+	user, hp := split_user( host )
+	hp, cfg, alive := b.host_config( user, hp )
+
+	if len( chain ) == 0 {
+		client, err = ssh.Dial( "tcp", hp, cfg )
+		return
+	}
+
+	last := len( chain ) - 1
+	jc, err := b.connect3( b.host_port( chain[last] ), chain[:last], false )
+	if err != nil {
+		return nil, 0, fmt.Errorf( "unable to connect to jump host %s: %w", chain[last], err )
+	}
+
+	jclient := jc.tunnel()									// counted until the tunnelled client closes
+	if jclient == nil {
+		return nil, 0, fmt.Errorf( "connection to jump host %s was lost", chain[last] )
+	}
+
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = jump_timeout
+	}
+	ctx, cancel := context.WithTimeout( context.Background(), timeout )
+	conn, err := jclient.DialContext( ctx, "tcp", hp )
+	cancel()
+	if err != nil {
+		jc.untunnel()
+		var oce *ssh.OpenChannelError
+		if ! errors.As( err, &oce ) {								// no answer from the jump host, not a refusal
+			jc.drop( jclient )										// force a reconnect of the jump host on the next attempt
+		}
+		return nil, 0, fmt.Errorf( "jump host %s could not reach %s: %w", chain[last], hp, err )
+	}
+
+	hs_timer := time.AfterFunc( timeout, func() { conn.Close() } )		// the tunnel has no deadlines; limit the handshake
+	cc, chans, reqs, err := ssh.NewClientConn( conn, hp, cfg )
+	if ! hs_timer.Stop() && err == nil {
+		cc.Close()
+		err = fmt.Errorf( "ssh handshake with %s through %s timed out", hp, chain[last] )
+	}
+	if err != nil {
+		conn.Close()
+		jc.untunnel()
+		return nil, 0, err
+	}
+
+	client = ssh.NewClient( cc, chans, reqs )
+	go func() {
+		client.Wait()
+		jc.untunnel()
+	}()
+	return client, alive, nil
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Set_jump_hosts sets the list of jump hosts used to reach host. If host is empty the
+	list becomes the default for all hosts which do not have their own list. An empty
+	list for a host causes it to be reached directly even when there is a default list.
+	Host may be given as a name or name:port.  Existing connections are not affected.
+*/
+func ( b *Broker ) Set_jump_hosts( host string, jumps ...string ) {
+	if b == nil {
+		return
+	}
+
+	b.cfg_lock.Lock()
+	defer b.cfg_lock.Unlock()
+
+	if host == "" {
+		b.def_jumps = jumps
+		return
+	}
+
+	if b.jumps == nil {
+		b.jumps = make( map[string][]string )
+	}
+	b.jumps[host] = jumps
+}
+
+/*
+	Rm_jump_hosts removes the host specific jump host list. If host is empty the default
+	list is removed.
+*/
+func ( b *Broker ) Rm_jump_hosts( host string ) {
+	if b == nil {
+		return
+	}
+
+	b.cfg_lock.Lock()
+	defer b.cfg_lock.Unlock()
+
+	if host == "" {
+		b.def_jumps = nil
+		return
+	}
+
+	delete( b.jumps, host )
+}
@@ -0,0 +1,136 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	jump_test.go
+	Abstract:	Tests reaching a host through a chain of two jump hosts, each an
+				in-process server, and that a jump host isn't closed as idle while
+				a connection is tunnelled through it.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/att/gopkgs/ssh_broker/sshtest"
+)
+
+func TestJump_chain( t *testing.T ) {
+	opts := &sshtest.Server_opts{ Handler: sshtest.Script_handler( map[string]*sshtest.Reply{ "hostname": { Stdout: "target\n" } } ) }
+	b, target, done := mk_test_broker( t, opts )
+	defer done()
+
+	jumps := make( []*sshtest.Server, 2 )
+	for i := range jumps {
+		j, err := sshtest.Mk_server( &sshtest.Server_opts{ Authorized: opts.Authorized } )		// same user and key as the target
+		if err != nil {
+			t.Fatal( err )
+		}
+		defer j.Close()
+		jumps[i] = j
+	}
+
+	b.Set_jump_hosts( target.Addr(), jumps[0].Addr(), jumps[1].Addr() )
+	stdout, _, err := b.Run_cmd( target.Addr(), "hostname" )
+	if err != nil || stdout.String() != "target\n" {
+		t.Fatalf( "run_cmd through jump hosts: %q %v", stdout, err )
+	}
+	if _, _, err = b.Run_cmd( target.Addr(), "hostname" ); err != nil {
+		t.Fatalf( "second run_cmd: %s", err )
+	}
+
+	for i, s := range append( jumps, target ) {
+		if n := s.Nconns(); n != 1 {
+			t.Errorf( "server %d: expected one (pooled) connection, got %d", i, n )
+		}
+	}
+	if c := b.get_conn( target.Addr() ); c == nil || len( c.chain ) != 2 {
+		t.Errorf( "target connection missing, or has the wrong chain" )
+	}
+
+	l, err := net.Listen( "tcp", "127.0.0.1:0" )				// an address nothing listens on
+	if err != nil {
+		t.Fatal( err )
+	}
+	dead := l.Addr().String()
+	l.Close()
+
+	b.Set_jump_hosts( dead, jumps[0].Addr(), jumps[1].Addr() )
+	if _, _, err = b.Run_cmd( dead, "hostname" ); err == nil {
+		t.Fatalf( "expected error reaching a dead host through the jump hosts" )
+	}
+	if c := b.get_conn( jumps[1].Addr() ); c == nil || !c.is_active() {
+		t.Errorf( "jump host connection dropped because it refused to reach the dead host" )
+	}
+	if _, _, err = b.Run_cmd( target.Addr(), "hostname" ); err != nil || jumps[1].Nconns() != 1 {
+		t.Errorf( "target not reached over the same jump connection after the failure: %v (%d connections)", err, jumps[1].Nconns() )
+	}
+}
+
+/*
+	A jump host must not be closed as idle while a connection tunnelled through it is live,
+	even when its pool entry was first made to run commands on it.
+*/
+func TestJump_idle( t *testing.T ) {
+	opts := &sshtest.Server_opts{ Handler: sshtest.Script_handler( map[string]*sshtest.Reply{ "hostname": { Stdout: "host\n" } } ) }
+	b, target, done := mk_test_broker( t, opts )
+	defer done()
+
+	j, err := sshtest.Mk_server( &sshtest.Server_opts{ Authorized: opts.Authorized, Handler: opts.Handler } )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer j.Close()
+
+	b.Set_keepalive( 0, 500 * time.Millisecond )
+	if _, _, err = b.Run_cmd( j.Addr(), "hostname" ); err != nil {			// pool entry made as a command target
+		t.Fatalf( "run_cmd on the jump host: %s", err )
+	}
+	b.Set_jump_hosts( target.Addr(), j.Addr() )
+	if _, _, err = b.Run_cmd( target.Addr(), "hostname" ); err != nil {
+		t.Fatalf( "run_cmd through the jump host: %s", err )
+	}
+
+	tc := b.get_conn( target.Addr() )
+	jc := b.get_conn( j.Addr() )
+	tc.busy( 1 )												// the downstream connection stays in use
+	time.Sleep( 2 * time.Second )
+	if ! jc.is_active() || j.Nconns() != 1 {
+		t.Fatalf( "jump host closed as idle with a live connection through it" )
+	}
+	if n := atomic.LoadInt32( &jc.tunnels ); n != 1 {
+		t.Errorf( "expected one tunnel through the jump host, got %d", n )
+	}
+
+	tc.busy( -1 )												// both should now be closed as idle
+	for i := 0; i < 50 && (tc.is_active() || jc.is_active()); i++ {
+		time.Sleep( 100 * time.Millisecond )
+	}
+	if tc.is_active() || jc.is_active() {
+		t.Errorf( "idle connections not closed: target=%v jump=%v", tc.is_active(), jc.is_active() )
+	}
+	if n := atomic.LoadInt32( &jc.tunnels ); n != 0 {
+		t.Errorf( "tunnel count not released when the target closed: %d", n )
+	}
+}
@@ -19,15 +19,20 @@
 
 /*
 	Mnemonic:	rsync.go
-	Abstract: 	This module contains the support to allow an rsync command to be run on the
-				localhost for each new remote host connection. The rsync connections are
-				run "outside" of the ssh environment that is managed by this package.
+	Abstract: 	This module contains the support to allow a set of files to be synchronised
+				to each remote host when a new connection is established.  Originally an
+				rsync command was run on the local host "outside" of the ssh environment
+				managed by this package; the files are now copied using sftp over the new
+				connection (see sftp.go).
 
 	Author:		E. Scott Daniels
 	Date: 		20 January 2015
 
 	Mods:		13 Apr 2015 - Added explicit ssh command for rsync to use.
 				07 Jun 2018 - fix printf %s/v bug.
+				19 Oct 2026 - Rsync's ssh now honours the broker's host key policy.
+				19 Oct 2026 - Rsync's ssh uses the host's jump hosts.
+				19 Oct 2026 - Replaced the external rsync command with sftp over the pooled connection.
 
 	CAUTION:	This package reqires go 1.3.3 or later.
 */
@@ -37,16 +42,15 @@ package ssh_broker
 import (
     "fmt"
 	"os"
-
-	"github.com/att/gopkgs/extcmd"
+	"strings"
 )
 
 /*
-	Add_rsync accepts the setup for rsync commands.  We assume src is one or more
-	source files and dest_dir is the name of the directory on the remote
-	host.  When the rsync command is actually run, the two strings are
-	combined (unchanged) with user@host: inserted just before the dest
-	directory.
+	Add_rsync accepts the setup for the files synchronised on connect.  We assume src
+	is one or more (space separated) source files or directories and dest_dir is the
+	name of the directory on the remote host.  Files which are unchanged on the remote
+	host are not copied. As with rsync, a source directory with a trailing slant has its
+	contents, rather than itself, copied.
 */
 func ( b *Broker ) Add_rsync( src *string, dest_dir *string ) {
 	if b != nil {
@@ -67,44 +71,35 @@ func ( b *Broker ) Rm_rsync( ) {
 // ---- private functions -----------------------------------------------------------------
 
 /*
-	Actually run the rsync command.  If verbose is true, then output is written
-	to stderr, otherwise it is ignored. Error is returned and will be nil if
-	the command successfully executed.
+	Synchronise the files to the host on the connection.  If verbose is true, then a
+	summary is written to stderr. Error is returned and will be nil if all files were
+	successfully copied (or were already there).
 
-	The -e option is used to force rsync to use ssh with a nokey checking option
-	so that we don't get caught by the reinstall of a machine which changes its
-	key and would possibly block our rsync attempt.
+	The caller holds the connection's host lock, so the connection is used directly
+	rather than looking it up.
 */
-func ( b *Broker ) synch_host( host *string ) ( err error ) {
+func ( b *Broker ) synch_host( c *connection ) ( err error ) {
 
-	if b == nil || b.rsync_src == nil || b.rsync_dir == nil  || host == nil {
+	if b == nil || b.rsync_src == nil || b.rsync_dir == nil  || c == nil {
 		if b.verbose {
-			fmt.Fprintf( os.Stderr, "ssh_broker: synch_host giving up b is nil: %v; src is nil %v dir is nil %v; host is nil %v\n", b == nil, b.rsync_src == nil, b.rsync_dir == nil , host == nil )
+			fmt.Fprintf( os.Stderr, "ssh_broker: synch_host giving up b is nil: %v; src is nil %v dir is nil %v; conn is nil %v\n", b == nil, b.rsync_src == nil, b.rsync_dir == nil , c == nil )
 		}
 		return
 	}
 
-	cmd := fmt.Sprintf( `rsync -e "ssh -o StrictHostKeyChecking=no" %s %s@%s:%s`, *b.rsync_src, b.config.User, *host, *b.rsync_dir )
-	
-	verbose := b.verbose
-	if verbose {
-		fmt.Fprintf( os.Stderr, "synch_host: %s\n", cmd )	
+	sc, err := c.sftp_client()
+	if err != nil {
+		return
 	}
 
-	stdout, stderr, err := extcmd.Cmd2strings( cmd )
+	st, err := sync_tree( sc, strings.Fields( *b.rsync_src ), *b.rsync_dir )
 	if err != nil {
-		fmt.Fprintf( os.Stderr, "ssh-broker: unable to rsync for host %s: %s\n", *host, err )
-		fmt.Fprintf( os.Stderr, "ssh-broker: command: %s\n", cmd )
-		verbose = true
+		fmt.Fprintf( os.Stderr, "ssh-broker: unable to sync files to host %s: %s\n", c.host, err )
+		return
 	}
-	if verbose {
-		for i := range stdout {
-			fmt.Fprintf( os.Stderr, "%s\n", stdout[i] )
-		}
 
-		for i := range stderr {
-			fmt.Fprintf( os.Stderr, "%s\n", stderr[i] )
-		}
+	if b.verbose {
+		fmt.Fprintf( os.Stderr, "synch_host: %s: %d copied (%d bytes), %d unchanged\n", c.host, st.Copied, st.Bytes, st.Skipped )
 	}
 
 	return
@@ -0,0 +1,361 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	sftp.go
+	Abstract: 	File transfer using SFTP over the pooled connection to a host.  Put and Get
+				copy a file or directory tree (recursively) and Sync copies local files and
+				directories to a remote directory skipping files which are unchanged.  File
+				modes and modification times are preserved.
+
+				As with rsync, a remote file with the same size and modification time as
+				the local file is assumed to be unchanged.  Only when the sizes match and
+				the times don't is the remote content read to compare its sha256; if the
+				content is the same the remote time is updated so that the next sync
+				doesn't need to read it again.
+
+				The sftp client is created on first use and is shared by all users of the
+				connection; it is discarded when the connection is reestablished.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+/*
+	Counts returned by Sync.
+*/
+type Sync_stats struct {
+	Copied	int					// files copied
+	Skipped	int					// files which were unchanged
+	Bytes	int64				// bytes copied
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Return the sftp client for the connection, creating it if needed.
+*/
+func ( c *connection ) sftp_client( ) ( sc *sftp.Client, err error ) {
+	c.sftp_lock.Lock()
+	defer c.sftp_lock.Unlock()
+
+	if c.sftpc == nil {
+		c.stat_lock.Lock()
+		client := c.schan										// not c.client(); used by sync before the connection is marked up
+		c.stat_lock.Unlock()
+
+		c.sftpc, err = sftp.NewClient( client )
+		if err != nil {
+			c.sftpc = nil
+			return nil, fmt.Errorf( "unable to start sftp on %s: %s", c.host, err )
+		}
+	}
+
+	return c.sftpc, nil
+}
+
+/*
+	Drop the sftp client (the connection is being replaced or closed).
+*/
+func ( c *connection ) sftp_close( ) {
+	c.sftp_lock.Lock()
+	if c.sftpc != nil {
+		c.sftpc.Close()
+		c.sftpc = nil
+	}
+	c.sftp_lock.Unlock()
+}
+
+/*
+	Get the sftp client for the host, connecting if needed.
+*/
+func ( b *Broker ) host_sftp( host string ) ( sc *sftp.Client, err error ) {
+	c, err := b.connect2( host )
+	if err != nil {
+		return
+	}
+	atomic.StoreInt64( &c.last_cmd, time.Now().Unix() )	// a transfer counts as use for idle eviction
+
+	return c.sftp_client()
+}
+
+/*
+	Compute the sha256 of the content read from r.
+*/
+func sum_reader( r io.Reader ) ( []byte, error ) {
+	h := sha256.New()
+	if _, err := io.Copy( h, r ); err != nil {
+		return nil, err
+	}
+
+	return h.Sum( nil ), nil
+}
+
+/*
+	Returns true if the remote file exists with the same size and modification time, or the
+	same size and content, as the local file (see the abstract).
+*/
+func same_file( sc *sftp.Client, lname string, linfo os.FileInfo, rname string ) ( bool ) {
+	rinfo, err := sc.Stat( rname )
+	if err != nil || !rinfo.Mode().IsRegular() || rinfo.Size() != linfo.Size() {
+		return false
+	}
+	if rinfo.ModTime().Unix() == linfo.ModTime().Unix() {			// sftp times are whole seconds
+		return true
+	}
+
+	lf, err := os.Open( lname )
+	if err != nil {
+		return false
+	}
+	defer lf.Close()
+
+	rf, err := sc.Open( rname )
+	if err != nil {
+		return false
+	}
+	defer rf.Close()
+
+	lsum, err := sum_reader( lf )
+	if err != nil {
+		return false
+	}
+	rsum, err := sum_reader( rf )
+	if err != nil || !bytes.Equal( lsum, rsum ) {
+		return false
+	}
+
+	sc.Chtimes( rname, linfo.ModTime(), linfo.ModTime() )		// quick check will match next time
+	return true
+}
+
+/*
+	Copy one local file to the remote name preserving the mode and modification time.
+*/
+func put_file( sc *sftp.Client, lname string, linfo os.FileInfo, rname string ) ( n int64, err error ) {
+	lf, err := os.Open( lname )
+	if err != nil {
+		return
+	}
+	defer lf.Close()
+
+	rf, err := sc.OpenFile( rname, os.O_WRONLY | os.O_CREATE | os.O_TRUNC )
+	if err != nil {
+		return 0, fmt.Errorf( "unable to create remote file %s: %s", rname, err )
+	}
+
+	n, err = io.Copy( rf, lf )
+	cerr := rf.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+
+	if err = sc.Chmod( rname, linfo.Mode().Perm() ); err != nil {
+		return
+	}
+	err = sc.Chtimes( rname, linfo.ModTime(), linfo.ModTime() )
+
+	return
+}
+
+/*
+	Copy the local file or directory (recursively) to the remote name. If skip_same is
+	true files which are unchanged are not copied.
+*/
+func put_tree( sc *sftp.Client, lname string, rname string, skip_same bool, st *Sync_stats ) ( err error ) {
+	linfo, err := os.Stat( lname )
+	if err != nil {
+		return
+	}
+
+	if !linfo.IsDir() {
+		if skip_same && same_file( sc, lname, linfo, rname ) {
+			st.Skipped++
+			return
+		}
+
+		n, err := put_file( sc, lname, linfo, rname )
+		if err == nil {
+			st.Copied++
+			st.Bytes += n
+		}
+		return err
+	}
+
+	if err = sc.MkdirAll( rname ); err != nil {
+		return fmt.Errorf( "unable to create remote directory %s: %s", rname, err )
+	}
+	sc.Chmod( rname, linfo.Mode().Perm() )
+
+	ents, err := os.ReadDir( lname )
+	if err != nil {
+		return
+	}
+	for _, ent := range ents {
+		if err = put_tree( sc, filepath.Join( lname, ent.Name() ), path.Join( rname, ent.Name() ), skip_same, st ); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+/*
+	Copy the remote file or directory (recursively) to the local name.
+*/
+func get_tree( sc *sftp.Client, rname string, lname string ) ( err error ) {
+	rinfo, err := sc.Stat( rname )
+	if err != nil {
+		return fmt.Errorf( "unable to stat remote %s: %s", rname, err )
+	}
+
+	if rinfo.IsDir() {
+		if err = os.MkdirAll( lname, rinfo.Mode().Perm() | 0700 ); err != nil {
+			return
+		}
+
+		ents, err := sc.ReadDir( rname )
+		if err != nil {
+			return err
+		}
+		for _, ent := range ents {
+			if err = get_tree( sc, path.Join( rname, ent.Name() ), filepath.Join( lname, ent.Name() ) ); err != nil {
+				return err
+			}
+		}
+
+		return os.Chmod( lname, rinfo.Mode().Perm() )
+	}
+
+	rf, err := sc.Open( rname )
+	if err != nil {
+		return
+	}
+	defer rf.Close()
+
+	lf, err := os.OpenFile( lname, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, rinfo.Mode().Perm() )
+	if err != nil {
+		return
+	}
+
+	_, err = io.Copy( lf, rf )
+	cerr := lf.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return
+	}
+
+	if err = os.Chmod( lname, rinfo.Mode().Perm() ); err != nil {
+		return
+	}
+	return os.Chtimes( lname, rinfo.ModTime(), rinfo.ModTime() )
+}
+
+/*
+	Sync the sources to the remote directory using the sftp client.  A source ending in a
+	slant has its contents copied to the directory; otherwise the file or directory itself
+	is created in the directory (the same as rsync).
+*/
+func sync_tree( sc *sftp.Client, srcs []string, dest_dir string ) ( st *Sync_stats, err error ) {
+	st = &Sync_stats{ }
+
+	if err = sc.MkdirAll( dest_dir ); err != nil {
+		return st, fmt.Errorf( "unable to create remote directory %s: %s", dest_dir, err )
+	}
+
+	for _, src := range srcs {
+		if src == "" {
+			continue
+		}
+
+		dest := path.Join( dest_dir, filepath.Base( src ) )
+		if strings.HasSuffix( src, "/" ) {
+			dest = dest_dir
+		}
+
+		if err = put_tree( sc, src, dest, true, st ); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Put copies the local file or directory (recursively) to the remote host. Dest is the
+	remote file or directory name that is created.
+*/
+func ( b *Broker ) Put( host string, src string, dest string ) ( err error ) {
+	sc, err := b.host_sftp( host )
+	if err != nil {
+		return
+	}
+
+	return put_tree( sc, src, dest, false, &Sync_stats{} )
+}
+
+/*
+	Get copies the remote file or directory (recursively) to the local host. Dest is the
+	local file or directory name that is created.
+*/
+func ( b *Broker ) Get( host string, src string, dest string ) ( err error ) {
+	sc, err := b.host_sftp( host )
+	if err != nil {
+		return
+	}
+
+	return get_tree( sc, src, dest )
+}
+
+/*
+	Sync copies the local files and directories to the directory on the remote host. Files
+	which are unchanged (same size and time, or content) are skipped.  A source name ending in a slant causes the
+	contents of the directory, rather than the directory itself, to be copied.
+*/
+func ( b *Broker ) Sync( host string, srcs []string, dest_dir string ) ( st *Sync_stats, err error ) {
+	sc, err := b.host_sftp( host )
+	if err != nil {
+		return
+	}
+
+	return sync_tree( sc, srcs, dest_dir )
+}
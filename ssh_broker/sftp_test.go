@@ -0,0 +1,129 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	sftp_test.go
+	Abstract:	Tests the tree copy and sync functions using an sftp server on pipes
+				which serves the local filesystem.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+type pipe_rwc struct {
+	io.Reader
+	io.WriteCloser
+}
+
+/*
+	Returns the client and a function which shuts down the server and client; the
+	server must close first or the client blocks waiting for its reader to end.
+*/
+func mk_test_sftp( t *testing.T ) ( *sftp.Client, func() ) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+
+	srv, err := sftp.NewServer( pipe_rwc{ sr, sw } )
+	if err != nil {
+		t.Fatal( err )
+	}
+	go srv.Serve()
+
+	sc, err := sftp.NewClientPipe( cr, cw )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	return sc, func() { srv.Close(); sc.Close() }
+}
+
+func TestSync( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "sftp" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	src := filepath.Join( dir, "src" )
+	os.MkdirAll( filepath.Join( src, "sub" ), 0755 )
+	ioutil.WriteFile( filepath.Join( src, "run.ksh" ), []byte( "#!/bin/ksh\necho hi\n" ), 0750 )
+	ioutil.WriteFile( filepath.Join( src, "sub", "data" ), []byte( "data\n" ), 0640 )
+
+	sc, done := mk_test_sftp( t )
+	defer done()
+
+	dest := filepath.Join( dir, "dest" )
+	st, err := sync_tree( sc, []string{ src + "/" }, dest )
+	if err != nil {
+		t.Fatalf( "sync failed: %s", err )
+	}
+	if st.Copied != 2 || st.Skipped != 0 {
+		t.Errorf( "unexpected first sync stats: %+v", st )
+	}
+
+	info, err := os.Stat( filepath.Join( dest, "run.ksh" ) )
+	if err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf( "mode not preserved: %v %v", info, err )
+	}
+
+	later := time.Now().Add( time.Hour )
+	ioutil.WriteFile( filepath.Join( src, "sub", "data" ), []byte( "DATA\n" ), 0640 )		// same size, different content
+	os.Chtimes( filepath.Join( src, "sub", "data" ), later, later )
+	st, err = sync_tree( sc, []string{ src + "/" }, dest )
+	if err != nil || st.Copied != 1 || st.Skipped != 1 {
+		t.Errorf( "unexpected second sync stats: %+v %v", st, err )
+	}
+
+	os.Chtimes( filepath.Join( src, "run.ksh" ), later, later )						// touched, content unchanged
+	st, err = sync_tree( sc, []string{ src + "/" }, dest )
+	if err != nil || st.Copied != 0 || st.Skipped != 2 {
+		t.Errorf( "unexpected third sync stats: %+v %v", st, err )
+	}
+	if info, err := os.Stat( filepath.Join( dest, "run.ksh" ) ); err != nil || info.ModTime().Unix() != later.Unix() {
+		t.Errorf( "remote time not updated after content matched: %v %v", info, err )
+	}
+
+	ioutil.WriteFile( filepath.Join( dest, "sub", "data" ), []byte( "XXXX\n" ), 0640 )		// same size and time: assumed unchanged
+	os.Chtimes( filepath.Join( dest, "sub", "data" ), later, later )
+	linfo, _ := os.Stat( filepath.Join( src, "sub", "data" ) )
+	if !same_file( sc, filepath.Join( src, "sub", "data" ), linfo, filepath.Join( dest, "sub", "data" ) ) {
+		t.Errorf( "same size and time was not treated as unchanged" )
+	}
+	ioutil.WriteFile( filepath.Join( dest, "sub", "data" ), []byte( "DATA\n" ), 0640 )
+	os.Chtimes( filepath.Join( dest, "sub", "data" ), later, later )
+
+	got := filepath.Join( dir, "got" )
+	if err = get_tree( sc, dest, got ); err != nil {
+		t.Fatalf( "get failed: %s", err )
+	}
+	if b, _ := ioutil.ReadFile( filepath.Join( got, "sub", "data" ) ); string( b ) != "DATA\n" {
+		t.Errorf( "get copied wrong content: %q", b )
+	}
+}
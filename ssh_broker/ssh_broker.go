@@ -47,6 +47,21 @@
 				07 Jan 2016 - Switched crypto/ssh to pull from golang.org since code.google is deprecated.
 				28 Apr 2017 - Deal with new crypto/ssh requirement that host key callback be supplied in 
 					the connection configuration.
+				19 Oct 2026 - Added Mk_broker_opts() and host key verification policies.
+				19 Oct 2026 - Added agent, passphrase, certificate and password authentication.
+				19 Oct 2026 - Added jump host support.
+				19 Oct 2026 - Added per-command deadlines and cancellation.
+				19 Oct 2026 - Added streaming of output lines and the *_opts run functions.
+				19 Oct 2026 - Capture exit status/signal and classify failures; retries are now based
+					on the classification and only made for failures before the command started.
+				19 Oct 2026 - Added fan-out execution across a host list.
+				19 Oct 2026 - Added sftp Put/Get/Sync; sync on connect now uses sftp rather than rsync.
+				19 Oct 2026 - Apply per-host settings from an OpenSSH client config file.
+				19 Oct 2026 - Added verbatim script transport.
+				19 Oct 2026 - Added keepalives, proactive reconnect, idle eviction and Stats().
+				19 Oct 2026 - Added local and remote port forwarding.
+				19 Oct 2026 - Added the audit sink.
+				19 Oct 2026 - Added PTY, environment and stdin options, and interactive sessions.
 
 	CAUTION:	This package requires go 1.3.3 or later.
 */
@@ -101,16 +116,97 @@
 	that other script types can be used, though it is known that #!/usr/bin/env awk will fail and
 	thus "pure awk" must be wrapped inside of a ksh or bash script.
 
+	Because comments and blank lines are removed, heredocs and multi-line strings can be
+	broken by this transport.  Setting Verbatim in the Run_opts given to Run_on_host_opts()
+	(or NBRun_on_host_opts()) causes the script to be copied unaltered to a private
+	temporary directory on the remote host and executed there, so $0 and the arguments are
+	real and the script may read standard input (Stdin option).  The directory is removed
+	when the script completes.
+
 	There are also two functions which support the running af a command on the remote host in a
 	"traditional" SSH fashion.  Run_cmd (blocking) and NBRun_cmd (non-blocking) run the command
 	in a similar fashion as the script execution methods.
 
-	The user can also associate an rsync command to be executed on each successful connection
-	to a host.  This is done using the Add_rsync() function which supplies a list of files
-	(space separated) and a directory to which they are to be placed on the remote hosts. The
-	directory name should have a trailing slant (/) to ensure that rsync properly creates
-	it.  Because it _might_ be acceptable to the caller that the directory does not need a
-	slant, this code will not add one if it is missing.
+	The user can also associate a set of files to be synchronised to each host on each
+	successful connection.  This is done using the Add_rsync() function which supplies a
+	list of files (space separated) and a directory to which they are to be placed on the
+	remote hosts.  The files are copied with sftp over the new connection and files which
+	are unchanged on the remote host are skipped. (The name is historic; rsync was once
+	used and is no longer needed on either host.)
+
+	Put(), Get() and Sync() copy files and directory trees to and from a host using sftp
+	over the pooled connection.  Modes and modification times are preserved, and Sync()
+	skips files whose content has not changed.
+
+	Mk_broker() accepts any host key that the remote host presents.  Mk_broker_opts() allows
+	a host key policy (Hk_policy) to be supplied which verifies keys against known_hosts
+	files (strict), records the key of hosts that are not yet known (trust on first use),
+	and/or checks pinned fingerprints. When a key cannot be verified the request's error
+	is an *Hk_error which can be fetched with Get_hk_error():
+
+		opts := &Broker_opts {
+			Keys: keys,
+			Host_keys: &Hk_policy { Mode: HK_STRICT, Known_hosts: []string { "/home/scooter/.ssh/known_hosts" } },
+		}
+		broker, err := Mk_broker_opts( "scooter", opts )
+
+	Broker_opts also allows keys held by an ssh-agent (SSH_AUTH_SOCK) to be used and the
+	agent to be forwarded to remote sessions, passphrase protected keys (the passphrase
+	function is invoked for each), OpenSSH user certificates (key-cert.pub files next to
+	a key are used automatically), and a password which is used for password and
+	keyboard-interactive authentication if the key methods fail.
+
+	The *_ctx variants of the run functions accept a context; when the context is cancelled,
+	or its deadline passes, the remote command is killed, the session closed, and the request
+	returned with Timed_out() or Cancelled() set. Set_cmd_timeout() sets a default deadline
+	for all requests that do not have one.
+
+	Output is normally buffered and returned when the command completes. The *_opts run
+	functions accept a Stream_sink which causes each line of output to be delivered, as it
+	is received, to a channel or writer tagged with the host, request id and stream.
+
+	When a request fails Get_fail_class() indicates whether the failure was local (setup),
+	a transport problem (connection, authentication), the remote command exiting non-zero
+	or being killed by a signal (remote), or a timeout.  Get_exit_status() returns the remote
+	exit code and signal.
+
+	Fanout_script() and Fanout_cmd() run the same script or command on a list of hosts, with
+	a concurrency limit, and return the results for all hosts along with a summary. They can
+	optionally stop starting hosts once a number of failures have been seen.
+
+	Hosts which can only be reached through one or more jump hosts (bastions) can be
+	configured with Set_jump_hosts(), or for all hosts using the Jump_hosts option. The
+	connection to a jump host is pooled like any other connection and is shared by all
+	of the hosts reached through it.
+
+	Connections are normally checked only when a command fails. The Keepalive option causes
+	a keepalive request to be sent on each connection periodically; a connection which fails
+	to respond is closed and reconnected in the background so the next command does not
+	pay for the reconnect. Idle_timeout closes connections which have not been used for a
+	while (a jump host is kept while anything is tunnelled through it).  Both can be changed with Set_keepalive(), and Stats() reports, for each host,
+	the connection age, sessions in use and command, retry and failure counts.
+
+	Forward_local() and Forward_remote() tunnel TCP connections over the pooled connection
+	to a host, in the same way as ssh -L and -R, allowing services which listen only on
+	the remote host's loopback address to be reached.  Forwards are closed when the broker
+	is closed or Close_session() is called for the host.
+
+	An audit sink (Audit option or Set_audit()) receives a record for every command and
+	script run: when, by whom, on which host, the script's sha256, parameters, exit status,
+	duration and output byte counts. Mk_json_audit() creates a sink which appends the
+	records to a file as JSON lines.
+
+	Run_opts can also request a pseudo terminal (for commands such as sudo when requiretty
+	is set; a script must be run verbatim to have one), set environment variables, and
+	supply standard input for a command.
+	Interactive() starts a command or shell on a pooled connection and returns a handle
+	with the standard input, output and error pipes for the caller to drive directly.
+
+	An OpenSSH client config file (usually ~/.ssh/config) can be given with the Ssh_config
+	option or Set_ssh_config(). The HostName, User, Port, IdentityFile, ProxyJump,
+	ConnectTimeout and ServerAliveInterval settings from the Host and Match blocks which
+	match a host are applied when the connection to the host is established.  A user or
+	jump host list given explicitly to the broker takes precedence.
 
 	https://godoc.org/golang.org/x/crypto/ssh
  */
@@ -119,6 +215,8 @@ package ssh_broker
 import (
 	"bytes"
 	"bufio"
+	"context"
+	"errors"
     "fmt"
 	"io"
 	"net"
@@ -126,9 +224,12 @@ import (
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // ------ private structures -----------------------------------------------------------------------------
@@ -139,10 +240,25 @@ type connection struct {
 	host		string				// host name:port connected to
 	schan		*ssh.Client			// the ssh supplied connection
 	retry_ch	chan *Broker_msg	// retry channel for the host
-	last_cmd	int64				// timestamp of last command to prevent ssh from going stale
+	last_cmd	int64				// timestamp of last command to prevent ssh from going stale (atomic)
 	active		bool				// if an error occurs this flag is marked false forcing a reconnect
+	sftpc		*sftp.Client		// sftp client on schan; created on first use
+	chain		[]string			// jump hosts used to reach the host
+	sync		bool				// files are synced on connect; set once used other than as a jump host (under stat_lock)
+	since		time.Time			// when schan was established
+	in_use		int32				// sessions currently running commands (atomic)
+	tunnels		int32				// connections tunnelled through this one as a jump host (atomic)
 
 	host_lock	sync.Mutex			// must hold the mutex to attempt a session
+	sftp_lock	sync.Mutex			// gates creation of the sftp client
+
+	stat_lock	sync.Mutex			// gates active, schan and since, and the counters below; schan is
+									// changed only with host_lock held as well so it may be read under either
+	ncmds		int64				// commands completed
+	nretries	int64				// commands requeued for retry
+	nfails		int64				// commands which failed
+	nreconnects	int					// proactive reconnects after a failed keepalive
+	last_err	string				// most recent failure
 }
 
 // ------ public structures -----------------------------------------------------------------------------
@@ -161,9 +277,39 @@ type Broker struct  {
 	was_closed	bool					// set to true if Close called on us so we don't try to reuse
 	rsync_src	*string					// space separated list of files to rsynch to the other side
 	rsync_dir	*string					// target directory for rsync
+	hk			*hk_checker				// host key verification; nil if any key is accepted
+	auth		*auth_info				// authentication methods and agent connection
+	fwd_agent	bool					// forward the agent to remote sessions
+	cfg_lock	sync.RWMutex			// gates access to per-host configuration (jumps, ssh config)
+	jumps		map[string][]string		// host specific jump host lists
+	def_jumps	[]string				// jump hosts used when a host has no specific list
+	ssh_cfg		*Ssh_config				// OpenSSH client config applied to each host; nil if none
+	alive		time.Duration			// keepalive interval for connections without one in the ssh config
+	idle		time.Duration			// connections unused for this long are closed (0 == never)
+	fwds		map[*Forward]bool		// active port forwards (under cfg_lock)
+	audit		Audit_sink				// receives a record for each request; nil if not auditing
+	cmd_timeout	time.Duration			// default max run time for a command (0 == forever; under cfg_lock)
 	verbose		bool					// we might get chatty if it's true
 }
 
+/*
+	Options which can be supplied to Mk_broker_opts().
+*/
+type Broker_opts struct {
+	Keys		[]string				// private key files
+	Passphrase	Passphrase_fn			// called for the passphrase of an encrypted key file
+	Certs		[]string				// OpenSSH certificate files (paired with the key having the same public key)
+	Use_agent	bool					// authenticate with keys held by the agent at SSH_AUTH_SOCK
+	Forward_agent bool					// forward the agent to remote sessions
+	Password	string					// password/keyboard-interactive fallback
+	Host_keys	*Hk_policy				// host key verification; nil accepts any key
+	Jump_hosts	[]string				// default jump hosts ([user@]host[:port]) in the order they are traversed
+	Ssh_config	string					// OpenSSH client config file (e.g. ~/.ssh/config) to apply; empty for none
+	Keepalive	time.Duration			// interval between keepalive requests on each connection (0 == none)
+	Idle_timeout time.Duration			// close connections not used for this long (0 == never)
+	Audit		Audit_sink				// receives a record for each command or script run
+}
+
 /*
 	Used to pass information into an initiator and then back to the requestor. External users
 	(non-package functions) can use the get functions related to this struct to extract
@@ -183,6 +329,23 @@ type Broker_msg struct {
 	stderr	bytes.Buffer
 	err		error					// any resulting error
 	resp_ch chan *Broker_msg		// channel used to send back results
+	ctx		context.Context			// caller's context (nil if none given)
+	timed_out bool					// command was killed because the deadline passed
+	cancelled bool					// command was killed because the caller cancelled
+	stream	*Stream_sink			// if not nil, output is streamed here
+	exit_code int					// remote exit status; -1 if the command didn't complete
+	signal	string					// signal which terminated the remote command
+	fail	int						// failure classification (FAIL_ constants)
+	started	bool					// the remote command was started; it is not retried after this
+	verbatim bool					// upload the script unaltered rather than sending it on stdin
+	stdin	io.Reader				// standard input for the command or verbatim script; never retried when set
+	tmp_dir	string					// remote directory for verbatim scripts
+	began	time.Time				// when the request was started (audit)
+	nout	int64					// bytes written to stdout by the remote command (atomic)
+	nerr	int64					// bytes written to stderr (atomic)
+	ssum	string					// sha256 of the script run (audit only)
+	pty		*Pty_opts				// pseudo terminal to request; nil for none
+	envars	map[string]string		// environment variables to set on the session
 }
 
 // --------------------------------------------------------------------------------------------------
@@ -272,7 +435,9 @@ func send_script( sess *ssh.Session, argv0 string, env_file string, br *bufio.Re
 	Allocates stdin on the session and then runs the #! named command. If sname
 	is a relative or absolute path then it is opened directly. If it is not, then
 	PATH is searched for the script.  This function assumes that the session has
-	already been set up with stdout/err if needed.
+	already been set up with stdout/err if needed.  Environment variables requested
+	are set; a pty can't be used as the script is written on stdin, so a request for
+	one fails unless the script is run verbatim.
 
 */
 func ( b *Broker ) roar( req *Broker_msg ) ( err error ) {
@@ -281,30 +446,48 @@ func ( b *Broker ) roar( req *Broker_msg ) ( err error ) {
 		return
 	}
 
-	sess, err := b.session2( req.host )							// get a connection and session
+	if req.verbatim {
+		return b.roar_verbatim( req )
+	}
+
+	if req.pty != nil {
+		req.fail = FAIL_SETUP
+		return fmt.Errorf( "not run: a pty can't be used when the script is sent on stdin; run it verbatim: %s", req.sname )
+	}
+
+	sess, c, err := b.session2( req.host )						// get a connection and session
 	if err != nil {
 		return
 	}
+	defer c.busy( -1 )
 	defer sess.Close()
 
-	sess.Stdout = &req.stdout
-	sess.Stderr = &req.stderr
+	if err = req.setup_session( sess ); err != nil {
+		return
+	}
+
+	var flush func()
+	sess.Stdout, sess.Stderr, flush = req.out_writers()
+	defer flush()
 
 	pname, err := find_file( req.sname )
 	if err != nil {
+		req.fail = FAIL_SETUP
 		return
 	}
 
 
 	f, err := os.Open( pname )							// open script and read first line here to suss off shell
 	if err != nil {
+		req.fail = FAIL_SETUP
 		return
 	}
 	defer f.Close()
 
+	b.audit_script( req, pname )
 	br := bufio.NewReader( f );								// get a buffered reader for the file
 	rec, rerr := br.ReadBytes( '\n' );						// read first line
-	if rec[0] == '#' && rec[1] == '!' && rerr == nil {
+	if len( rec ) > 2 && rec[0] == '#' && rec[1] == '!' && rerr == nil {
 		rec = bytes.Trim( rec, "\n" )						// zap the newline
 
 		shell := string( rec[2:] ) + " -s -- " + req.parms		// assume ksh or bash
@@ -313,8 +496,9 @@ func ( b *Broker ) roar( req *Broker_msg ) ( err error ) {
 		}
 
 		go send_script( sess, pname, req.env, br )			// write the remainder of the script in parallel
-		err = sess.Run( shell )
+		err = b.run( req, sess, shell )
 	} else {
+		req.fail = FAIL_SETUP
 		err = fmt.Errorf( "not run: run on a remote requires script to have leading #! directive on the first line: %s\n", pname )
 	}
 
@@ -330,16 +514,23 @@ func ( b *Broker ) rcmd( req *Broker_msg ) ( err error ) {
 		return
 	}
 
-	sess, err := b.session2( req.host )							// get a connection and session
+	sess, c, err := b.session2( req.host )						// get a connection and session
 	if err != nil {
 		return
 	}
+	defer c.busy( -1 )
 	defer sess.Close()
 
-	sess.Stdout = &req.stdout
-	sess.Stderr = &req.stderr
+	if err = req.setup_session( sess ); err != nil {
+		return
+	}
+	sess.Stdin = req.stdin
+
+	var flush func()
+	sess.Stdout, sess.Stderr, flush = req.out_writers()
+	defer flush()
 
-	err = sess.Run( req.cmd )
+	err = b.run( req, sess, req.cmd )
 
 	return
 }
@@ -349,35 +540,29 @@ func ( b *Broker ) rcmd( req *Broker_msg ) ( err error ) {
 	is needed by ssh in the config auth list.
 */
 func read_key_file( kfname string ) ( s ssh.Signer, err error ) {
-	s = nil
-
-	kf, err := os.Open( kfname )
-	if err != nil {
-		return
-	}
-	defer kf.Close()
-
-	buf := make( []byte, 4096 )			// we could check file length and base on that
-	_, err = kf.Read( buf )
-	if err != nil {
-		return
-	}
+	return read_key_file_pp( kfname, nil )
+}
 
-	s, err = ssh.ParsePrivateKey(  buf )		// convert to a "signer"
-	if err != nil {
-		s = nil
-		return
+/*
+	Find or create our connection to the named host. If a connection doesn't
+	exist, then we'll create one (through jump hosts if configured for the host).
+*/
+func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
+	if b == nil || b.was_closed {
+		err = fmt.Errorf( "run_cmd: broker pointer was nil, or broker has been closed" )
+		return nil, err
 	}
 
-	return
+	host = b.host_port( host )						// add the config's, or default, port if not supplied
+	return b.connect3( host, b.jump_chain( host ), true )
 }
 
 /*
-	Find or create our connection to the named host. If a connection doesn't
-	exist, then we'll create one. If the rsync data is present, then we'll
-	rsynch stuff over while we have the lock.
+	Find or create the connection to host reaching it through the jump host chain
+	if the connection must be created. If the rsync data is present, and sync is true,
+	then we'll rsynch stuff over while we have the lock.
 */
-func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
+func ( b *Broker ) connect3( host string, chain []string, sync bool ) ( c *connection, err error ) {
 	err = nil
 	need_sync := false					// must detect early and execute late so flag if needed
 
@@ -386,18 +571,14 @@ func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
 		return nil, err
 	}
 
-	if strings.Index( host, ":" ) < 0  {
-		host = host + ":22"							// add default port if not supplied
-	}
-
 	b.conns_lock.RLock()								// get a read lock
 	c = b.conns[host]
 	b.conns_lock.RUnlock()
-	if c != nil && c.active {							// we've already connected, just return
+	if c != nil && c.is_active() && (!sync || c.synced()) {		// we've already connected, just return
 		return c, nil
 	}
 
-	if b.rsync_src != nil && b.rsync_dir != nil {		// no connection, rsynch if we need to
+	if sync && b.rsync_src != nil && b.rsync_dir != nil {		// no connection, rsynch if we need to
 		need_sync = true								// but wait until we auth the connection to prevent prompt
 	}
 
@@ -407,9 +588,8 @@ func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
 	//defer b.conns_lock.Unlock()							// hold until we return
 
 	c = b.conns[host]
-	is_new := false
 	if c != nil {										// created while we were waiting on lock or existed but not active
-		if c.active {
+		if c.is_active() && (!sync || c.synced()) {
 			if b.verbose {
 				fmt.Fprintf( os.Stderr, "ssh_broker: connection established while waiting on lock\n" )
 			}
@@ -417,17 +597,26 @@ func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
 			return	c, nil								// if active, then safe to send it back now
 		}
 	} else {
-		c = &connection{ host: host }
-		is_new = true									// need to allocate the retry channel once we have a lock
+		c = &connection{ host: host, chain: chain }
+		c.retry_ch = make( chan *Broker_msg, 1024 )		// new struct, must alloc the host retry queue
+		b.conns[host] = c								// others now wait on its lock rather than making another
 	}
 
 	b.conns_lock.Unlock()								// safe to release lock on main hash
 	c.host_lock.Lock()									// must have lock before we can do anything to it
 	defer c.host_lock.Unlock()							// this can be deferred as we'll hold til the end
-	if c.active {										// activated while we waited... just go on
+	if c.is_active() {									// activated while we waited... just go on
 		if b.verbose {
 			fmt.Fprintf( os.Stderr, "ssh_broker: connection established while waiting on second lock\n" )
 		}
+		if sync && ! c.synced() {						// made as a jump host; now a target too
+			if need_sync {
+				if err = b.synch_host( c ); err != nil {
+					return c, fmt.Errorf( "unable to sync files to %s: %s", host, err )
+				}
+			}
+			c.set_sync()
+		}
 		return c, nil 
 	}
 
@@ -435,12 +624,12 @@ func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
 		fmt.Fprintf( os.Stderr, "have lock, going on\n" )
 	}
 	// we have the lock, and connection isn't active, so let's make it so Mr. Crusher
-	if is_new {
-		c.retry_ch = make( chan *Broker_msg, 1024 )		// new struct, must alloc the host retry queue
-	}
-
-	c.schan, err = ssh.Dial( "tcp", host, b.config )	// establish the tcp session (ssh channel) this can block for minutes!
+	client, alive, err := b.dial( host, chain )			// establish the tcp session (ssh channel) this can block for minutes!
 	if err != nil {
+		var hke *Hk_error
+		if errors.As( err, &hke ) {					// return host key errors unwrapped so the caller can test the type
+			err = hke
+		}
 		c = nil
 		if b.verbose {
 			fmt.Fprintf( os.Stderr, "ssh_broker: couldn't establishe tcp session to %s: %s\n", host, err )
@@ -448,57 +637,92 @@ func ( b *Broker ) connect2( host string ) ( c *connection, err error ) {
 		return
 	}
 
+	c.stat_lock.Lock()
+	c.schan = client									// not active yet; sync (below) uses it
+	c.stat_lock.Unlock()
+
+	if b.fwd_agent {
+		if ferr := b.auth.forward( client ); ferr != nil && b.verbose {
+			fmt.Fprintf( os.Stderr, "ssh_broker: unable to forward agent to %s: %s\n", host, ferr )
+		}
+	}
+
+	c.sftp_close()										// any sftp client was on the old connection
+
 	if need_sync {
 		if b.verbose  {
 			fmt.Fprintf( os.Stderr, "ssh_broker: sync with host=%s  d=%s\n",  host, *b.rsync_dir )
 		}
-		toks := strings.Split( host, ":" )				// must split off port for rsynch
-		err = b.synch_host( &toks[0] )
+		err = b.synch_host( c )
 		if err != nil {
-			err = fmt.Errorf( "unable to rsynch to %s: %s", host, err )
-			c.schan.Close()								// if rsynch fails connection "fails"
-			c.active = false
+			err = fmt.Errorf( "unable to sync files to %s: %s", host, err )
+			client.Close()								// if sync fails connection "fails"
 			return
 		}
 	}
 	
-	c.last_cmd = time.Now().Unix()
+	c.stat_lock.Lock()
+	c.since = time.Now()
+	atomic.StoreInt64( &c.last_cmd, c.since.Unix() )
 	c.active = true
-	b.conns[host] = c									// finally, add to our map (host:port)
+	c.sync = c.sync || sync
+	c.stat_lock.Unlock()
+	b.start_watch( c, client, alive )
 
 	return
 }
 
 /*
 	Create a new session to the named host establishing the connection if we must.
+	The session is counted as a user of the connection (see hold()) from the moment it
+	is handed out; the caller must call busy( -1 ) on the connection returned when it
+	has finished with the session.
 */
-func ( b *Broker ) session2( host string ) ( s *ssh.Session, err error ) {
-
-	var c *connection
+func ( b *Broker ) session2( host string ) ( s *ssh.Session, c *connection, err error ) {
 
-	s = nil
 	c, err = b.connect2( host )			// ensure we have a connection first (rsync if defined if not connected)
 	if err != nil {
-		return
+		return nil, nil, err
 	}
 
-	s, err = c.schan.NewSession( )						// create a new session
+	client, s, err := c.open_session( )
 	if err != nil  && !  strings.Contains(  fmt.Sprintf( "%s", err ), "administratively prohibited" ) {	
-		c.schan.Close( )					// any error other than at session max, assume we need to reconnect
-		c.active = false
-
-		s = nil
+		if client != nil {
+			c.drop( client )				// any error other than at session max, assume we need to reconnect
+		}
 
 		c, err = b.connect2( host )
 		if err != nil {
-			return
+			return nil, nil, err
 		}
 
-		s, err = c.schan.NewSession( )
-		if err != nil {							// time to give up and return error
-			s = nil
-		}
+		_, s, err = c.open_session( )
+	}
+	if err != nil {							// time to give up and return error
+		return nil, nil, err
+	}
 
+	if b.fwd_agent {
+		agent.RequestAgentForwarding( s )		// failure isn't fatal; the command just won't have an agent
+	}
+
+	return
+}
+
+/*
+	Open a session on the connection counting it as a user of the connection. The client
+	used is returned (nil if the connection was down) so that it can be dropped on failure.
+*/
+func ( c *connection ) open_session( ) ( client *ssh.Client, s *ssh.Session, err error ) {
+	client = c.hold( )
+	if client == nil {
+		return nil, nil, fmt.Errorf( "connection to %s is down", c.host )
+	}
+
+	s, err = client.NewSession( )
+	if err != nil {
+		c.busy( -1 )
+		s = nil
 	}
 
 	return
@@ -556,7 +780,12 @@ func ( b *Broker ) initiator( id int ) {
 			continue
 		}
 
-		if req.cmd != "" {								// remote command to execute rather than a local script
+		req.began = time.Now()
+		if req.ctx != nil && req.ctx.Err() != nil {		// cancelled or expired while waiting in the queue
+			req.startt = time.Now().Unix()
+			req.err = b.ctx_err( req, req.ctx )
+			req.endt = req.startt
+		} else if req.cmd != "" {							// remote command to execute rather than a local script
 			req.startt = time.Now().Unix()
 			req.err = b.rcmd( req )						// run it
 			req.endt = time.Now().Unix()
@@ -566,13 +795,13 @@ func ( b *Broker ) initiator( id int ) {
 			req.endt = time.Now().Unix()
 		}
 
+		req.classify( req.err )
 		if req.err != nil {
-			if req.ntries < 10  &&  
-				( strings.Contains( fmt.Sprintf( "%s", req.err ), "administratively prohibited" ) ||
-				  strings.Contains( fmt.Sprintf( "%s", req.err ), "use of closed network connection" )  ) {	// likely over max sessions or remote rebooted/died
+			if req.ntries < 10  &&  req.retryable() {		// likely over max sessions or remote rebooted/died
 				c, err := b.connect2( req.host )			// find the connection
 				if err == nil { 							// no error finding it, then queue the request to be retried
-					req.err = nil
+					c.count( req, true )
+					req.reset()
 					req.ntries++
 					c.retry_ch <- req
 					req = nil								// don't send result and dont check retry queue below
@@ -581,6 +810,8 @@ func ( b *Broker ) initiator( id int ) {
 		}
 
 		if req != nil {											// if not requeued above
+			b.get_conn( req.host ).count( req, false )
+			b.audit_req( req )
 			c, err := b.connect2( req.host )					// find the connection for the host (before giving up control of req)
 			if  req.resp_ch != nil {							// return result
 				req.resp_ch <- req
@@ -622,7 +853,8 @@ func (m *Broker_msg) Get_info( ) ( host string, sname string, id int ) {
 /*
 	Mk_broker creates a broker for the given user and with the given key files.
 	If keys are given, then the assumption is that there should _not_ be any prompt for
-	password. If keys is nil, then prompting will be allowed.
+	password. If keys is nil, then prompting will be allowed.  Any host key is accepted;
+	use Mk_broker_opts() to verify host keys.
 */
 func Mk_broker( user string, keys []string ) ( broker *Broker ) {
 	if len( keys ) <= 0 {
@@ -630,33 +862,58 @@ func Mk_broker( user string, keys []string ) ( broker *Broker ) {
 		return
 	}
 
-	broker = &Broker { }
-	broker.conns = make( map[string]*connection, 100 )		// value is a hint, not limit
-	broker.was_closed = false
-	//broker.verbose = true
+	broker, err := Mk_broker_opts( user, &Broker_opts{ Keys: keys } )
+	if err != nil {
+		fmt.Fprintf( os.Stderr, "mk_broker: %s\n", err )
+	}
 
-	auth_list := make( []ssh.AuthMethod, len( keys ) )
+	return
+}
 
-	j := 0
-	for i := range keys {
-		s, err := read_key_file( keys[i]  )
-		if err == nil {										// error isn't fatal to the process, but not having the key later might cause issues
-			auth_list[j] = ssh.PublicKeys( s )
-			j++
-		}
+/*
+	Mk_broker_opts creates a broker for the user using the options supplied.  Nil is
+	returned with an error if the options cannot be used.
+*/
+func Mk_broker_opts( user string, opts *Broker_opts ) ( broker *Broker, err error ) {
+	if opts == nil {
+		return nil, fmt.Errorf( "no options supplied" )
 	}
 
-	if j <= 0 {												// didn't find a suitable key
-		fmt.Fprintf( os.Stderr, "mk_broker: no suitable key found\n" )
-		broker = nil
-		return
+	hk, err := mk_hk_checker( opts.Host_keys )
+	if err != nil {
+		return nil, err
+	}
+
+	ai, err := mk_auth( opts, false )
+	if err != nil {
+		return nil, err
+	}
+
+	broker = &Broker { }
+	broker.conns = make( map[string]*connection, 100 )		// value is a hint, not limit
+	broker.was_closed = false
+	broker.hk = hk
+	broker.auth = ai
+	broker.fwd_agent = opts.Forward_agent
+	broker.def_jumps = opts.Jump_hosts
+	broker.alive = opts.Keepalive
+	broker.idle = opts.Idle_timeout
+	broker.audit = opts.Audit
+	if opts.Ssh_config != "" {
+		if broker.ssh_cfg, err = Parse_ssh_config( opts.Ssh_config ); err != nil {
+			ai.close()
+			return nil, err
+		}
 	}
 
 	broker.config = &ssh.ClientConfig {						// set up the config info that ssh needs to open a connection
 		User: user,
-		Auth: auth_list[0:j],								// drop nil entries (ssh crashes if they are there)
+		Auth: ai.methods,
 		ClientVersion: "",
-		HostKeyCallback: allow_any_hk,						// ignore host key changes
+		HostKeyCallback: allow_any_hk,						// ignore host key changes unless a policy was given
+	}
+	if hk != nil {
+		broker.config.HostKeyCallback = hk.check
 	}
 
 	broker.init_ch = make( chan *Broker_msg, 2048 )
@@ -694,18 +951,21 @@ func ( b *Broker ) Close( ) {
 		return
 	}
 
+	b.close_fwds( "" )
+
 	b.conns_lock.Lock( )								// get a write lock
 	defer b.conns_lock.Unlock()							// hold until we return
 
 	for k, c := range b.conns {
 		if c != nil {
 			c.host_lock.Lock()				// there still could be other threads with connection locks so must get this too
-			c.schan.Close()
+			c.drop( nil )
 			b.conns[k] = nil
 			c.host_lock.Unlock()
 		}
 	}
 
+	b.auth.close()						// drop the agent connection if there is one
 	close( b.init_ch )					// close the initiator channel which should cause initiators to stop
 										// do NOT close retry channel as it could lead to a panic
 
@@ -727,8 +987,7 @@ func ( b *Broker ) Reset( ) {
 	for k, c := range b.conns {
 		if c != nil {
 			c.host_lock.Lock()				// there still could be other threads with connection locks so must get this too
-			c.schan.Close()
-			c.schan = nil
+			c.drop( nil )
 			b.conns[k] = nil
 			c.host_lock.Unlock()
 		}
@@ -753,13 +1012,15 @@ func ( b *Broker ) Close_session( name *string ) ( err error ) {
 		return
 	}
 
+	b.close_fwds( *name )			// forwards through the host go with it
+
 	c := b.conns[*name]
 	if c == nil {					// nothing to close
 		return
 	}
 
 	c.host_lock.Lock()				// must have lock to fiddle it
-	err = c.schan.Close()
+	err = c.drop( nil )
 	b.conns[*name] = nil
 	c.host_lock.Unlock()
 
@@ -789,19 +1050,8 @@ func ( b *Broker ) Run_on_host( host string, script string, parms string, env_fi
 		env:	env_file,
 		parms:	parms,
 	}
-	req.resp_ch = make( chan *Broker_msg )			// we'll listen on this channel for response
-													// do NOT close the channel as we aren't sending on it
-	stdout = nil
-	stderr = nil
 
-	b.init_ch <- req						// send request to initiator queue
-	req = <- req.resp_ch					// wait on the response
-	stdout = &req.stdout
-	stderr = &req.stderr
-	req.resp_ch = nil
-	err = req.err
-
-	return
+	return b.run_req( req )
 }
 
 /*
@@ -843,19 +1093,8 @@ func ( b *Broker ) Run_cmd( host string, cmd string ) ( stdout *bytes.Buffer, st
 		host: 	host,
 		cmd:	cmd,
 	}
-	req.resp_ch = make( chan *Broker_msg )			// we'll listen on this channel for response
-													// do NOT close the channel as we aren't sending
-	stdout = nil
-	stderr = nil
 
-	b.init_ch <- req						// send request to initiator queue
-	req = <- req.resp_ch					// wait on the response
-	stdout = &req.stdout
-	stderr = &req.stderr
-	err = req.err
-	req.resp_ch = nil
-
-	return
+	return b.run_req( req )
 }
 
 /*
@@ -0,0 +1,537 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ssh_config.go
+	Abstract: 	Parses an OpenSSH client configuration file (~/.ssh/config) and looks up the
+				settings for a host.  Host and Match blocks are supported (Match with the
+				all, host, originalhost, user and localuser criteria; a Match block with any
+				other criteria, e.g. exec, never matches) as is Include.  As with ssh, the
+				first value found for an option is used, except for IdentityFile where
+				all values are used.
+
+				The options recognised are HostName, User, Port, IdentityFile, ProxyJump,
+				ConnectTimeout and ServerAliveInterval; all others are ignored. The broker
+				applies them when a connection is established.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	A parsed configuration file.
+*/
+type Ssh_config struct {
+	blocks	[]*cfg_block
+}
+
+/*
+	The settings for one host.  Fields are empty/zero if not given in the file.
+*/
+type Host_cfg struct {
+	Hostname		string
+	User			string
+	Port			int
+	Identity_files	[]string
+	Proxy_jump		[]string			// empty if not set; a single "none" entry disables jumping
+	Connect_timeout	time.Duration
+	Alive_interval	time.Duration
+}
+
+/*
+	A Host or Match block. Options given before the first block are in a block which
+	always matches.
+*/
+type cfg_block struct {
+	host_pats	[]string				// Host patterns
+	match		[][]string				// Match criteria pairs (name, value); nil for Host blocks
+	opts		[][]string				// keyword (lower case) and value in the order given
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Return the ASCII lower case of the byte.
+*/
+func lower( c byte ) ( byte ) {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+
+	return c
+}
+
+/*
+	Match the string against a single ssh pattern (* and ? wildcards). As with ssh the
+	match ignores case.
+*/
+func wild_match( pat string, s string ) ( bool ) {
+	for len( pat ) > 0 {
+		switch pat[0] {
+			case '*':
+				for i := len( s ); i >= 0; i-- {
+					if wild_match( pat[1:], s[i:] ) {
+						return true
+					}
+				}
+				return false
+
+			case '?':
+				if len( s ) == 0 {
+					return false
+				}
+
+			default:
+				if len( s ) == 0 || lower( pat[0] ) != lower( s[0] ) {
+					return false
+				}
+		}
+
+		pat = pat[1:]
+		s = s[1:]
+	}
+
+	return len( s ) == 0
+}
+
+/*
+	Match the string against a list of patterns. A negated pattern (!pat) which matches
+	causes the list not to match regardless of the other patterns.
+*/
+func match_list( pats []string, s string ) ( bool ) {
+	matched := false
+	for _, p := range pats {
+		if strings.HasPrefix( p, "!" ) {
+			if wild_match( p[1:], s ) {
+				return false
+			}
+		} else {
+			if wild_match( p, s ) {
+				matched = true
+			}
+		}
+	}
+
+	return matched
+}
+
+/*
+	Split the line into keyword and arguments. Keyword and arguments may be separated
+	by whitespace or an equal sign; arguments may be quoted.
+*/
+func split_cfg_line( line string ) ( key string, args []string ) {
+	line = strings.TrimSpace( line )
+	if line == "" || line[0] == '#' {
+		return "", nil
+	}
+
+	ki := strings.IndexAny( line, " \t=" )
+	if ki < 0 {
+		return strings.ToLower( line ), nil
+	}
+	key = strings.ToLower( line[:ki] )
+	rest := strings.TrimLeft( line[ki:], " \t" )
+	rest = strings.TrimLeft( strings.TrimPrefix( rest, "=" ), " \t" )
+
+	for len( rest ) > 0 {
+		var tok string
+		if rest[0] == '"' {
+			end := strings.IndexByte( rest[1:], '"' )
+			if end < 0 {
+				tok, rest = rest[1:], ""
+			} else {
+				tok, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexAny( rest, " \t" )
+			if end < 0 {
+				tok, rest = rest, ""
+			} else {
+				tok, rest = rest[:end], rest[end:]
+			}
+		}
+		args = append( args, tok )
+		rest = strings.TrimLeft( rest, " \t" )
+	}
+
+	return
+}
+
+/*
+	Expand a leading tilde to the user's home directory.
+*/
+func expand_home( fname string ) ( string ) {
+	if strings.HasPrefix( fname, "~/" ) || fname == "~" {
+		return filepath.Join( os.Getenv( "HOME" ), fname[1:] )
+	}
+
+	return fname
+}
+
+/*
+	Read the file and add its blocks to the config. Depth prevents include loops.
+*/
+func ( sc *Ssh_config ) read( fname string, depth int ) ( err error ) {
+	if depth > 10 {
+		return fmt.Errorf( "ssh config: include nesting too deep: %s", fname )
+	}
+
+	f, err := os.Open( fname )
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	cur := sc.blocks[len( sc.blocks ) - 1]
+	lnum := 0
+	br := bufio.NewScanner( f )
+	for br.Scan() {
+		lnum++
+		key, args := split_cfg_line( br.Text() )
+		if key == "" {
+			continue
+		}
+
+		switch key {
+			case "host":
+				cur = &cfg_block{ host_pats: args }
+				sc.blocks = append( sc.blocks, cur )
+
+			case "match":
+				cur = &cfg_block{ match: [][]string{} }
+				for i := 0; i < len( args ); i++ {
+					crit := strings.ToLower( args[i] )
+					if crit == "all" || crit == "canonical" || crit == "final" {
+						cur.match = append( cur.match, []string{ crit, "" } )
+						continue
+					}
+					if i + 1 < len( args ) {
+						cur.match = append( cur.match, []string{ crit, args[i+1] } )
+						i++
+					} else {
+						return fmt.Errorf( "%s:%d: match criteria %s has no value", fname, lnum, args[i] )
+					}
+				}
+				sc.blocks = append( sc.blocks, cur )
+
+			case "include":
+				for _, pat := range args {
+					pat = expand_home( pat )
+					if !filepath.IsAbs( pat ) {
+						pat = filepath.Join( os.Getenv( "HOME" ), ".ssh", pat )
+					}
+					names, _ := filepath.Glob( pat )
+					for _, iname := range names {
+						if err = sc.read( iname, depth + 1 ); err != nil {
+							return
+						}
+					}
+				}
+				cur = sc.blocks[len( sc.blocks ) - 1]		// included file may have started a block; ssh continues with it
+
+			default:
+				if len( args ) > 0 {
+					cur.opts = append( cur.opts, append( []string{ key }, args... ) )
+				}
+		}
+	}
+
+	return br.Err()
+}
+
+/*
+	Returns true if the block applies to the host.  Name is the name the user gave, hostname
+	is the name after HostName has been applied, and user is the remote user.
+*/
+func ( blk *cfg_block ) matches( name string, hostname string, ruser string ) ( bool ) {
+	if blk.match == nil {
+		if blk.host_pats == nil {
+			return true											// global block
+		}
+		return match_list( blk.host_pats, name )
+	}
+
+	for _, m := range blk.match {
+		pats := strings.Split( m[1], "," )
+		switch m[0] {
+			case "all":
+
+			case "host":
+				if !match_list( pats, hostname ) {
+					return false
+				}
+
+			case "originalhost":
+				if !match_list( pats, name ) {
+					return false
+				}
+
+			case "user":
+				if !match_list( pats, ruser ) {
+					return false
+				}
+
+			case "localuser":
+				lu, err := user.Current()
+				if err != nil || !match_list( pats, lu.Username ) {
+					return false
+				}
+
+			default:											// exec, canonical etc. are not supported
+				return false
+		}
+	}
+
+	return true
+}
+
+/*
+	Expand the % tokens that may appear in IdentityFile values.
+*/
+func expand_tokens( s string, hostname string, name string, port int, ruser string ) ( string ) {
+	if strings.IndexByte( s, '%' ) < 0 {
+		return s
+	}
+
+	luser := ""
+	if lu, err := user.Current(); err == nil {
+		luser = lu.Username
+	}
+
+	r := strings.NewReplacer( "%%", "%", "%h", hostname, "%n", name, "%p", strconv.Itoa( port ),
+		"%r", ruser, "%u", luser, "%d", os.Getenv( "HOME" ) )
+	return r.Replace( s )
+}
+
+/*
+	Add the port to [user@]host if it wasn't given: the config's Port for the host if
+	there is one, otherwise 22.  A port given with the host is never changed, so an
+	explicit host:22 and a host whose config gives another port are different pool
+	entries.
+*/
+func ( b *Broker ) host_port( host string ) ( string ) {
+	user, hp := split_user( host )
+	if strings.Index( hp, ":" ) >= 0 {
+		return host
+	}
+
+	b.cfg_lock.RLock()
+	sc := b.ssh_cfg
+	b.cfg_lock.RUnlock()
+
+	if sc != nil {
+		if user == "" {
+			user = b.config.User
+		}
+		if hc := sc.Lookup( hp, user ); hc.Port > 0 {
+			return host + ":" + strconv.Itoa( hc.Port )
+		}
+	}
+
+	return add_port( host )
+}
+
+/*
+	Apply the ssh config settings for the host (host:port) returning the address to dial,
+	the client config to use and the keepalive interval (0 if none).  An explicit user
+	(user@host) takes precedence over the config's User.  The port isn't changed; the
+	config's Port was applied by host_port() if the caller didn't give one.
+*/
+func ( b *Broker ) host_config( user string, hp string ) ( addr string, cfg *ssh.ClientConfig, alive time.Duration ) {
+	b.cfg_lock.RLock()
+	sc := b.ssh_cfg
+	b.cfg_lock.RUnlock()
+
+	cfg = b.user_config( user )
+	name, port, err := net.SplitHostPort( hp )
+	if sc == nil || err != nil {
+		return hp, cfg, 0
+	}
+
+	ruser := user
+	if ruser == "" {
+		ruser = b.config.User
+	}
+	hc := sc.Lookup( name, ruser )
+
+	if hc.Hostname != "" {
+		name = hc.Hostname
+	}
+	addr = net.JoinHostPort( name, port )
+
+	if (user == "" && hc.User != "") || hc.Connect_timeout > 0 || len( hc.Identity_files ) > 0 {
+		ncfg := *cfg
+		if user == "" && hc.User != "" {
+			ncfg.User = hc.User
+		}
+		if hc.Connect_timeout > 0 {
+			ncfg.Timeout = hc.Connect_timeout
+		}
+		if len( hc.Identity_files ) > 0 {
+			ncfg.Auth = b.auth.with_ids( hc.Identity_files, b.verbose )
+		}
+		cfg = &ncfg
+	}
+
+	return addr, cfg, hc.Alive_interval
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Parse_ssh_config reads the OpenSSH client configuration file. If fname is empty
+	~/.ssh/config is read.
+*/
+func Parse_ssh_config( fname string ) ( sc *Ssh_config, err error ) {
+	if fname == "" {
+		fname = "~/.ssh/config"
+	}
+
+	sc = &Ssh_config{ blocks: []*cfg_block{ &cfg_block{ } } }
+	err = sc.read( expand_home( fname ), 0 )
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+/*
+	Lookup returns the settings for the host (name as given by the user, without user or
+	port).  Ruser is the remote user that will be used if the file doesn't set one and is
+	needed for Match user.
+*/
+func ( sc *Ssh_config ) Lookup( name string, ruser string ) ( hc *Host_cfg ) {
+	hc = &Host_cfg{ }
+	if sc == nil {
+		return
+	}
+
+	seen := make( map[string]bool )
+	for _, blk := range sc.blocks {
+		hostname := name
+		if hc.Hostname != "" {
+			hostname = hc.Hostname
+		}
+		u := ruser
+		if hc.User != "" {
+			u = hc.User
+		}
+
+		if !blk.matches( name, hostname, u ) {
+			continue
+		}
+
+		for _, opt := range blk.opts {
+			key := opt[0]
+			if key == "identityfile" {
+				hc.Identity_files = append( hc.Identity_files, opt[1] )
+				continue
+			}
+
+			if seen[key] {											// first value obtained wins
+				continue
+			}
+			seen[key] = true
+
+			switch key {
+				case "hostname":
+					hc.Hostname = strings.Replace( opt[1], "%h", name, -1 )
+
+				case "user":
+					hc.User = opt[1]
+
+				case "port":
+					hc.Port, _ = strconv.Atoi( opt[1] )
+
+				case "proxyjump":
+					hc.Proxy_jump = strings.Split( opt[1], "," )
+
+				case "connecttimeout":
+					if n, err := strconv.Atoi( opt[1] ); err == nil {
+						hc.Connect_timeout = time.Duration( n ) * time.Second
+					}
+
+				case "serveraliveinterval":
+					if n, err := strconv.Atoi( opt[1] ); err == nil {
+						hc.Alive_interval = time.Duration( n ) * time.Second
+					}
+
+				default:
+					delete( seen, key )								// not one of ours; don't care
+			}
+		}
+	}
+
+	hostname := name
+	if hc.Hostname != "" {
+		hostname = hc.Hostname
+	}
+	u := ruser
+	if hc.User != "" {
+		u = hc.User
+	}
+	port := hc.Port
+	if port == 0 {
+		port = 22
+	}
+	for i, f := range hc.Identity_files {
+		hc.Identity_files[i] = expand_home( expand_tokens( f, hostname, name, port, u ) )
+	}
+
+	return
+}
+
+/*
+	Set_ssh_config reads the OpenSSH client config file and applies its settings to
+	connections established from now on. If fname is empty the config is dropped.
+*/
+func ( b *Broker ) Set_ssh_config( fname string ) ( err error ) {
+	if b == nil {
+		return
+	}
+
+	var sc *Ssh_config
+	if fname != "" {
+		if sc, err = Parse_ssh_config( fname ); err != nil {
+			return
+		}
+	}
+
+	b.cfg_lock.Lock()
+	b.ssh_cfg = sc
+	b.cfg_lock.Unlock()
+
+	return
+}
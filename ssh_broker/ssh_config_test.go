@@ -0,0 +1,191 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	ssh_config_test.go
+	Abstract:	Tests parsing and lookup of an OpenSSH client config file.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const test_ssh_config = `
+# global settings
+ConnectTimeout 7
+
+Host web* !web9
+	HostName %h.example.com
+	User deploy
+	IdentityFile ~/.ssh/%r_key
+
+Host db1
+	Port=2222
+	ProxyJump bastion,jump2
+	User dbadmin
+
+Match host db1 user dbadmin
+	ServerAliveInterval 15
+
+Match exec "true"
+	User never
+
+Host *
+	User fallback
+	IdentityFile "/keys/all key"
+	ConnectTimeout 30
+`
+
+func TestSsh_config( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "sshcfg" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	fname := filepath.Join( dir, "config" )
+	ioutil.WriteFile( fname, []byte( test_ssh_config ), 0600 )
+
+	sc, err := Parse_ssh_config( fname )
+	if err != nil {
+		t.Fatalf( "parse failed: %s", err )
+	}
+
+	hc := sc.Lookup( "web1", "scooter" )
+	if hc.Hostname != "web1.example.com" || hc.User != "deploy" || hc.Connect_timeout != 7 * time.Second {
+		t.Errorf( "web1 settings wrong: %+v", hc )
+	}
+	if len( hc.Identity_files ) != 2 || hc.Identity_files[0] != filepath.Join( os.Getenv( "HOME" ), ".ssh/deploy_key" ) || hc.Identity_files[1] != "/keys/all key" {
+		t.Errorf( "web1 identity files wrong: %q", hc.Identity_files )
+	}
+
+	hc = sc.Lookup( "web9", "scooter" )
+	if hc.Hostname != "" || hc.User != "fallback" {
+		t.Errorf( "negated pattern matched: %+v", hc )
+	}
+
+	hc = sc.Lookup( "db1", "scooter" )
+	if hc.Port != 2222 || hc.User != "dbadmin" || hc.Alive_interval != 15 * time.Second {
+		t.Errorf( "db1 settings wrong: %+v", hc )
+	}
+	if len( hc.Proxy_jump ) != 2 || hc.Proxy_jump[1] != "jump2" {
+		t.Errorf( "db1 proxy jump wrong: %q", hc.Proxy_jump )
+	}
+
+	hc = sc.Lookup( "WEB1", "scooter" )					// host patterns ignore case
+	if hc.User != "deploy" {
+		t.Errorf( "upper case host did not match: %+v", hc )
+	}
+	if hc = sc.Lookup( "Web9", "scooter" ); hc.User != "fallback" {
+		t.Errorf( "negated pattern matched mixed case host: %+v", hc )
+	}
+}
+
+/*
+	Jump hosts given to the broker must win over a ProxyJump from the config.
+*/
+func TestSsh_config_jumps( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "sshcfg" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	fname := filepath.Join( dir, "config" )
+	ioutil.WriteFile( fname, []byte( test_ssh_config ), 0600 )
+
+	sc, err := Parse_ssh_config( fname )
+	if err != nil {
+		t.Fatalf( "parse failed: %s", err )
+	}
+
+	b := &Broker{ config: &ssh.ClientConfig{ User: "scooter" }, ssh_cfg: sc }
+	if chain := b.jump_chain( "db1:22" ); len( chain ) != 2 || chain[0] != "bastion" {
+		t.Errorf( "expected ProxyJump chain with no broker jumps, got %q", chain )
+	}
+
+	b.Set_jump_hosts( "", "gw1" )
+	if chain := b.jump_chain( "db1:22" ); len( chain ) != 1 || chain[0] != "gw1" {
+		t.Errorf( "expected broker default to win over ProxyJump, got %q", chain )
+	}
+
+	b.Set_jump_hosts( "db1", "gw2" )
+	if chain := b.jump_chain( "db1:22" ); len( chain ) != 1 || chain[0] != "gw2" {
+		t.Errorf( "expected host list to win, got %q", chain )
+	}
+
+	b.Set_jump_hosts( "db1" )
+	if chain := b.jump_chain( "db1:22" ); len( chain ) != 0 {
+		t.Errorf( "expected empty host list to connect directly, got %q", chain )
+	}
+
+	b.Rm_jump_hosts( "db1" )
+	b.Rm_jump_hosts( "" )
+	if chain := b.jump_chain( "web1:22" ); len( chain ) != 0 {
+		t.Errorf( "expected no jumps for web1, got %q", chain )
+	}
+}
+
+/*
+	The config's Port applies only when the caller gave no port; an explicit :22 is kept.
+*/
+func TestSsh_config_port( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "sshcfg" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	fname := filepath.Join( dir, "config" )
+	ioutil.WriteFile( fname, []byte( test_ssh_config ), 0600 )
+
+	sc, err := Parse_ssh_config( fname )
+	if err != nil {
+		t.Fatalf( "parse failed: %s", err )
+	}
+
+	b := &Broker{ config: &ssh.ClientConfig{ User: "scooter" }, ssh_cfg: sc, auth: &auth_info{ } }
+	for _, tc := range [][2]string{
+		{ "db1", "db1:2222" },
+		{ "dbadmin@db1", "dbadmin@db1:2222" },
+		{ "db1:22", "db1:22" },
+		{ "db1:2200", "db1:2200" },
+		{ "web1", "web1:22" },
+	} {
+		if got := b.host_port( tc[0] ); got != tc[1] {
+			t.Errorf( "host_port( %s ): expected %s, got %s", tc[0], tc[1], got )
+		}
+	}
+
+	if addr, _, _ := b.host_config( "", "db1:22" ); addr != "db1:22" {
+		t.Errorf( "explicit port 22 replaced by the config's port: %s", addr )
+	}
+	if addr, _, _ := b.host_config( "", b.host_port( "db1" ) ); addr != "db1:2222" {
+		t.Errorf( "config port not applied: %s", addr )
+	}
+}
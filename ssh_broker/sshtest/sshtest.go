@@ -0,0 +1,637 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	sshtest.go
+	Abstract: 	An in-process ssh server for testing the broker without a real remote host.
+	Date: 		19 October 2026
+*/
+
+/*
+	Package sshtest provides an ssh server which listens on a loopback port and runs
+	commands using a handler supplied by the test: either Exec_handler, which really runs
+	the command with /bin/sh, or a Script_handler which returns canned replies.  The sftp
+	subsystem is served from the local filesystem, keepalive requests are answered, local
+	and remote port forwarding are supported, and a kill signal cancels the command's
+	context.
+
+	Users may be authenticated by public key and/or password; if neither is configured
+	any user is accepted without authentication.  A limit on the number of sessions open
+	at once on a connection can be set, causing further sessions to be rejected as
+	"administratively prohibited" as some sshd configurations do, and Drop_conns() closes
+	all connections to simulate a network failure or a rebooted host.
+
+	Basic usage:
+
+		srv, err := sshtest.Mk_server( &sshtest.Server_opts{ Handler: sshtest.Exec_handler } )
+		defer srv.Close()
+		host := srv.Addr()			// 127.0.0.1:port
+*/
+package sshtest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	A command to be run by a handler.  Ctx is cancelled if the client sends a kill signal
+	or the session is closed.
+*/
+type Cmd struct {
+	User	string
+	Cmd		string
+	Env		[]string				// name=value pairs from env requests
+	Pty		bool					// a pty was requested
+	Term	string					// terminal type given with the pty request
+	Ctx		context.Context
+	Stdin	io.Reader
+	Stdout	io.Writer
+	Stderr	io.Writer
+}
+
+/*
+	Runs the command and returns its exit status.  A negative status causes the session to
+	be closed without an exit status (as though the connection failed).
+*/
+type Handler func( c *Cmd ) ( status int )
+
+/*
+	A canned reply for Script_handler.
+*/
+type Reply struct {
+	Stdout	string
+	Stderr	string
+	Status	int
+	Delay	time.Duration			// wait this long (or until killed) before replying
+}
+
+/*
+	Options for Mk_server.
+*/
+type Server_opts struct {
+	Authorized	map[string][]ssh.PublicKey	// user -> keys accepted for the user
+	Passwords	map[string]string			// user -> password
+	Handler		Handler						// runs exec requests; nil rejects them
+	Max_sessions int						// sessions allowed open on a connection at once (0 == no limit)
+}
+
+/*
+	The server.
+*/
+type Server struct {
+	opts		Server_opts
+	config		*ssh.ServerConfig
+	listener	net.Listener
+	host_key	ssh.Signer
+
+	lock		sync.Mutex
+	conns		map[net.Conn]bool		// open network connections
+	nconns		int						// connections accepted
+	ncmds		int						// commands run
+	wg			sync.WaitGroup
+}
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Serve one network connection.
+*/
+func ( s *Server ) serve( nc net.Conn ) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete( s.conns, nc )
+		s.lock.Unlock()
+		nc.Close()
+	}()
+
+	sc, chans, reqs, err := ssh.NewServerConn( nc, s.config )
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+
+	go s.global( sc, reqs )
+
+	nopen := 0
+	olock := sync.Mutex{}
+	for nch := range chans {
+		if nch.ChannelType() == "direct-tcpip" {
+			go direct( nch )
+			continue
+		}
+
+		if nch.ChannelType() != "session" {
+			nch.Reject( ssh.UnknownChannelType, "unsupported channel type" )
+			continue
+		}
+
+		olock.Lock()
+		if s.opts.Max_sessions > 0 && nopen >= s.opts.Max_sessions {
+			olock.Unlock()
+			nch.Reject( ssh.Prohibited, "too many sessions" )
+			continue
+		}
+		nopen++
+		olock.Unlock()
+
+		ch, creqs, err := nch.Accept()
+		if err != nil {
+			olock.Lock()
+			nopen--
+			olock.Unlock()
+			continue
+		}
+
+		go func() {
+			s.session( sc.User(), ch, creqs )
+			olock.Lock()
+			nopen--
+			olock.Unlock()
+		}()
+	}
+}
+
+/*
+	Copy in both directions until either side finishes.
+*/
+func pipe( ch ssh.Channel, nc net.Conn ) {
+	done := make( chan bool, 2 )
+	go func() {
+		io.Copy( ch, nc )
+		ch.CloseWrite()
+		done <- true
+	}()
+	go func() {
+		io.Copy( nc, ch )
+		done <- true
+	}()
+
+	<- done
+	ch.Close()
+	nc.Close()
+	<- done
+}
+
+/*
+	Connect a direct-tcpip channel (local forward) to the address requested.
+*/
+func direct( nch ssh.NewChannel ) {
+	var dest struct {
+		Host	string
+		Port	uint32
+		Ohost	string
+		Oport	uint32
+	}
+	if err := ssh.Unmarshal( nch.ExtraData(), &dest ); err != nil {
+		nch.Reject( ssh.ConnectionFailed, "bad request" )
+		return
+	}
+
+	nc, err := net.Dial( "tcp", net.JoinHostPort( dest.Host, fmt.Sprintf( "%d", dest.Port ) ) )
+	if err != nil {
+		nch.Reject( ssh.ConnectionFailed, err.Error() )
+		return
+	}
+
+	ch, reqs, err := nch.Accept()
+	if err != nil {
+		nc.Close()
+		return
+	}
+	go ssh.DiscardRequests( reqs )
+
+	pipe( ch, nc )
+}
+
+/*
+	Handle global requests on the connection: keepalives and remote forwarding
+	(tcpip-forward).  Remote listeners are closed when the connection ends.
+*/
+func ( s *Server ) global( sc *ssh.ServerConn, reqs <-chan *ssh.Request ) {
+	listeners := make( map[string]net.Listener )
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+
+	for r := range reqs {
+		var fwd struct {
+			Addr	string
+			Port	uint32
+		}
+
+		switch r.Type {
+			case "keepalive@openssh.com":
+				r.Reply( true, nil )
+
+			case "tcpip-forward":
+				if ssh.Unmarshal( r.Payload, &fwd ) != nil {
+					r.Reply( false, nil )
+					continue
+				}
+				l, err := net.Listen( "tcp", net.JoinHostPort( fwd.Addr, fmt.Sprintf( "%d", fwd.Port ) ) )
+				if err != nil {
+					r.Reply( false, nil )
+					continue
+				}
+				port := uint32( l.Addr().( *net.TCPAddr ).Port )
+				listeners[fmt.Sprintf( "%s:%d", fwd.Addr, port )] = l
+
+				pb := make( []byte, 4 )
+				binary.BigEndian.PutUint32( pb, port )
+				r.Reply( true, pb )
+
+				go func( addr string, port uint32 ) {
+					for {
+						nc, err := l.Accept()
+						if err != nil {
+							return
+						}
+
+						ra := nc.RemoteAddr().( *net.TCPAddr )
+						payload := ssh.Marshal( &struct {
+							Addr	string
+							Port	uint32
+							Oaddr	string
+							Oport	uint32
+						}{ addr, port, ra.IP.String(), uint32( ra.Port ) } )
+
+						ch, creqs, err := sc.OpenChannel( "forwarded-tcpip", payload )
+						if err != nil {
+							nc.Close()
+							continue
+						}
+						go ssh.DiscardRequests( creqs )
+						go pipe( ch, nc )
+					}
+				}( fwd.Addr, port )
+
+			case "cancel-tcpip-forward":
+				if ssh.Unmarshal( r.Payload, &fwd ) == nil {
+					key := fmt.Sprintf( "%s:%d", fwd.Addr, fwd.Port )
+					if l := listeners[key]; l != nil {
+						l.Close()
+						delete( listeners, key )
+					}
+				}
+				r.Reply( true, nil )
+
+			default:
+				if r.WantReply {
+					r.Reply( false, nil )
+				}
+		}
+	}
+}
+
+/*
+	Handle the requests on a session channel until the command (or subsystem) completes.
+*/
+func ( s *Server ) session( user string, ch ssh.Channel, reqs <-chan *ssh.Request ) {
+	defer ch.Close()
+
+	ctx, cancel := context.WithCancel( context.Background() )
+	defer cancel()
+
+	env := []string{ }
+	done := make( chan int, 1 )
+	started := false
+	pty := false
+	term := ""
+	for {
+		select {
+			case status := <- done:
+				if status >= 0 {
+					sb := make( []byte, 4 )
+					binary.BigEndian.PutUint32( sb, uint32( status ) )
+					ch.SendRequest( "exit-status", false, sb )
+				}
+				return
+
+			case r, ok := <- reqs:
+				if !ok {
+					cancel()
+					if started {
+						<- done
+					}
+					return
+				}
+
+				switch r.Type {
+					case "env":
+						var kv struct { Name, Value string }
+						if ssh.Unmarshal( r.Payload, &kv ) == nil {
+							env = append( env, kv.Name + "=" + kv.Value )
+						}
+						r.Reply( true, nil )
+
+					case "pty-req":
+						var preq struct { Term string; Rest []byte `ssh:"rest"` }
+						if ssh.Unmarshal( r.Payload, &preq ) != nil {
+							r.Reply( false, nil )
+							continue
+						}
+						pty = true
+						term = preq.Term
+						r.Reply( true, nil )
+
+					case "window-change":
+						if r.WantReply {
+							r.Reply( true, nil )
+						}
+
+					case "exec", "shell":
+						var cmd struct { Command string }
+						if started || s.opts.Handler == nil || (r.Type == "exec" && ssh.Unmarshal( r.Payload, &cmd ) != nil) {
+							r.Reply( false, nil )
+							continue
+						}
+						r.Reply( true, nil )
+						started = true
+
+						s.lock.Lock()
+						s.ncmds++
+						s.lock.Unlock()
+
+						c := &Cmd{ User: user, Cmd: cmd.Command, Env: env, Pty: pty, Term: term, Ctx: ctx, Stdin: ch, Stdout: ch, Stderr: ch.Stderr() }
+						if pty {
+							c.Stderr = ch						// a pty merges the streams
+						}
+						go func() {
+							done <- s.opts.Handler( c )
+						}()
+
+					case "subsystem":
+						var sub struct { Name string }
+						if started || ssh.Unmarshal( r.Payload, &sub ) != nil || sub.Name != "sftp" {
+							r.Reply( false, nil )
+							continue
+						}
+						r.Reply( true, nil )
+						started = true
+
+						go func() {
+							srv, err := sftp.NewServer( ch )
+							if err == nil {
+								srv.Serve()
+								srv.Close()
+							}
+							done <- 0
+						}()
+
+					case "signal":
+						cancel()								// any signal kills the command
+
+					default:
+						if r.WantReply {
+							r.Reply( false, nil )
+						}
+				}
+		}
+	}
+}
+
+// ----- public ------------------------------------------------------------------------------------
+
+/*
+	Mk_server starts a server listening on a loopback port.
+*/
+func Mk_server( opts *Server_opts ) ( s *Server, err error ) {
+	if opts == nil {
+		opts = &Server_opts{ }
+	}
+
+	s = &Server{ opts: *opts, conns: make( map[net.Conn]bool ) }
+
+	_, pk, err := ed25519.GenerateKey( rand.Reader )
+	if err != nil {
+		return nil, err
+	}
+	if s.host_key, err = ssh.NewSignerFromKey( pk ); err != nil {
+		return nil, err
+	}
+
+	s.config = &ssh.ServerConfig{ }
+	if opts.Authorized == nil && opts.Passwords == nil {
+		s.config.NoClientAuth = true
+	}
+	if opts.Authorized != nil {
+		s.config.PublicKeyCallback = func( cm ssh.ConnMetadata, key ssh.PublicKey ) ( *ssh.Permissions, error ) {
+			kb := key.Marshal()
+			for _, ak := range opts.Authorized[cm.User()] {
+				if string( ak.Marshal() ) == string( kb ) {
+					return nil, nil
+				}
+			}
+			return nil, fmt.Errorf( "key not authorised for %s", cm.User() )
+		}
+	}
+	if opts.Passwords != nil {
+		s.config.PasswordCallback = func( cm ssh.ConnMetadata, pw []byte ) ( *ssh.Permissions, error ) {
+			if want, ok := opts.Passwords[cm.User()]; ok && want == string( pw ) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf( "bad password for %s", cm.User() )
+		}
+	}
+	s.config.AddHostKey( s.host_key )
+
+	if s.listener, err = net.Listen( "tcp", "127.0.0.1:0" ); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for {
+			nc, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			s.lock.Lock()
+			s.conns[nc] = true
+			s.nconns++
+			s.lock.Unlock()
+
+			s.wg.Add( 1 )
+			go s.serve( nc )
+		}
+	}()
+
+	return
+}
+
+/*
+	Addr returns the address (127.0.0.1:port) the server is listening on.
+*/
+func ( s *Server ) Addr( ) ( string ) {
+	return s.listener.Addr().String()
+}
+
+/*
+	Host_key returns the server's public host key.
+*/
+func ( s *Server ) Host_key( ) ( ssh.PublicKey ) {
+	return s.host_key.PublicKey()
+}
+
+/*
+	Drop_conns closes all open connections. The server continues to accept new ones.
+*/
+func ( s *Server ) Drop_conns( ) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for nc := range s.conns {
+		nc.Close()
+	}
+}
+
+/*
+	Nconns returns the number of connections accepted since the server was started.
+*/
+func ( s *Server ) Nconns( ) ( int ) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.nconns
+}
+
+/*
+	Ncmds returns the number of commands run.
+*/
+func ( s *Server ) Ncmds( ) ( int ) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.ncmds
+}
+
+/*
+	Close stops the server and closes all connections.
+*/
+func ( s *Server ) Close( ) {
+	s.listener.Close()
+	s.Drop_conns()
+	s.wg.Wait()
+}
+
+/*
+	Exec_handler runs the command with /bin/sh -c (or /bin/sh reading standard input for
+	a shell request) in the current directory with the environment of the test process
+	plus any sent by the client, and TERM if a pty was requested.  No real pty is used.
+*/
+func Exec_handler( c *Cmd ) ( status int ) {
+	cmd := exec.CommandContext( c.Ctx, "/bin/sh", "-c", c.Cmd )
+	if c.Cmd == "" {
+		cmd = exec.CommandContext( c.Ctx, "/bin/sh" )			// shell request: commands come from stdin
+	}
+	cmd.Env = append( os.Environ(), c.Env... )
+	if c.Term != "" {
+		cmd.Env = append( cmd.Env, "TERM=" + c.Term )
+	}
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+
+	err := cmd.Run()
+	if err == nil {
+		return 0
+	}
+	if ee, ok := err.( *exec.ExitError ); ok && ee.ExitCode() >= 0 {
+		return ee.ExitCode()
+	}
+	if c.Ctx.Err() != nil {
+		return 137							// killed
+	}
+
+	fmt.Fprintf( c.Stderr, "sshtest: %s\n", err )
+	return 127
+}
+
+/*
+	Script_handler returns a handler which replies to each command from the map.  Commands
+	not in the map exit with 127.  Standard input is read and discarded.
+*/
+func Script_handler( replies map[string]*Reply ) ( Handler ) {
+	return func( c *Cmd ) ( int ) {
+		go io.Copy( ioutil.Discard, c.Stdin )
+
+		r := replies[c.Cmd]
+		if r == nil {
+			fmt.Fprintf( c.Stderr, "sshtest: %s: command not found\n", c.Cmd )
+			return 127
+		}
+
+		if r.Delay > 0 {
+			select {
+				case <- time.After( r.Delay ):
+
+				case <- c.Ctx.Done():
+					return 137
+			}
+		}
+
+		io.WriteString( c.Stdout, r.Stdout )
+		io.WriteString( c.Stderr, r.Stderr )
+		return r.Status
+	}
+}
+
+/*
+	Mk_key_file generates an ed25519 key pair, writes the private key to the file in
+	OpenSSH format, and returns the public key (for Server_opts.Authorized).
+*/
+func Mk_key_file( fname string ) ( pub ssh.PublicKey, err error ) {
+	_, pk, err := ed25519.GenerateKey( rand.Reader )
+	if err != nil {
+		return
+	}
+
+	pb, err := ssh.MarshalPrivateKey( pk, "" )
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile( fname, pem.EncodeToMemory( pb ), 0600 ); err != nil {
+		return
+	}
+
+	signer, err := ssh.NewSignerFromKey( pk )
+	if err != nil {
+		return
+	}
+
+	return signer.PublicKey(), nil
+}
@@ -0,0 +1,111 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	sshtest_test.go
+	Abstract:	Tests the server with a plain ssh client.
+	Date:		19 October 2026
+*/
+
+package sshtest
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServer( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "sshtest" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	kf := filepath.Join( dir, "id" )
+	pub, err := Mk_key_file( kf )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	srv, err := Mk_server( &Server_opts{
+		Authorized: map[string][]ssh.PublicKey{ "scooter": { pub } },
+		Handler: Script_handler( map[string]*Reply{ "hostname": { Stdout: "fake\n" }, "false": { Status: 1 } } ),
+		Max_sessions: 1,
+	} )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer srv.Close()
+
+	if _, err = ssh.Dial( "tcp", srv.Addr(), &ssh.ClientConfig{ User: "scooter", HostKeyCallback: ssh.FixedHostKey( srv.Host_key() ) } ); err == nil {
+		t.Errorf( "connection without a key was accepted" )
+	}
+
+	ab, _ := ssh.ParsePrivateKey( must_read( t, kf ) )
+	client, err := ssh.Dial( "tcp", srv.Addr(), &ssh.ClientConfig{ User: "scooter", Auth: []ssh.AuthMethod{ ssh.PublicKeys( ab ) }, HostKeyCallback: ssh.FixedHostKey( srv.Host_key() ) } )
+	if err != nil {
+		t.Fatalf( "dial failed: %s", err )
+	}
+	defer client.Close()
+
+	s1, err := client.NewSession()
+	if err != nil {
+		t.Fatal( err )
+	}
+	if _, err = client.NewSession(); err == nil || !strings.Contains( err.Error(), "administratively prohibited" ) {
+		t.Errorf( "session limit not enforced: %v", err )
+	}
+
+	out, err := s1.Output( "hostname" )
+	if err != nil || string( out ) != "fake\n" {
+		t.Errorf( "unexpected output: %q %v", out, err )
+	}
+
+	s2, err := client.NewSession()				// first has closed so this is allowed
+	if err != nil {
+		t.Fatalf( "second session rejected: %s", err )
+	}
+	var ee *ssh.ExitError
+	if err = s2.Run( "false" ); !errors.As( err, &ee ) || ee.ExitStatus() != 1 {
+		t.Errorf( "expected exit status 1: %v", err )
+	}
+
+	srv.Drop_conns()
+	if _, err = client.NewSession(); err == nil {
+		t.Errorf( "session allowed after connections dropped" )
+	}
+	if srv.Nconns() != 2 || srv.Ncmds() != 2 {
+		t.Errorf( "unexpected counts: %d conns %d cmds", srv.Nconns(), srv.Ncmds() )
+	}
+}
+
+func must_read( t *testing.T, fname string ) ( []byte ) {
+	b, err := ioutil.ReadFile( fname )
+	if err != nil {
+		t.Fatal( err )
+	}
+
+	return b
+}
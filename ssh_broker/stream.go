@@ -0,0 +1,271 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	stream.go
+	Abstract: 	Streaming output support and the *_opts run functions. Rather than buffering
+				all standard output and error in the request until the command completes,
+				a request may be given a stream sink; each line is delivered to the sink
+				(a channel or writer) as soon as it is received, tagged with the host,
+				the request id and the stream it was written to.  The final result (error
+				and elapsed time) is still returned as a Broker_msg.
+
+				A streamed request which fails after some of its output was sent to the
+				sink is not retried (as other requests would be) so that the sink never
+				sees output from more than one attempt.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	STDOUT	string = "stdout"
+	STDERR	string = "stderr"
+)
+
+/*
+	A single line of output from a remote command.  The newline is not included.
+*/
+type Output_line struct {
+	Host	string
+	Id		int
+	Stream	string				// STDOUT or STDERR
+	Line	string
+}
+
+/*
+	Stream_sink defines where output lines are sent. If Ch is not nil lines are written to
+	the channel (the caller must read it promptly as the remote command is blocked while
+	the channel is full).  If W is not nil each line is written as "host id stream: line".
+	If Keep is true output is also buffered in the request as it is without streaming.
+
+	A sink may be shared by many requests.
+*/
+type Stream_sink struct {
+	Ch		chan *Output_line
+	W		io.Writer
+	Keep	bool
+
+	wlock	sync.Mutex			// serialises writes to W from concurrent sessions
+}
+
+/*
+	Options for the *_opts run functions.
+*/
+type Run_opts struct {
+	Ctx		context.Context		// deadline/cancellation for the command (see Run_cmd_ctx)
+	Stream	*Stream_sink		// output is streamed to the sink rather than buffered
+	Verbatim bool				// scripts are uploaded unaltered and executed (see verbatim.go)
+	Stdin	io.Reader			// standard input for a command or verbatim script; read by one request only
+	Remote_tmp string			// remote directory for verbatim scripts; /tmp if empty
+	Pty		*Pty_opts			// request a pseudo terminal (see interactive.go); scripts must be run Verbatim
+	Env		map[string]string	// environment variables to set (the server must accept them)
+}
+
+/*
+	Writer given to the ssh session which breaks output into lines for the sink.
+*/
+type line_writer struct {
+	sink	*Stream_sink
+	host	string
+	id		int
+	stream	string
+	partial	[]byte				// unterminated line waiting for the rest
+}
+
+// --------------------------------------------------------------------------------------------------
+
+func ( lw *line_writer ) send( line []byte ) {
+	ol := &Output_line{ Host: lw.host, Id: lw.id, Stream: lw.stream, Line: string( line ) }
+
+	if lw.sink.Ch != nil {
+		lw.sink.Ch <- ol
+	}
+
+	if lw.sink.W != nil {
+		lw.sink.wlock.Lock()
+		fmt.Fprintf( lw.sink.W, "%s %d %s: %s\n", ol.Host, ol.Id, ol.Stream, ol.Line )
+		lw.sink.wlock.Unlock()
+	}
+}
+
+func ( lw *line_writer ) Write( buf []byte ) ( int, error ) {
+	n := len( buf )
+
+	for len( buf ) > 0 {
+		nl := bytes.IndexByte( buf, '\n' )
+		if nl < 0 {
+			lw.partial = append( lw.partial, buf... )
+			break
+		}
+
+		if len( lw.partial ) > 0 {
+			lw.send( append( lw.partial, buf[:nl]... ) )
+			lw.partial = lw.partial[:0]
+		} else {
+			lw.send( buf[:nl] )
+		}
+		buf = buf[nl+1:]
+	}
+
+	return n, nil
+}
+
+/*
+	Send any unterminated last line.
+*/
+func ( lw *line_writer ) flush( ) {
+	if len( lw.partial ) > 0 {
+		lw.send( lw.partial )
+		lw.partial = nil
+	}
+}
+
+/*
+	Return the writers for the session's standard output and error, and a function that
+	must be called after the command completes to flush partial lines.
+*/
+func ( req *Broker_msg ) out_writers( ) ( stdout io.Writer, stderr io.Writer, flush func() ) {
+	cwo := &count_writer{ w: &req.stdout, n: &req.nout }		// bytes are counted for the audit record
+	cwe := &count_writer{ w: &req.stderr, n: &req.nerr }
+	if req.stream == nil {
+		return cwo, cwe, func() {}
+	}
+
+	lwo := &line_writer{ sink: req.stream, host: req.host, id: req.id, stream: STDOUT }
+	lwe := &line_writer{ sink: req.stream, host: req.host, id: req.id, stream: STDERR }
+	cwo.w = lwo
+	cwe.w = lwe
+	if req.stream.Keep {
+		cwo.w = io.MultiWriter( &req.stdout, lwo )
+		cwe.w = io.MultiWriter( &req.stderr, lwe )
+	}
+
+	return cwo, cwe, func() { lwo.flush(); lwe.flush() }
+}
+
+/*
+	Copy the options into the request.
+*/
+func ( req *Broker_msg ) apply( opts *Run_opts ) {
+	if opts != nil {
+		req.ctx = opts.Ctx
+		req.stream = opts.Stream
+		req.verbatim = opts.Verbatim
+		req.stdin = opts.Stdin
+		req.tmp_dir = opts.Remote_tmp
+		req.pty = opts.Pty
+		req.envars = opts.Env
+	}
+}
+
+// ------------ public broker functions ---------------------------------------------------------------------
+
+/*
+	Run_on_host_opts executes the script on the remote host using the options given and blocks
+	until it completes. The request message is returned; use its Get_* functions for the results.
+*/
+func ( b *Broker ) Run_on_host_opts( host string, script string, parms string, env_file string, opts *Run_opts ) ( msg *Broker_msg, err error ) {
+	if b == nil || b.was_closed {
+		return nil, fmt.Errorf( "run_on_host: broker pointer was nil, or broker has been closed" )
+	}
+
+	msg = &Broker_msg {
+		host: 	host,
+		sname:	script,
+		env:	env_file,
+		parms:	parms,
+	}
+	msg.apply( opts )
+
+	_, _, err = b.run_req( msg )
+	return
+}
+
+/*
+	NBRun_on_host_opts queues the script for execution using the options given. The result
+	message is written to uch (if not nil) when the script completes.
+*/
+func ( b *Broker ) NBRun_on_host_opts( host string, script string, parms string, env_file string, uid int, uch chan *Broker_msg, opts *Run_opts ) ( err error ) {
+	if b == nil || b.was_closed {
+		return fmt.Errorf( "nbrun_on_host: broker pointer was nil, or broker has been closed" )
+	}
+
+	req := &Broker_msg {
+		host: 	host,
+		sname:	script,
+		env:	env_file,
+		parms:	parms,
+		id:		uid,
+		resp_ch:	uch,
+	}
+	req.apply( opts )
+
+	b.init_ch <- req						// send request to initiator queue
+	return
+}
+
+/*
+	Run_cmd_opts runs the command on the remote host using the options given and blocks
+	until it completes. The request message is returned; use its Get_* functions for the results.
+*/
+func ( b *Broker ) Run_cmd_opts( host string, cmd string, opts *Run_opts ) ( msg *Broker_msg, err error ) {
+	if b == nil || b.was_closed {
+		return nil, fmt.Errorf( "run_cmd: broker pointer was nil, or broker has been closed" )
+	}
+
+	msg = &Broker_msg {
+		host: 	host,
+		cmd:	cmd,
+	}
+	msg.apply( opts )
+
+	_, _, err = b.run_req( msg )
+	return
+}
+
+/*
+	NBRun_cmd_opts queues the command for execution using the options given. The result
+	message is written to uch (if not nil) when the command completes.
+*/
+func ( b *Broker ) NBRun_cmd_opts( host string, cmd string, uid int, uch chan *Broker_msg, opts *Run_opts ) ( err error ) {
+	if b == nil || b.was_closed {
+		return fmt.Errorf( "nbrun_cmd: broker pointer was nil, or broker has been closed" )
+	}
+
+	req := &Broker_msg {
+		host: 	host,
+		cmd:	cmd,
+		id:		uid,
+		resp_ch:	uch,
+	}
+	req.apply( opts )
+
+	b.init_ch <- req						// send request to initiator queue
+	return
+}
@@ -0,0 +1,87 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	stream_test.go
+	Abstract:	Tests splitting of output into lines for a stream sink, and that streamed
+				requests aren't retried once output has been sent.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestLineWriter( t *testing.T ) {
+	out := bytes.NewBufferString( "" )
+	ch := make( chan *Output_line, 10 )
+	req := &Broker_msg{ host: "cheetah:22", id: 7, stream: &Stream_sink{ Ch: ch, W: out, Keep: true } }
+
+	stdout, _, flush := req.out_writers()
+	stdout.Write( []byte( "first line\nsec" ) )
+	stdout.Write( []byte( "ond line\nno newline" ) )
+	flush()
+
+	close( ch )
+	lines := []string{ }
+	for ol := range ch {
+		if ol.Host != "cheetah:22" || ol.Id != 7 || ol.Stream != STDOUT {
+			t.Errorf( "bad tagging: %+v", ol )
+		}
+		lines = append( lines, ol.Line )
+	}
+
+	if len( lines ) != 3 || lines[0] != "first line" || lines[1] != "second line" || lines[2] != "no newline" {
+		t.Errorf( "unexpected lines: %q", lines )
+	}
+
+	if out.String() != "cheetah:22 7 stdout: first line\ncheetah:22 7 stdout: second line\ncheetah:22 7 stdout: no newline\n" {
+		t.Errorf( "unexpected writer output: %q", out.String() )
+	}
+
+	if req.stdout.String() != "first line\nsecond line\nno newline" {
+		t.Errorf( "output not kept: %q", req.stdout.String() )
+	}
+}
+
+func TestStream_retry( t *testing.T ) {
+	ch := make( chan *Output_line, 10 )
+	req := &Broker_msg{ host: "cheetah:22", stream: &Stream_sink{ Ch: ch } }
+	req.err = fmt.Errorf( "ssh: rejected: administratively prohibited (too many sessions)" )
+	req.fail = FAIL_TRANSPORT
+	if !req.retryable() {
+		t.Fatalf( "streamed request with no output should be retryable" )
+	}
+
+	stdout, _, flush := req.out_writers()
+	stdout.Write( []byte( "partial output\n" ) )
+	flush()
+	if req.retryable() {
+		t.Errorf( "streamed request retryable after its output was sent to the sink" )
+	}
+
+	req.stream = nil
+	if !req.retryable() {
+		t.Errorf( "unstreamed request should be retryable; its buffered output is reset" )
+	}
+}
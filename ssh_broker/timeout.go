@@ -0,0 +1,276 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	timeout.go
+	Abstract: 	Support for per-command deadlines and cancellation. Each request may carry
+				a context; when the context is done (deadline passed or cancelled by the
+				caller) the remote command is sent a kill signal and the session is closed
+				which frees the initiator.  The broker may also have a default timeout
+				which is applied to requests whose context has no deadline.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+/*
+	Max time we wait for a session to finish after it has been killed and closed.  If the
+	remote side doesn't respond in this time we assume the connection is dead.
+*/
+const kill_wait = 5 * time.Second
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Return the context to use for the request and a cancel function which must be
+	called when the request has finished.
+*/
+func ( b *Broker ) req_ctx( req *Broker_msg ) ( ctx context.Context, cancel context.CancelFunc ) {
+	ctx = req.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	b.cfg_lock.RLock()
+	timeout := b.cmd_timeout
+	b.cfg_lock.RUnlock()
+
+	if timeout > 0 {
+		if _, has_dl := ctx.Deadline(); !has_dl {
+			return context.WithTimeout( ctx, timeout )
+		}
+	}
+
+	return ctx, func() {}
+}
+
+/*
+	Start the command on the session and wait for it to finish, or for the request's
+	context to be done.  When the context finishes first the remote process is signalled,
+	the session closed and the request marked as timed out (or cancelled).
+*/
+func ( b *Broker ) run( req *Broker_msg, sess *ssh.Session, cmd string ) ( err error ) {
+	ctx, cancel := b.req_ctx( req )
+	defer cancel()
+
+	if err = ctx.Err(); err != nil {				// expired while queued; don't bother
+		return b.ctx_err( req, ctx )
+	}
+
+	err = sess.Start( cmd )
+	if err != nil {
+		return
+	}
+	req.started = true
+
+	done := make( chan error, 1 )
+	go func() {
+		done <- sess.Wait()
+	}()
+
+	select {
+		case err = <- done:
+			return
+
+		case <- ctx.Done():
+	}
+
+	sess.Signal( ssh.SIGKILL )					// not all servers honour signals, so close too
+	sess.Close()
+
+	select {
+		case <- done:
+
+		case <- time.After( kill_wait ):			// remote is not responding; assume the connection is bad
+			if b.verbose {
+				fmt.Fprintf( os.Stderr, "ssh_broker: session to %s did not close after kill; dropping connection\n", req.host )
+			}
+			b.drop_conn( req.host )
+			<- done										// closing the connection guarantees wait returns
+	}
+
+	return b.ctx_err( req, ctx )
+}
+
+/*
+	Mark the request and build the error returned when the context finished first.
+*/
+func ( b *Broker ) ctx_err( req *Broker_msg, ctx context.Context ) ( error ) {
+	if ctx.Err() == context.DeadlineExceeded {
+		req.timed_out = true
+		return fmt.Errorf( "command timed out on %s: %w", req.host, ctx.Err() )
+	}
+
+	req.cancelled = true
+	return fmt.Errorf( "command cancelled on %s: %w", req.host, ctx.Err() )
+}
+
+/*
+	Mark the connection to the host as inactive and close it.
+*/
+func ( b *Broker ) drop_conn( host string ) {
+	if c := b.get_conn( host ); c != nil {
+		c.drop( nil )
+	}
+}
+
+/*
+	Queue the request and wait for the response.
+*/
+func ( b *Broker ) run_req( req *Broker_msg ) ( stdout *bytes.Buffer, stderr *bytes.Buffer, err error ) {
+	req.resp_ch = make( chan *Broker_msg )			// we'll listen on this channel for response
+													// do NOT close the channel as we aren't sending
+	b.init_ch <- req						// send request to initiator queue
+	req = <- req.resp_ch					// wait on the response
+	stdout = &req.stdout
+	stderr = &req.stderr
+	err = req.err
+	req.resp_ch = nil
+
+	return
+}
+
+// ----- public msg functions ------------------------------------------------------------------------------
+
+/*
+	Timed_out returns true if the request was stopped because its deadline passed.
+*/
+func ( m *Broker_msg ) Timed_out( ) ( bool ) {
+	return m != nil && m.timed_out
+}
+
+/*
+	Cancelled returns true if the request was stopped because the caller cancelled its context.
+*/
+func ( m *Broker_msg ) Cancelled( ) ( bool ) {
+	return m != nil && m.cancelled
+}
+
+// ------------ public broker functions ---------------------------------------------------------------------
+
+/*
+	Set_cmd_timeout sets the default maximum time that a script or command is allowed to run.
+	It is applied to every request whose context does not have a deadline.  A value of zero
+	(the default) allows commands to run forever.
+*/
+func ( b *Broker ) Set_cmd_timeout( d time.Duration ) {
+	if b != nil {
+		b.cfg_lock.Lock()
+		b.cmd_timeout = d
+		b.cfg_lock.Unlock()
+	}
+}
+
+/*
+	Run_on_host_ctx is the same as Run_on_host except that the remote script is killed if the
+	context is cancelled, or its deadline passes, before the script completes.
+*/
+func ( b *Broker ) Run_on_host_ctx( ctx context.Context, host string, script string, parms string, env_file string ) ( stdout *bytes.Buffer, stderr *bytes.Buffer, err error ) {
+	if b == nil || b.was_closed {
+		err = fmt.Errorf( "run_on_host: broker pointer was nil, or broker has been closed" )
+		return
+	}
+
+	req := &Broker_msg {
+		host: 	host,
+		sname:	script,
+		env:	env_file,
+		parms:	parms,
+		ctx:	ctx,
+	}
+
+	return b.run_req( req )
+}
+
+/*
+	NBRun_on_host_ctx is the same as NBRun_on_host except that the remote script is killed if the
+	context is cancelled, or its deadline passes, before the script completes.
+*/
+func ( b *Broker ) NBRun_on_host_ctx( ctx context.Context, host string, script string, parms string,  uid int, uch chan *Broker_msg  ) ( err error ) {
+	if b == nil || b.was_closed {
+		err = fmt.Errorf( "nbrun_on_host: broker pointer was nil, or broker has been closed" )
+		return
+	}
+
+	req := &Broker_msg {
+		host: 	host,
+		sname:	script,
+		parms:	parms,
+		id:		uid,
+		resp_ch:	uch,
+		ctx:	ctx,
+	}
+
+	b.init_ch <- req						// send request to initiator queue
+
+	return
+}
+
+/*
+	Run_cmd_ctx is the same as Run_cmd except that the remote command is killed if the
+	context is cancelled, or its deadline passes, before the command completes.
+*/
+func ( b *Broker ) Run_cmd_ctx( ctx context.Context, host string, cmd string ) ( stdout *bytes.Buffer, stderr *bytes.Buffer, err error ) {
+	if b == nil || b.was_closed {
+		err = fmt.Errorf( "run_cmd: broker pointer was nil, or broker has been closed" )
+		return
+	}
+
+	req := &Broker_msg {
+		host: 	host,
+		cmd:	cmd,
+		ctx:	ctx,
+	}
+
+	return b.run_req( req )
+}
+
+/*
+	NBRun_cmd_ctx is the same as NBRun_cmd except that the remote command is killed if the
+	context is cancelled, or its deadline passes, before the command completes.
+*/
+func ( b *Broker ) NBRun_cmd_ctx( ctx context.Context, host string, cmd string,  uid int, uch chan *Broker_msg  ) ( err error ) {
+	if b == nil || b.was_closed {
+		err = fmt.Errorf( "nbrun_cmd: broker pointer was nil, or broker has been closed" )
+		return
+	}
+
+	req := &Broker_msg {
+		host: 	host,
+		cmd:	cmd,
+		id:		uid,
+		resp_ch:	uch,
+		ctx:	ctx,
+	}
+
+	b.init_ch <- req						// send request to initiator queue
+
+	return
+}
@@ -0,0 +1,111 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	timeout_test.go
+	Abstract:	Tests that a command is killed when its deadline passes, when it is
+				cancelled, and when the broker's default timeout expires, using a
+				scripted command which would otherwise run for a long time.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/att/gopkgs/ssh_broker/sshtest"
+)
+
+func TestTimeout( t *testing.T ) {
+	killed := make( chan string, 10 )
+	script := sshtest.Script_handler( map[string]*sshtest.Reply{
+		"sleep":	{ Stdout: "awake\n", Delay: time.Minute },
+		"quick":	{ Stdout: "done\n" },
+	} )
+	handler := func( c *sshtest.Cmd ) ( int ) {
+		status := script( c )
+		if status == 137 {
+			killed <- c.Cmd
+		}
+		return status
+	}
+
+	b, srv, done := mk_test_broker( t, &sshtest.Server_opts{ Handler: handler } )
+	defer done()
+
+	if _, _, err := b.Run_cmd( srv.Addr(), "quick" ); err != nil {				// connect before the timed runs
+		t.Fatalf( "run_cmd: %s", err )
+	}
+
+	expect_killed := func( what string ) {
+		select {
+			case <- killed:
+
+			case <- time.After( 2 * time.Second ):
+				t.Errorf( "%s: remote command was not killed", what )
+		}
+	}
+
+	uch := make( chan *Broker_msg, 1 )
+	ctx, cancel := context.WithTimeout( context.Background(), 100 * time.Millisecond )
+	start := time.Now()
+	b.NBRun_cmd_ctx( ctx, srv.Addr(), "sleep", 1, uch )
+	msg := <- uch
+	cancel()
+	if _, _, _, err := msg.Get_results(); err == nil || !msg.Timed_out() || msg.Cancelled() {
+		t.Errorf( "deadline: expected timed out error, got %v (timed out %v)", err, msg.Timed_out() )
+	}
+	if elapsed := time.Since( start ); elapsed > kill_wait {
+		t.Errorf( "deadline: command took %s to stop", elapsed )
+	}
+	expect_killed( "deadline" )
+
+	ctx, cancel = context.WithCancel( context.Background() )
+	b.NBRun_cmd_ctx( ctx, srv.Addr(), "sleep", 2, uch )
+	time.Sleep( 100 * time.Millisecond )
+	cancel()
+	msg = <- uch
+	if _, _, _, err := msg.Get_results(); err == nil || !msg.Cancelled() || msg.Timed_out() {
+		t.Errorf( "cancel: expected cancelled error, got %v (cancelled %v)", err, msg.Cancelled() )
+	}
+	expect_killed( "cancel" )
+
+	b.Set_cmd_timeout( 100 * time.Millisecond )
+	if _, _, err := b.Run_cmd( srv.Addr(), "sleep" ); err == nil {
+		t.Errorf( "default timeout: expected error" )
+	}
+	expect_killed( "default timeout" )
+
+	stdout, _, err := b.Run_cmd( srv.Addr(), "quick" )					// connection is still good
+	if err != nil || stdout.String() != "done\n" {
+		t.Errorf( "run after timeouts: %q %v", stdout, err )
+	}
+	if n := srv.Nconns(); n != 1 {
+		t.Errorf( "expected the connection to be kept, server saw %d", n )
+	}
+
+	ctx, cancel = context.WithCancel( context.Background() )
+	cancel()
+	if _, _, err = b.Run_cmd_ctx( ctx, srv.Addr(), "quick" ); err == nil {		// cancelled before it was run
+		t.Errorf( "expected error for a request cancelled before it started" )
+	}
+}
@@ -0,0 +1,177 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	verbatim.go
+	Abstract: 	Verbatim script transport. Rather than feeding the script to the interpreter
+				on standard input (with comments and blank lines removed) the script is
+				copied unaltered, using sftp, into a newly created private directory on the
+				remote host and executed from there.  $0 is the remote path of the script,
+				the parameters are passed on the command line as usual, and standard input
+				is available to the script.  If an environment file is given it is copied
+				too and sourced (exported) before the script is exec'd, so a POSIX shell is
+				assumed to be the user's login shell.  ARGV0 is set to the local script
+				name for scripts written for the stdin transport.
+
+				The directory is removed when the script completes, including when it is
+				killed because of a deadline, provided the host can still be reached.
+
+	Date: 		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// --------------------------------------------------------------------------------------------------
+
+/*
+	Quote the string for the remote shell.
+*/
+func sh_quote( s string ) ( string ) {
+	return "'" + strings.Replace( s, "'", `'\''`, -1 ) + "'"
+}
+
+/*
+	Create a uniquely named directory, accessible only by the user, in the remote tmp directory.
+*/
+func mk_remote_tmp( sc *sftp.Client, tmp_dir string ) ( dir string, err error ) {
+	if tmp_dir == "" {
+		tmp_dir = "/tmp"
+	}
+
+	rb := make( []byte, 8 )
+	if _, err = rand.Read( rb ); err != nil {
+		return
+	}
+
+	dir = path.Join( tmp_dir, "ssh_broker." + hex.EncodeToString( rb ) )
+	if err = sc.Mkdir( dir ); err != nil {					// fails if it exists, so it is ours
+		return "", fmt.Errorf( "unable to create remote directory %s: %s", dir, err )
+	}
+
+	return dir, sc.Chmod( dir, 0700 )
+}
+
+/*
+	Copy the local file to the remote name and give it the mode.
+*/
+func put_verbatim( sc *sftp.Client, lname string, rname string, mode os.FileMode ) ( err error ) {
+	linfo, err := os.Stat( lname )
+	if err != nil {
+		return
+	}
+
+	if _, err = put_file( sc, lname, linfo, rname ); err != nil {
+		return
+	}
+
+	return sc.Chmod( rname, mode )
+}
+
+/*
+	Remove the remote directory and the files we put there.
+*/
+func rm_remote_tmp( sc *sftp.Client, dir string ) {
+	ents, err := sc.ReadDir( dir )
+	if err == nil {
+		for _, ent := range ents {
+			sc.Remove( path.Join( dir, ent.Name() ) )
+		}
+	}
+	sc.RemoveDirectory( dir )
+}
+
+/*
+	Run the script in the request using the verbatim transport.
+*/
+func ( b *Broker ) roar_verbatim( req *Broker_msg ) ( err error ) {
+	pname, err := find_file( req.sname )
+	if err != nil {
+		req.fail = FAIL_SETUP
+		return
+	}
+
+	b.audit_script( req, pname )
+
+	ename := ""
+	if req.env != "" {
+		if ename, err = find_file( req.env ); err != nil {
+			req.fail = FAIL_SETUP
+			return fmt.Errorf( "could not find environment file: %s: %s", req.env, err )
+		}
+	}
+
+	sc, err := b.host_sftp( req.host )
+	if err != nil {
+		return
+	}
+
+	dir, err := mk_remote_tmp( sc, req.tmp_dir )
+	if err != nil {
+		return
+	}
+	defer func() {
+		if sc, err := b.host_sftp( req.host ); err == nil {		// the connection might have been dropped (timeout) so get it again
+			rm_remote_tmp( sc, dir )
+		}
+	}()
+
+	rscript := path.Join( dir, filepath.Base( pname ) )
+	if err = put_verbatim( sc, pname, rscript, 0700 ); err != nil {
+		return fmt.Errorf( "unable to copy script to %s: %s", req.host, err )
+	}
+
+	cmd := "ARGV0=" + sh_quote( pname ) + " exec " + sh_quote( rscript ) + " " + req.parms
+	if ename != "" {
+		renv := path.Join( dir, ".env" )						// dot name can't collide with the script
+		if err := put_verbatim( sc, ename, renv, 0600 ); err != nil {
+			return fmt.Errorf( "unable to copy environment file to %s: %s", req.host, err )
+		}
+		cmd = "set -a; . " + sh_quote( renv ) + "; set +a; " + cmd
+	}
+
+	sess, c, err := b.session2( req.host )
+	if err != nil {
+		return
+	}
+	defer c.busy( -1 )
+	defer sess.Close()
+
+	if err = req.setup_session( sess ); err != nil {
+		return
+	}
+
+	var flush func()
+	sess.Stdout, sess.Stderr, flush = req.out_writers()
+	defer flush()
+	sess.Stdin = req.stdin
+
+	return b.run( req, sess, cmd )
+}
@@ -0,0 +1,73 @@
+// vi: sw=4 ts=4:
+/*
+ ---------------------------------------------------------------------------
+   Copyright (c) 2013-2015 AT&T Intellectual Property
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at:
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+ ---------------------------------------------------------------------------
+*/
+
+/*
+	Mnemonic:	verbatim_test.go
+	Abstract:	Tests the remote temporary directory handling of the verbatim transport
+				using the pipe based sftp server, and the shell quoting.
+	Date:		19 October 2026
+*/
+
+package ssh_broker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerbatim_tmp( t *testing.T ) {
+	dir, err := ioutil.TempDir( "", "verbatim" )
+	if err != nil {
+		t.Fatal( err )
+	}
+	defer os.RemoveAll( dir )
+
+	script := "#!/bin/ksh\n\n# comment kept\ncat <<endKat\n  indented\nendKat\n"
+	lname := filepath.Join( dir, "heredoc.ksh" )
+	ioutil.WriteFile( lname, []byte( script ), 0644 )
+
+	sc, done := mk_test_sftp( t )
+	defer done()
+
+	rdir, err := mk_remote_tmp( sc, dir )
+	if err != nil {
+		t.Fatalf( "mk_remote_tmp failed: %s", err )
+	}
+
+	rname := filepath.Join( rdir, "heredoc.ksh" )
+	if err = put_verbatim( sc, lname, rname, 0700 ); err != nil {
+		t.Fatalf( "put failed: %s", err )
+	}
+	b, _ := ioutil.ReadFile( rname )
+	info, _ := os.Stat( rname )
+	if string( b ) != script || info.Mode().Perm() != 0700 {
+		t.Errorf( "script altered: %q %v", b, info.Mode() )
+	}
+
+	rm_remote_tmp( sc, rdir )
+	if _, err = os.Stat( rdir ); !os.IsNotExist( err ) {
+		t.Errorf( "remote directory not removed: %v", err )
+	}
+
+	if q := sh_quote( "it's" ); q != `'it'\''s'` {
+		t.Errorf( "bad quoting: %s", q )
+	}
+}
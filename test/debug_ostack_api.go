@@ -28,6 +28,8 @@
 
 	Mod:		11 Jul 2015 : Changes to support new crack function for v2.
 				17 Dec 2015 : Test L3 network list generation.
+				19 Oct 2026 : Map, host, gateway and token listing is now supported by
+							cmd/ostack-inventory; this remains as a developer test driver.
 */
 
 package main
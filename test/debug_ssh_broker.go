@@ -25,6 +25,7 @@
 	Date:		23 December 2014
 	Mods:		              Fixed fmt statement in printf.
 				21 Sep 2015 - Added repeat function.
+				19 Oct 2026 - Show the exit status and failure class of asynch requests.
 */
 
 package main
@@ -98,7 +99,8 @@ func handle_responses( ch chan int, rch chan *ssh_broker.Broker_msg ) {
 		host, script, id := msg.Get_info( )
 		fmt.Fprintf( os.Stderr, "received response host=%s script=%s id=%d\n", host, script, id )
 		if err != nil {
-			fmt.Fprintf( os.Stderr, "command failed: %s:  %s \n", host, err )
+			code, sig := msg.Get_exit_status( )
+			fmt.Fprintf( os.Stderr, "command failed: %s:  %s (%s failure, exit=%d signal=%s)\n", host, err, ssh_broker.Fail_class_name( msg.Get_fail_class() ), code, sig )
 			fmt.Fprintf( os.Stderr, "%s", stderr.String() )
 		} else {
 			fmt.Fprintf( os.Stderr, "command was successful:\n" )